@@ -14,6 +14,7 @@ export interface Wallpaper {
   tags?: Tag[];
   created_at: string;
   updated_at: string;
+  score?: number;
 }
 
 export interface WallpaperResponse {
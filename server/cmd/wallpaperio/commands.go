@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"wallpaperio/server/internal/domain/models/dto"
+	"wallpaperio/server/internal/services"
+)
+
+// commands holds the services used by maintenance subcommands
+type commands struct {
+	reconcileSvc    *services.ReconcileService
+	featureModelSvc *services.FeatureModelService
+	clusterSvc      *services.ClusterService
+	partitionSvc    *services.PartitionService
+	variantSvc      *services.VariantService
+	metadataSvc     *services.MetadataService
+	colorSvc        *services.ColorService
+}
+
+func (c *commands) run(name string, args []string) error {
+	switch name {
+	case "reconcile":
+		return c.reconcile(args)
+	case "reembed":
+		return c.reembed(args)
+	case "cluster":
+		return c.cluster(args)
+	case "migrate-partitions":
+		return c.migratePartitions(args)
+	case "backfill-variants":
+		return c.backfillVariants(args)
+	case "backfill-metadata":
+		return c.backfillMetadata(args)
+	case "backfill-colors":
+		return c.backfillColors(args)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+// reconcile reports (and with -repair fixes) mismatches between wallpapers
+// and stored feature vectors
+func (c *commands) reconcile(args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "delete orphan vectors and re-extract missing features")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := c.reconcileSvc.Reconcile(*repair)
+	if err != nil {
+		return err
+	}
+	return printJSON(report)
+}
+
+// reembed backfills a new feature model version and switches to it
+func (c *commands) reembed(args []string) error {
+	fs := flag.NewFlagSet("reembed", flag.ExitOnError)
+	var req dto.ReembedRequest
+	fs.StringVar(&req.Name, "name", "", "feature model name")
+	fs.StringVar(&req.Version, "version", "", "feature model version")
+	fs.IntVar(&req.Dimension, "dim", 0, "feature vector dimension")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	model, err := c.featureModelSvc.Reembed(req)
+	if model != nil {
+		if printErr := printJSON(model); printErr != nil && err == nil {
+			err = printErr
+		}
+	}
+	return err
+}
+
+// cluster groups wallpapers into visual clusters
+func (c *commands) cluster(args []string) error {
+	fs := flag.NewFlagSet("cluster", flag.ExitOnError)
+	k := fs.Int("k", 0, "number of clusters (0 picks one from the catalogue size)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	run, err := c.clusterSvc.Run(*k)
+	if run != nil {
+		if printErr := printJSON(run); printErr != nil && err == nil {
+			err = printErr
+		}
+	}
+	return err
+}
+
+// migratePartitions moves feature vectors into their category's partition
+func (c *commands) migratePartitions(args []string) error {
+	fs := flag.NewFlagSet("migrate-partitions", flag.ExitOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := c.partitionSvc.Migrate()
+	if report != nil {
+		if printErr := printJSON(report); printErr != nil && err == nil {
+			err = printErr
+		}
+	}
+	return err
+}
+
+// backfillVariants renders missing and outdated image variants
+func (c *commands) backfillVariants(args []string) error {
+	fs := flag.NewFlagSet("backfill-variants", flag.ExitOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	status, err := c.variantSvc.Backfill()
+	if printErr := printJSON(status); printErr != nil && err == nil {
+		err = printErr
+	}
+	return err
+}
+
+// backfillMetadata reads the dimensions, size and type of wallpapers
+// created before they were recorded
+func (c *commands) backfillMetadata(args []string) error {
+	fs := flag.NewFlagSet("backfill-metadata", flag.ExitOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	status, err := c.metadataSvc.Backfill()
+	if printErr := printJSON(status); printErr != nil && err == nil {
+		err = printErr
+	}
+	return err
+}
+
+// backfillColors extracts the palette of wallpapers without one
+func (c *commands) backfillColors(args []string) error {
+	fs := flag.NewFlagSet("backfill-colors", flag.ExitOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	status, err := c.colorSvc.Backfill()
+	if printErr := printJSON(status); printErr != nil && err == nil {
+		err = printErr
+	}
+	return err
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
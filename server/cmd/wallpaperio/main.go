@@ -2,15 +2,19 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"github.com/joho/godotenv"
 
 	"wallpaperio/server/internal/config"
 	"wallpaperio/server/internal/delivery/http"
 	"wallpaperio/server/internal/handlers"
+	"wallpaperio/server/internal/middleware"
 	"wallpaperio/server/internal/services"
 	"wallpaperio/server/internal/services/database"
+	"wallpaperio/server/internal/services/storage"
 	"wallpaperio/server/pkg/auth"
+	"wallpaperio/server/pkg/image_generator"
 
 	"github.com/gin-gonic/gin"
 )
@@ -32,28 +36,108 @@ func main() {
 	// Initialize services
 	googleAuth := auth.NewGoogleAuth(&cfg.Google)
 	jwtService := auth.NewJWTService(cfg.JWT.Secret)
-	categorySvc := services.NewCategoryService(db.DB, cfg.Server.GeneratorImagesHostURL)
+	mediaStorage, err := storage.New(&cfg.Storage, cfg.Server.PublicURL, cfg.Server.InternalURL)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	// A bucket anyone can read would make signed links pointless
+	if cfg.SignedURLs.Enabled && cfg.Storage.Type == storage.TypeS3 && cfg.Storage.S3.PublicRead {
+		log.Fatal("S3_PUBLIC_READ must be off when SIGNED_URLS is enabled")
+	}
+	signer, err := services.NewURLSigner(mediaStorage, &cfg.SignedURLs, cfg.Server.PublicURL)
+	if err != nil {
+		log.Fatalf("Failed to initialize URL signing: %v", err)
+	}
+	categorySvc := services.NewCategoryService(db.DB, mediaStorage, cfg.Server.GeneratorImagesHostURL)
 	tagSvc := services.NewTagService(db.DB)
 	featureSvc := services.NewFeatureService()
-	wallpaperSvc, err := services.NewWallpaperService(db.DB, tagSvc, featureSvc)
+	newVectorStore, err := services.NewVectorStoreFactory(&cfg.VectorStore)
 	if err != nil {
-		log.Fatalf("Failed to initialize wallpaper service: %v", err)
+		log.Fatalf("Failed to initialize vector store: %v", err)
+	}
+	featureModelSvc, err := services.NewFeatureModelService(db.DB, featureSvc, newVectorStore, &cfg.FeatureModel)
+	if err != nil {
+		log.Fatalf("Failed to initialize feature models: %v", err)
+	}
+	defer featureModelSvc.Close()
+	duplicateSvc := services.NewDuplicateService(db.DB, featureModelSvc, &cfg.Duplicate)
+	imageFetcher := services.NewImageFetcher(mediaStorage, cfg.Server.GeneratorImagesHostURL, cfg.Storage.MaxImagePixels)
+	variantSvc := services.NewVariantService(db.DB, mediaStorage, imageFetcher, &cfg.Variants)
+	colorSvc := services.NewColorService(db.DB, imageFetcher)
+	wallpaperSvc := services.NewWallpaperService(db.DB, tagSvc, featureSvc, featureModelSvc, duplicateSvc, variantSvc, colorSvc, mediaStorage, imageFetcher)
+	reconcileSvc := services.NewReconcileService(db.DB, featureSvc, featureModelSvc)
+	clusterSvc := services.NewClusterService(db.DB, tagSvc, featureModelSvc)
+	partitionSvc := services.NewPartitionService(db.DB, featureModelSvc)
+	imageSearchSvc := services.NewImageSearchService(featureSvc, featureModelSvc, wallpaperSvc)
+	metadataSvc := services.NewMetadataService(db.DB, imageFetcher)
+	uploadSvc := services.NewUploadService(wallpaperSvc, mediaStorage)
+	downloadSvc := services.NewDownloadService(db.DB, mediaStorage, imageFetcher, signer, cfg.Downloads.Sizes)
+
+	// Run a maintenance command instead of the server if one was given
+	if len(os.Args) > 1 {
+		cmds := &commands{
+			reconcileSvc:    reconcileSvc,
+			featureModelSvc: featureModelSvc,
+			clusterSvc:      clusterSvc,
+			partitionSvc:    partitionSvc,
+			variantSvc:      variantSvc,
+			metadataSvc:     metadataSvc,
+			colorSvc:        colorSvc,
+		}
+		if err := cmds.run(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatalf("Command %s failed: %v", os.Args[1], err)
+		}
+		return
 	}
 
 	// Initialize handlers
 	authHandler := handlers.NewGoogleAuthHandler(googleAuth, db, jwtService)
 	imageCfg := config.LoadImageGeneratorConfig()
-	imageHandler := handlers.NewImageHandler(imageCfg, db.DB)
-	categoryHandler := handlers.NewCategoryHandler(categorySvc)
-	wallpaperHandler := handlers.NewWallpaperHandler(wallpaperSvc, tagSvc, db.DB)
+	generatorClient := image_generator.NewClient(imageCfg.URL)
+	quotaSvc := services.NewQuotaService(db.DB, imageCfg.Quotas)
+	generationSvc := services.NewGenerationService(db.DB, generatorClient, wallpaperSvc, quotaSvc, imageCfg.AutoPublish)
+	generationPoller := services.NewGenerationPoller(generationSvc, imageCfg)
+	generationPoller.Start()
+	defer generationPoller.Stop()
+	imageHandler := handlers.NewImageHandler(generatorClient, generationSvc, cfg.Server.FrontendOrigins)
+	categoryHandler := handlers.NewCategoryHandler(categorySvc, signer, cfg.Storage.MaxUploadBytes)
+	wallpaperHandler := handlers.NewWallpaperHandler(wallpaperSvc, tagSvc, db.DB, signer)
+	reconcileHandler := handlers.NewReconcileHandler(reconcileSvc)
+	featureModelHandler := handlers.NewFeatureModelHandler(featureModelSvc)
+	imageSearchHandler := handlers.NewImageSearchHandler(imageSearchSvc, signer)
+	duplicateHandler := handlers.NewDuplicateHandler(duplicateSvc, signer)
+	clusterHandler := handlers.NewClusterHandler(clusterSvc, signer)
+	variantHandler := handlers.NewVariantHandler(variantSvc)
+	metadataHandler := handlers.NewMetadataHandler(metadataSvc)
+	colorHandler := handlers.NewColorHandler(colorSvc)
+	uploadHandler := handlers.NewUploadHandler(uploadSvc, signer, cfg.Storage.MaxUploadBytes)
+	downloadHandler := handlers.NewDownloadHandler(downloadSvc)
+	mediaHandler := handlers.NewMediaHandler(mediaStorage, signer, cfg.Cache.Media)
 
 	// Initialize router
-	router := gin.Default()
-	appRouter := http.NewRouter(jwtService, cfg.Server.APIKey)
+	router := gin.New()
+	router.Use(middleware.Logger(), gin.Recovery())
+	appRouter := http.NewRouter(jwtService, cfg.Server.APIKey, &cfg.Cache)
+	// Local storage is served by this server and S3 objects are fetched
+	// from the bucket, unless links are signed: then this server serves
+	// both and checks the signatures
+	_, local := mediaStorage.(*storage.Local)
+	appRouter.ServeMedia(local || signer.Enabled())
 	appRouter.AddHandler("auth", authHandler)
 	appRouter.AddHandler("image", imageHandler)
 	appRouter.AddHandler("category", categoryHandler)
 	appRouter.AddHandler("wallpaper", wallpaperHandler)
+	appRouter.AddHandler("reconcile", reconcileHandler)
+	appRouter.AddHandler("feature_model", featureModelHandler)
+	appRouter.AddHandler("image_search", imageSearchHandler)
+	appRouter.AddHandler("duplicate", duplicateHandler)
+	appRouter.AddHandler("cluster", clusterHandler)
+	appRouter.AddHandler("upload", uploadHandler)
+	appRouter.AddHandler("variant", variantHandler)
+	appRouter.AddHandler("metadata", metadataHandler)
+	appRouter.AddHandler("color", colorHandler)
+	appRouter.AddHandler("download", downloadHandler)
+	appRouter.AddHandler("media", mediaHandler)
 	appRouter.Setup(router)
 
 	// Start server
@@ -1,20 +1,41 @@
 package config
 
 import (
+	"fmt"
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	Google   GoogleConfig
-	JWT      JWTConfig
+	Server       ServerConfig
+	Database     DatabaseConfig
+	Google       GoogleConfig
+	JWT          JWTConfig
+	VectorStore  VectorStoreConfig
+	FeatureModel FeatureModelConfig
+	Duplicate    DuplicateConfig
+	Storage      StorageConfig
+	Variants     VariantConfig
+	Downloads    DownloadConfig
+	SignedURLs   SignedURLConfig
+	Cache        CacheConfig
 }
 
 type ServerConfig struct {
 	Port                   string
 	GeneratorImagesHostURL string
 	APIKey                 string
+	// InternalURL is where the generator service can reach this server,
+	// used to hand it stored images
+	InternalURL string
+	// PublicURL is where clients reach this server, used for stored images
+	PublicURL string
+	// FrontendOrigins are the origins browsers may open WebSockets from;
+	// when empty only pages served from this server's host may
+	FrontendOrigins []string
 }
 
 type DatabaseConfig struct {
@@ -36,12 +57,118 @@ type JWTConfig struct {
 	Secret string
 }
 
+// VectorStoreConfig selects where wallpaper feature vectors are kept.
+// Type is "milvus" (default) or "memory"; Path optionally persists the
+// in-memory store to a file.
+type VectorStoreConfig struct {
+	Type string
+	Path string
+}
+
+// FeatureModelConfig names the feature model registered as active on first
+// start. Later model switches go through re-embedding.
+type FeatureModelConfig struct {
+	Name    string
+	Version string
+}
+
+// DuplicateConfig controls near-duplicate detection on ingest. Mode is
+// "reject" (default), "flag" or "off"; Threshold is the cosine similarity at
+// which two images count as duplicates.
+type DuplicateConfig struct {
+	Mode      string
+	Threshold float32
+}
+
+// StorageConfig controls where images are stored. Type is "local" (default)
+// or "s3". LocalDir is served under /media; MaxUploadBytes caps the size of
+// an uploaded original and MaxImagePixels the width times height of any
+// image the server decodes.
+type StorageConfig struct {
+	Type           string
+	LocalDir       string
+	MaxUploadBytes int64
+	MaxImagePixels int64
+	S3             S3Config
+}
+
+// S3Config points at an S3-compatible bucket. PublicURL overrides the base
+// URL of objects handed to clients, e.g. a CDN or reverse proxy in front of
+// the bucket; PublicRead makes a newly created bucket readable anonymously.
+type S3Config struct {
+	Endpoint   string
+	AccessKey  string
+	SecretKey  string
+	Bucket     string
+	Region     string
+	UseSSL     bool
+	PublicURL  string
+	PublicRead bool
+}
+
+// VariantConfig lists the image variants the server renders for every
+// wallpaper, read from IMAGE_VARIANTS as comma separated
+// "name:width:format:quality" entries
+type VariantConfig struct {
+	Specs []VariantSpec
+}
+
+// VariantSpec is one rendered variant: Width is the largest width in pixels,
+// Format is "jpeg", "webp" or "avif" and Quality the encoder's quality
+type VariantSpec struct {
+	Name    string
+	Width   int
+	Format  string
+	Quality int
+}
+
+// DownloadConfig lists the device resolutions whose download renditions are
+// cached, read from DOWNLOAD_SIZES as comma separated "WIDTHxHEIGHT"
+// entries. Only these are cached, so the renditions stored per wallpaper
+// stay bounded; other sizes are rendered per request.
+type DownloadConfig struct {
+	Sizes []DownloadSize
+}
+
+type DownloadSize struct {
+	Width  int
+	Height int
+}
+
+// SignedURLConfig gates full-size images behind expiring links. When
+// Enabled, image and medium URLs in API responses are signed with Secret and
+// valid for TTL, and stored images are only served with a valid signature.
+// Thumbnails stay public.
+type SignedURLConfig struct {
+	Enabled bool
+	Secret  string
+	TTL     time.Duration
+}
+
+// CacheConfig is the Cache-Control header sent per route group; empty sends
+// none. Media applies to stored images, which never change under a key;
+// signed links are only cached until they expire.
+type CacheConfig struct {
+	Media      string
+	Wallpapers string
+	Categories string
+}
+
+// defaultVariants keeps the thumb and medium images the clients expect
+const defaultVariants = "thumb:400:jpeg:85,medium:1280:jpeg:85"
+
+// defaultDownloadSizes covers common phone, tablet and desktop screens
+const defaultDownloadSizes = "1080x1920,1080x2400,1170x2532,1179x2556,1290x2796,1440x3200,1620x2160,2048x2732,1366x768,1920x1080,2560x1440,3840x2160"
+
 func LoadConfig() *Config {
 	return &Config{
 		Server: ServerConfig{
 			Port:                   getEnv("SERVER_PORT", "8080"),
 			GeneratorImagesHostURL: getEnv("SERVER_IMAGES_HOST_URL", ""),
 			APIKey:                 getEnv("API_KEY", ""),
+			InternalURL:            getEnv("SERVER_INTERNAL_URL", "http://localhost:"+getEnv("SERVER_PORT", "8080")),
+			PublicURL:              getEnv("SERVER_PUBLIC_URL", "http://localhost:"+getEnv("SERVER_PORT", "8080")),
+			FrontendOrigins:        getEnvList("FRONTEND_ORIGINS"),
 		},
 		Database: DatabaseConfig{
 			Host:     getEnv("DB_HOST", "localhost"),
@@ -59,6 +186,50 @@ func LoadConfig() *Config {
 		JWT: JWTConfig{
 			Secret: getEnv("JWT_SECRET", ""),
 		},
+		VectorStore: VectorStoreConfig{
+			Type: getEnv("VECTOR_STORE", "milvus"),
+			Path: getEnv("VECTOR_STORE_PATH", ""),
+		},
+		FeatureModel: FeatureModelConfig{
+			Name:    getEnv("FEATURE_MODEL_NAME", "efficientnetv2-b0"),
+			Version: getEnv("FEATURE_MODEL_VERSION", "1"),
+		},
+		Duplicate: DuplicateConfig{
+			Mode:      getEnv("DUPLICATE_MODE", "reject"),
+			Threshold: getEnvFloat("DUPLICATE_THRESHOLD", 0.97),
+		},
+		Storage: StorageConfig{
+			Type:           getEnv("STORAGE_TYPE", "local"),
+			LocalDir:       getEnv("STORAGE_LOCAL_DIR", "media"),
+			MaxUploadBytes: getEnvInt64("MAX_UPLOAD_BYTES", 25<<20),
+			MaxImagePixels: getEnvInt64("MAX_IMAGE_PIXELS", 50_000_000),
+			S3: S3Config{
+				Endpoint:   getEnv("S3_ENDPOINT", "localhost:9000"),
+				AccessKey:  getEnv("S3_ACCESS_KEY", ""),
+				SecretKey:  getEnv("S3_SECRET_KEY", ""),
+				Bucket:     getEnv("S3_BUCKET", "wallpaperio"),
+				Region:     getEnv("S3_REGION", ""),
+				UseSSL:     getEnvBool("S3_USE_SSL", false),
+				PublicURL:  getEnv("S3_PUBLIC_URL", ""),
+				PublicRead: getEnvBool("S3_PUBLIC_READ", false),
+			},
+		},
+		Variants: VariantConfig{
+			Specs: getEnvVariants("IMAGE_VARIANTS", defaultVariants),
+		},
+		Downloads: DownloadConfig{
+			Sizes: getEnvDownloadSizes("DOWNLOAD_SIZES", defaultDownloadSizes),
+		},
+		SignedURLs: SignedURLConfig{
+			Enabled: getEnvBool("SIGNED_URLS", false),
+			Secret:  getEnv("URL_SIGNING_SECRET", ""),
+			TTL:     getEnvDuration("SIGNED_URL_TTL", time.Hour),
+		},
+		Cache: CacheConfig{
+			Media:      getEnv("CACHE_CONTROL_MEDIA", "public, max-age=31536000, immutable"),
+			Wallpapers: getEnv("CACHE_CONTROL_WALLPAPERS", "no-cache"),
+			Categories: getEnv("CACHE_CONTROL_CATEGORIES", "public, max-age=300"),
+		},
 	}
 }
 
@@ -68,3 +239,134 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvFloat(key string, defaultValue float32) float32 {
+	if value, exists := os.LookupEnv(key); exists {
+		if f, err := strconv.ParseFloat(value, 32); err == nil {
+			return float32(f)
+		}
+	}
+	return defaultValue
+}
+
+func getEnvInt64(key string, defaultValue int64) int64 {
+	if value, exists := os.LookupEnv(key); exists {
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultValue
+}
+
+func getEnvVariants(key, defaultValue string) []VariantSpec {
+	specs, err := parseVariants(getEnv(key, defaultValue))
+	if err != nil {
+		log.Printf("Invalid %s, using %q: %v", key, defaultValue, err)
+		specs, _ = parseVariants(defaultValue)
+	}
+	return specs
+}
+
+func parseVariants(value string) ([]VariantSpec, error) {
+	var specs []VariantSpec
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 4 || parts[0] == "" {
+			return nil, fmt.Errorf("variant %q is not name:width:format:quality", entry)
+		}
+		width, err := strconv.Atoi(parts[1])
+		if err != nil || width <= 0 {
+			return nil, fmt.Errorf("variant %q has an invalid width", entry)
+		}
+		format := strings.ToLower(parts[2])
+		switch format {
+		case "jpeg", "webp", "avif":
+		default:
+			return nil, fmt.Errorf("variant %q has unknown format %q; use jpeg, webp or avif", entry, parts[2])
+		}
+		quality, err := strconv.Atoi(parts[3])
+		if err != nil || quality < 1 || quality > 100 {
+			return nil, fmt.Errorf("variant %q has an invalid quality", entry)
+		}
+		specs = append(specs, VariantSpec{
+			Name:    parts[0],
+			Width:   width,
+			Format:  format,
+			Quality: quality,
+		})
+	}
+	return specs, nil
+}
+
+func getEnvDownloadSizes(key, defaultValue string) []DownloadSize {
+	sizes, err := parseDownloadSizes(getEnv(key, defaultValue))
+	if err != nil {
+		log.Printf("Invalid %s, using %q: %v", key, defaultValue, err)
+		sizes, _ = parseDownloadSizes(defaultValue)
+	}
+	return sizes
+}
+
+const (
+	// MaxDownloadDimension bounds each side of a download size
+	MaxDownloadDimension = 8192
+	// MaxDownloadPixels bounds the area of a download size to 8K UHD
+	MaxDownloadPixels = 7680 * 4320
+)
+
+func parseDownloadSizes(value string) ([]DownloadSize, error) {
+	var sizes []DownloadSize
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		w, h, ok := strings.Cut(strings.ToLower(entry), "x")
+		width, errW := strconv.Atoi(w)
+		height, errH := strconv.Atoi(h)
+		if !ok || errW != nil || errH != nil {
+			return nil, fmt.Errorf("download size %q is not WIDTHxHEIGHT", entry)
+		}
+		if width < 1 || height < 1 || width > MaxDownloadDimension || height > MaxDownloadDimension {
+			return nil, fmt.Errorf("download size %q must be between 1 and %d on each side", entry, MaxDownloadDimension)
+		}
+		if width*height > MaxDownloadPixels {
+			return nil, fmt.Errorf("download size %q must cover at most %d pixels", entry, MaxDownloadPixels)
+		}
+		sizes = append(sizes, DownloadSize{Width: width, Height: height})
+	}
+	return sizes, nil
+}
+
+// getEnvList reads a comma separated list, leaving out empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
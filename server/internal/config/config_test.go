@@ -0,0 +1,101 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVariants(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []VariantSpec
+		wantErr bool
+	}{
+		{
+			name:  "defaults",
+			value: defaultVariants,
+			want: []VariantSpec{
+				{Name: "thumb", Width: 400, Format: "jpeg", Quality: 85},
+				{Name: "medium", Width: 1280, Format: "jpeg", Quality: 85},
+			},
+		},
+		{
+			name:  "webp and avif, format case-insensitive",
+			value: "thumb:400:WebP:80, large:2560:avif:60",
+			want: []VariantSpec{
+				{Name: "thumb", Width: 400, Format: "webp", Quality: 80},
+				{Name: "large", Width: 2560, Format: "avif", Quality: 60},
+			},
+		},
+		{name: "empty entries skipped", value: ",thumb:400:jpeg:85,,", want: []VariantSpec{{Name: "thumb", Width: 400, Format: "jpeg", Quality: 85}}},
+		{name: "empty", value: "", want: nil},
+		{name: "missing quality", value: "thumb:400:jpeg", wantErr: true},
+		{name: "empty name", value: ":400:jpeg:85", wantErr: true},
+		{name: "zero width", value: "thumb:0:jpeg:85", wantErr: true},
+		{name: "non-numeric width", value: "thumb:wide:jpeg:85", wantErr: true},
+		{name: "unknown format", value: "thumb:400:png:85", wantErr: true},
+		{name: "quality too low", value: "thumb:400:jpeg:0", wantErr: true},
+		{name: "quality too high", value: "thumb:400:jpeg:101", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseVariants(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseVariants(%q) = %v, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseVariants(%q) failed: %v", tt.value, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseVariants(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDownloadSizes(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []DownloadSize
+		wantErr bool
+	}{
+		{
+			name:  "sizes",
+			value: "1080x1920, 2560X1440",
+			want:  []DownloadSize{{Width: 1080, Height: 1920}, {Width: 2560, Height: 1440}},
+		},
+		{name: "longest side", value: "8192x100", want: []DownloadSize{{Width: 8192, Height: 100}}},
+		{name: "8K UHD", value: "7680x4320", want: []DownloadSize{{Width: 7680, Height: 4320}}},
+		{name: "empty entries skipped", value: ",1x1,", want: []DownloadSize{{Width: 1, Height: 1}}},
+		{name: "empty", value: "", want: nil},
+		{name: "missing height", value: "1080", wantErr: true},
+		{name: "wrong separator", value: "1080*1920", wantErr: true},
+		{name: "non-numeric", value: "widexhigh", wantErr: true},
+		{name: "zero", value: "0x1920", wantErr: true},
+		{name: "too wide", value: "8193x1080", wantErr: true},
+		{name: "too high", value: "1080x8193", wantErr: true},
+		{name: "too many pixels", value: "8192x8192", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDownloadSizes(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseDownloadSizes(%q) = %v, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDownloadSizes(%q) failed: %v", tt.value, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDownloadSizes(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
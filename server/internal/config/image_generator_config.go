@@ -1,24 +1,97 @@
 package config
 
 import (
+	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type ImageGeneratorConfig struct {
 	URL       string
 	ImagesDir string
 	BaseURL   string
+	// AutoPublish makes completed generations wallpapers unless the request
+	// says otherwise
+	AutoPublish bool
+	// PollInterval is how often pending generation tasks are checked at
+	// first; the interval doubles per check up to PollMaxInterval
+	PollInterval    time.Duration
+	PollMaxInterval time.Duration
+	// Timeout is how long a task may stay pending before its job fails
+	Timeout time.Duration
+	// Quotas caps generations per role, read from GENERATION_QUOTAS as comma
+	// separated "role:daily:monthly" entries. Admins are unlimited and the
+	// "user" entry, which roles without one fall back to, is required.
+	Quotas map[string]GenerationQuota
 }
 
+// GenerationQuota is how many images a user may generate per UTC day and
+// calendar month; 0 is unlimited
+type GenerationQuota struct {
+	Daily   int
+	Monthly int
+}
+
+// defaultGenerationQuotas applies to users unless configured otherwise
+const defaultGenerationQuotas = "user:20:300"
+
+// defaultQuotaRole is the role whose quota applies to roles without one
+const defaultQuotaRole = "user"
+
 func LoadImageGeneratorConfig() *ImageGeneratorConfig {
 	generatorURL := os.Getenv("GENERATOR_URL")
 	baseURL := os.Getenv("GENERATOR_URL")
 	imagesDir := filepath.Join("static", "images")
 
 	return &ImageGeneratorConfig{
-		URL:       generatorURL,
-		ImagesDir: imagesDir,
-		BaseURL:   baseURL,
+		URL:             generatorURL,
+		ImagesDir:       imagesDir,
+		BaseURL:         baseURL,
+		AutoPublish:     getEnvBool("AUTO_PUBLISH_GENERATIONS", false),
+		PollInterval:    getEnvDuration("GENERATION_POLL_INTERVAL", 2*time.Second),
+		PollMaxInterval: getEnvDuration("GENERATION_POLL_MAX_INTERVAL", time.Minute),
+		Timeout:         getEnvDuration("GENERATION_TIMEOUT", 30*time.Minute),
+		Quotas:          getEnvQuotas("GENERATION_QUOTAS", defaultGenerationQuotas),
+	}
+}
+
+func getEnvQuotas(key, defaultValue string) map[string]GenerationQuota {
+	quotas, err := parseQuotas(getEnv(key, defaultValue))
+	if err != nil {
+		log.Printf("Invalid %s, using %q: %v", key, defaultValue, err)
+		quotas, _ = parseQuotas(defaultValue)
+	}
+	return quotas
+}
+
+func parseQuotas(value string) (map[string]GenerationQuota, error) {
+	quotas := make(map[string]GenerationQuota)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("quota %q is not role:daily:monthly", entry)
+		}
+		daily, err := strconv.Atoi(parts[1])
+		if err != nil || daily < 0 {
+			return nil, fmt.Errorf("quota %q has an invalid daily limit", entry)
+		}
+		monthly, err := strconv.Atoi(parts[2])
+		if err != nil || monthly < 0 {
+			return nil, fmt.Errorf("quota %q has an invalid monthly limit", entry)
+		}
+		quotas[parts[0]] = GenerationQuota{Daily: daily, Monthly: monthly}
+	}
+	// Without it every role lacking an entry would be unlimited
+	if _, ok := quotas[defaultQuotaRole]; !ok {
+		return nil, fmt.Errorf("no quota for the %q role", defaultQuotaRole)
 	}
+	return quotas, nil
 }
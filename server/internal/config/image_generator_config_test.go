@@ -0,0 +1,58 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuotas(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]GenerationQuota
+		wantErr bool
+	}{
+		{
+			name:  "user only",
+			value: "user:5:100",
+			want:  map[string]GenerationQuota{"user": {Daily: 5, Monthly: 100}},
+		},
+		{
+			name:  "several roles with spaces and empty entries",
+			value: " user:5:100, ,premium:50:0,",
+			want: map[string]GenerationQuota{
+				"user":    {Daily: 5, Monthly: 100},
+				"premium": {Daily: 50, Monthly: 0},
+			},
+		},
+		{
+			name:  "later entries win",
+			value: "user:5:100,user:10:200",
+			want:  map[string]GenerationQuota{"user": {Daily: 10, Monthly: 200}},
+		},
+		{name: "missing user role", value: "premium:50:0", wantErr: true},
+		{name: "empty", value: "", wantErr: true},
+		{name: "too few fields", value: "user:5", wantErr: true},
+		{name: "too many fields", value: "user:5:100:1", wantErr: true},
+		{name: "empty role", value: ":5:100,user:5:100", wantErr: true},
+		{name: "negative daily", value: "user:-1:100", wantErr: true},
+		{name: "non-numeric monthly", value: "user:5:lots", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseQuotas(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseQuotas(%q) = %v, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseQuotas(%q) failed: %v", tt.value, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseQuotas(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
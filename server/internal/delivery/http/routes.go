@@ -3,24 +3,33 @@ package http
 import (
 	"github.com/gin-gonic/gin"
 
+	"wallpaperio/server/internal/config"
 	"wallpaperio/server/internal/handlers"
 	"wallpaperio/server/internal/middleware"
+	"wallpaperio/server/internal/services/storage"
 	"wallpaperio/server/pkg/auth"
 )
 
+// privateCacheControl keeps per-user responses out of shared caches
+const privateCacheControl = "private, no-cache"
+
 // Router holds all the handlers for the application
 type Router struct {
 	handlers   map[string]interface{}
 	jwtService *auth.JWTService
 	apiKey     string
+	cache      *config.CacheConfig
+	// serveMedia registers the stored images route
+	serveMedia bool
 }
 
 // NewRouter creates a new Router instance
-func NewRouter(jwtService *auth.JWTService, apiKey string) *Router {
+func NewRouter(jwtService *auth.JWTService, apiKey string, cache *config.CacheConfig) *Router {
 	return &Router{
 		handlers:   make(map[string]interface{}),
 		jwtService: jwtService,
 		apiKey:     apiKey,
+		cache:      cache,
 	}
 }
 
@@ -28,6 +37,12 @@ func (r *Router) AddHandler(name string, handler interface{}) {
 	r.handlers[name] = handler
 }
 
+// ServeMedia sets whether this server serves stored images under
+// storage.LocalRoute
+func (r *Router) ServeMedia(serve bool) {
+	r.serveMedia = serve
+}
+
 // Setup configures all the routes for the application
 func (r *Router) Setup(router *gin.Engine) {
 	// CORS middleware
@@ -35,6 +50,7 @@ func (r *Router) Setup(router *gin.Engine) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Retry-After, X-Quota-Daily-Limit, X-Quota-Daily-Remaining, X-Quota-Monthly-Limit, X-Quota-Monthly-Remaining")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
 			return
@@ -42,8 +58,11 @@ func (r *Router) Setup(router *gin.Engine) {
 		c.Next()
 	})
 
-	// Serve static files
-	router.Static("/static", "./static")
+	if r.serveMedia {
+		mediaHandler := r.handlers["media"].(*handlers.MediaHandler)
+		router.GET(storage.LocalRoute+"/*key", mediaHandler.ServeMedia)
+		router.HEAD(storage.LocalRoute+"/*key", mediaHandler.ServeMedia)
+	}
 
 	// Auth routes
 	auth := router.Group("/auth")
@@ -57,30 +76,83 @@ func (r *Router) Setup(router *gin.Engine) {
 	images := router.Group("/api/images")
 	{
 		imageHandler := r.handlers["image"].(*handlers.ImageHandler)
-		images.POST("/generate", imageHandler.GenerateImage)
+		images.POST("/generate", middleware.RequireAuth(r.jwtService), imageHandler.GenerateImage)
 		images.GET("/generators", imageHandler.GetAvailableGenerators)
-		images.GET("/status/:task_id", imageHandler.GetGenerationStatus)
+		images.GET("/status/:task_id", middleware.RequireAuth(r.jwtService), imageHandler.GetGenerationStatus)
+		images.GET("/quota", middleware.RequireAuth(r.jwtService), middleware.CacheControl(privateCacheControl), imageHandler.GetQuota)
+		images.GET("/jobs", middleware.RequireAuth(r.jwtService), middleware.CacheControl(privateCacheControl), imageHandler.GetJobs)
+		images.POST("/jobs/:id/ticket", middleware.RequireAuth(r.jwtService), imageHandler.GetStreamTicket)
+		// Clients that cannot send headers authenticate with a ticket instead
+		images.GET("/jobs/:id/events", middleware.OptionalAuth(r.jwtService), imageHandler.GetJobEvents)
+		images.GET("/jobs/:id/ws", middleware.OptionalAuth(r.jwtService), imageHandler.GetJobEventsWebSocket)
 	}
 
 	// Category routes
-	router.GET("/api/categories", func(c *gin.Context) {
+	router.GET("/api/categories", middleware.CacheControl(r.cache.Categories), func(c *gin.Context) {
 		categoryHandler := r.handlers["category"].(*handlers.CategoryHandler)
 		categoryHandler.GetAllCategories(c)
 	})
 
 	// Wallpaper routes
-	wallpaper := router.Group("/api/wallpapers")
+	wallpaper := router.Group("/api/wallpapers", middleware.CacheControl(r.cache.Wallpapers))
 	{
 		wallpaperHandler := r.handlers["wallpaper"].(*handlers.WallpaperHandler)
 		wallpaper.GET("", wallpaperHandler.GetWallpapers)
+		wallpaper.GET("/search/semantic", wallpaperHandler.SemanticSearch)
+		imageSearchHandler := r.handlers["image_search"].(*handlers.ImageSearchHandler)
+		// Every search runs the feature extractor, so it is not open to all
+		wallpaper.POST("/search/image", middleware.RequireAuth(r.jwtService), imageSearchHandler.SearchByImage)
 		wallpaper.GET("/:id/:direction", wallpaperHandler.GetAdjacentWallpaper)
 		wallpaper.GET("/:id/similar", wallpaperHandler.GetSimilarWallpapers)
 		wallpaper.GET("/:id/info", wallpaperHandler.GetWallpaperInfo)
+		wallpaper.GET("/by-hash/:sha256", wallpaperHandler.GetWallpapersByHash)
+		downloadHandler := r.handlers["download"].(*handlers.DownloadHandler)
+		wallpaper.GET("/:id/download", middleware.OptionalAuth(r.jwtService), downloadHandler.DownloadWallpaper)
 		wallpaper.POST("", middleware.RequireAdminOrAPIKey(r.jwtService, r.apiKey), wallpaperHandler.CreateWallpaper)
+		wallpaper.POST("/batch", middleware.RequireAdminOrAPIKey(r.jwtService, r.apiKey), wallpaperHandler.CreateWallpapersBatch)
+		uploadHandler := r.handlers["upload"].(*handlers.UploadHandler)
+		wallpaper.POST("/upload", middleware.RequireAdminOrAPIKey(r.jwtService, r.apiKey), uploadHandler.UploadWallpaper)
 		wallpaper.DELETE("/:id", middleware.RequireAdminOrAPIKey(r.jwtService, r.apiKey), wallpaperHandler.DeleteWallpaper)
 		// favorite - requires auth
 		wallpaper.POST("/:id/favorite", middleware.RequireAuth(r.jwtService), wallpaperHandler.AddFavorite)
 		wallpaper.DELETE("/:id/favorite", middleware.RequireAuth(r.jwtService), wallpaperHandler.RemoveFavorite)
-		wallpaper.GET("/favorites", middleware.RequireAuth(r.jwtService), wallpaperHandler.GetFavorites)
+		wallpaper.GET("/favorites", middleware.RequireAuth(r.jwtService), middleware.CacheControl(privateCacheControl), wallpaperHandler.GetFavorites)
+		wallpaper.GET("/recommended", middleware.RequireAuth(r.jwtService), middleware.CacheControl(privateCacheControl), wallpaperHandler.GetRecommendedWallpapers)
+	}
+
+	// Admin routes
+	admin := router.Group("/api/admin", middleware.RequireAdminOrAPIKey(r.jwtService, r.apiKey))
+	{
+		reconcileHandler := r.handlers["reconcile"].(*handlers.ReconcileHandler)
+		admin.GET("/reconcile", reconcileHandler.GetReport)
+		admin.POST("/reconcile", reconcileHandler.Repair)
+
+		featureModelHandler := r.handlers["feature_model"].(*handlers.FeatureModelHandler)
+		admin.GET("/feature-models", featureModelHandler.GetFeatureModels)
+		admin.POST("/feature-models/reembed", featureModelHandler.Reembed)
+
+		duplicateHandler := r.handlers["duplicate"].(*handlers.DuplicateHandler)
+		admin.GET("/duplicates", duplicateHandler.GetReport)
+		admin.POST("/duplicates/scan", duplicateHandler.StartScan)
+
+		categoryHandler := r.handlers["category"].(*handlers.CategoryHandler)
+		admin.PUT("/categories/:id/image", categoryHandler.SetCategoryImage)
+
+		variantHandler := r.handlers["variant"].(*handlers.VariantHandler)
+		admin.GET("/variants", variantHandler.GetVariants)
+		admin.POST("/variants/backfill", variantHandler.Backfill)
+
+		metadataHandler := r.handlers["metadata"].(*handlers.MetadataHandler)
+		admin.GET("/metadata", metadataHandler.GetStatus)
+		admin.POST("/metadata/backfill", metadataHandler.Backfill)
+
+		colorHandler := r.handlers["color"].(*handlers.ColorHandler)
+		admin.GET("/colors", colorHandler.GetStatus)
+		admin.POST("/colors/backfill", colorHandler.Backfill)
+
+		clusterHandler := r.handlers["cluster"].(*handlers.ClusterHandler)
+		admin.GET("/clusters", clusterHandler.GetClusters)
+		admin.POST("/clusters/run", clusterHandler.RunClustering)
+		admin.POST("/clusters/:id/assign", clusterHandler.AssignCluster)
 	}
 }
@@ -0,0 +1,16 @@
+package dto
+
+import (
+	"time"
+)
+
+// BackfillStatus is the progress of the latest run of a backfill job
+type BackfillStatus struct {
+	Running    bool       `json:"running"`
+	Scanned    int        `json:"scanned"`
+	Updated    int        `json:"updated"`
+	Failed     int        `json:"failed"`
+	Errors     []string   `json:"errors,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
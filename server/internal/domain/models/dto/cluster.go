@@ -0,0 +1,42 @@
+package dto
+
+import "wallpaperio/server/internal/domain/models"
+
+type ClusterRequest struct {
+	// K is the number of clusters; 0 picks one from the catalogue size
+	K int `json:"k"`
+}
+
+// ClusterLabelCount is an existing tag or category and how many wallpapers
+// of a cluster carry it
+type ClusterLabelCount struct {
+	ID    uint   `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type ClusterSummary struct {
+	ID         uint                `json:"id"`
+	Size       int                 `json:"size"`
+	Samples    []models.Wallpaper  `json:"samples"`
+	Tags       []ClusterLabelCount `json:"tags"`
+	Categories []ClusterLabelCount `json:"categories"`
+}
+
+type ClusterReport struct {
+	// LatestRun is the most recent run, which may still be running or have failed
+	LatestRun *models.ClusterRun `json:"latest_run"`
+	// Run is the run the clusters belong to
+	Run      *models.ClusterRun `json:"run"`
+	Clusters []ClusterSummary   `json:"clusters"`
+}
+
+type ClusterAssignRequest struct {
+	Category string   `json:"category"`
+	Tags     []string `json:"tags"`
+}
+
+type ClusterAssignResult struct {
+	ClusterID  uint  `json:"cluster_id"`
+	Wallpapers int64 `json:"wallpapers"`
+}
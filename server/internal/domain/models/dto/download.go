@@ -0,0 +1,13 @@
+package dto
+
+// DownloadRequest is the target resolution of a download. Without a width
+// and height the original image is served. Expires and Signature are those
+// of the image's signed link, which an original requires when links are
+// signed and the user is not signed in.
+type DownloadRequest struct {
+	Width     int    `form:"w"`
+	Height    int    `form:"h"`
+	Fit       string `form:"fit"`
+	Expires   string `form:"expires"`
+	Signature string `form:"signature"`
+}
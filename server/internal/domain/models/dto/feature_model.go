@@ -0,0 +1,7 @@
+package dto
+
+type ReembedRequest struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Dimension int    `json:"dimension"`
+}
@@ -1,5 +1,9 @@
 package dto
 
+import (
+	"time"
+)
+
 type BaseResponseImage struct {
 	Status       string  `json:"status"`
 	Error        *string `json:"error,omitempty"`
@@ -13,6 +17,9 @@ type CompletedResponseImage struct {
 	UrlPathMedium *string `json:"url_path_medium,omitempty"`
 	UrlPath       string  `json:"url_path"`
 	Error         *string `json:"error,omitempty"`
+	PublishStatus string  `json:"publish_status,omitempty"`
+	WallpaperID   *uint   `json:"wallpaper_id,omitempty"`
+	PublishError  string  `json:"publish_error,omitempty"`
 }
 
 type FailedResponseImageStatus struct {
@@ -23,6 +30,9 @@ type FailedResponseImageStatus struct {
 type PendingResponseImage struct {
 	Status string `json:"status"`
 	TaskID string `json:"task_id"`
+	JobID  uint   `json:"job_id"`
+	// Progress is the percentage done of a running task
+	Progress int `json:"progress,omitempty"`
 }
 
 type ImageCreate struct {
@@ -33,4 +43,30 @@ type ImageCreate struct {
 	Category       string   `json:"category"`
 	Tags           []string `json:"tags"`
 	GeneratorType  *string  `json:"generator_type,omitempty"`
+	// Publish overrides AUTO_PUBLISH_GENERATIONS for the result; only
+	// admins may set it
+	Publish *bool `json:"publish,omitempty"`
+}
+
+// GenerationEvent is the state of a generation job, streamed whenever it
+// changes. Type is "queued", "running", "progress", "completed" or "failed".
+type GenerationEvent struct {
+	Type          string `json:"type"`
+	JobID         uint   `json:"job_id"`
+	TaskID        string `json:"task_id"`
+	Status        string `json:"status"`
+	Progress      int    `json:"progress"`
+	URLPath       string `json:"url_path,omitempty"`
+	URLPathThumb  string `json:"url_path_thumb,omitempty"`
+	URLPathMedium string `json:"url_path_medium,omitempty"`
+	Error         string `json:"error,omitempty"`
+	PublishStatus string `json:"publish_status,omitempty"`
+	WallpaperID   uint   `json:"wallpaper_id,omitempty"`
+	PublishError  string `json:"publish_error,omitempty"`
+}
+
+// StreamTicket authorises one connection to a generation job's event stream
+type StreamTicket struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
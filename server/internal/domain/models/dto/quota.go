@@ -0,0 +1,20 @@
+package dto
+
+import (
+	"time"
+)
+
+// QuotaPeriod is a user's generation usage in the current day or month.
+// Limit and Remaining are null when the user is unlimited.
+type QuotaPeriod struct {
+	Limit     *int      `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining *int      `json:"remaining"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+type GenerationQuota struct {
+	Role    string      `json:"role"`
+	Daily   QuotaPeriod `json:"daily"`
+	Monthly QuotaPeriod `json:"monthly"`
+}
@@ -0,0 +1,24 @@
+package dto
+
+type DanglingWallpaper struct {
+	ID        uint   `json:"id"`
+	FeatureID int64  `json:"feature_id"`
+	ImageURL  string `json:"image_url"`
+}
+
+type ReconcileReport struct {
+	WallpapersScanned  int                 `json:"wallpapers_scanned"`
+	FeaturesScanned    int                 `json:"features_scanned"`
+	OrphanFeatureIDs   []int64             `json:"orphan_feature_ids"`
+	DanglingWallpapers []DanglingWallpaper `json:"dangling_wallpapers"`
+	Repaired           bool                `json:"repaired"`
+	DeletedFeatures    int                 `json:"deleted_features"`
+	ReembeddedIDs      []uint              `json:"reembedded_ids,omitempty"`
+	Errors             []string            `json:"errors,omitempty"`
+}
+
+type PartitionMigrationReport struct {
+	Scanned int      `json:"scanned"`
+	Moved   int      `json:"moved"`
+	Errors  []string `json:"errors,omitempty"`
+}
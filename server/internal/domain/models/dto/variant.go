@@ -0,0 +1,13 @@
+package dto
+
+type VariantSpec struct {
+	Name    string `json:"name"`
+	Width   int    `json:"width"`
+	Format  string `json:"format"`
+	Quality int    `json:"quality"`
+}
+
+type VariantReport struct {
+	Specs    []VariantSpec  `json:"specs"`
+	Backfill BackfillStatus `json:"backfill"`
+}
@@ -1,6 +1,8 @@
 package dto
 
 import (
+	"time"
+
 	"wallpaperio/server/internal/domain/models"
 )
 
@@ -17,14 +19,56 @@ type CreateWallpaper struct {
 	ImageMediumUrl *string  `json:"image_medium_url,omitempty"`
 	Category       string   `json:"category"`
 	Tags           []string `json:"tags"`
+	// OnDuplicate overrides the configured duplicate handling: "reject",
+	// "flag" or "off"
+	OnDuplicate string `json:"on_duplicate,omitempty"`
+	// Image metadata reported by the generator, used when it cannot be
+	// read from the image itself
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Bytes    int64  `json:"bytes,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	// GenerationJobID and Prompt link a wallpaper published from a
+	// generation job back to it
+	GenerationJobID *uint  `json:"-"`
+	Prompt          string `json:"-"`
+}
+
+// UploadWallpaper holds the form fields sent with an uploaded image
+type UploadWallpaper struct {
+	Category    string   `form:"category" binding:"required"`
+	Tags        []string `form:"tags"`
+	OnDuplicate string   `form:"on_duplicate"`
+}
+
+type BatchCreateWallpapers struct {
+	Wallpapers []CreateWallpaper `json:"wallpapers"`
+}
+
+type BatchCreateResult struct {
+	Index     int               `json:"index"`
+	ImageURL  string            `json:"image_url"`
+	Success   bool              `json:"success"`
+	Wallpaper *models.Wallpaper `json:"wallpaper,omitempty"`
+	Error     string            `json:"error,omitempty"`
 }
 
 type WallpaperFilter struct {
-	Tags     []string
-	Category string
-	Search   string
-	Limit    int
-	Offset   int
+	Tags      []string
+	Category  string
+	Search    string
+	MinWidth  int
+	MinHeight int
+	// Orientation is "portrait", "landscape" or "square"
+	Orientation string
+	// AspectRatios matches wallpapers close to any of the width/height ratios
+	AspectRatios []float64
+	// Color matches wallpapers with a palette colour within ColorDistance
+	// (ΔE) of this #rrggbb colour
+	Color         string
+	ColorDistance float64
+	Limit         int
+	Offset        int
 }
 
 type NextPreviousWallpaperFilter struct {
@@ -33,7 +77,46 @@ type NextPreviousWallpaperFilter struct {
 	CurrentID uint64
 }
 
+// ScoredWallpaper is a wallpaper returned by a similarity search. The
+// wallpaper's fields are inlined in JSON next to the score.
+type ScoredWallpaper struct {
+	models.Wallpaper
+	Score float32 `json:"score"`
+}
+
+type SimilarWallpaperFilter struct {
+	Limit     int
+	Offset    int
+	Threshold float32
+	Category  string
+	// SameCategory restricts results to the wallpaper's own category
+	SameCategory bool
+	Tags         []string
+}
+
 type WallpaperResult struct {
 	Wallpapers []models.Wallpaper
 	Total      int64
 }
+
+type DuplicateMember struct {
+	ID            uint    `json:"id"`
+	ImageThumbURL string  `json:"image_thumb_url"`
+	ContentHash   string  `json:"content_hash,omitempty"`
+	BestScore     float32 `json:"best_score"`
+}
+
+type DuplicateCluster struct {
+	Wallpapers []DuplicateMember `json:"wallpapers"`
+}
+
+// DuplicateReport is the progress and outcome of the latest duplicate scan
+type DuplicateReport struct {
+	Running    bool               `json:"running"`
+	Threshold  float32            `json:"threshold"`
+	Scanned    int                `json:"scanned"`
+	StartedAt  *time.Time         `json:"started_at,omitempty"`
+	FinishedAt *time.Time         `json:"finished_at,omitempty"`
+	Error      string             `json:"error,omitempty"`
+	Clusters   []DuplicateCluster `json:"clusters"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+)
+
+// FeatureModelStatus is the lifecycle state of a feature model's collection
+type FeatureModelStatus string
+
+const (
+	FeatureModelPending     FeatureModelStatus = "pending"
+	FeatureModelBackfilling FeatureModelStatus = "backfilling"
+	FeatureModelReady       FeatureModelStatus = "ready"
+	FeatureModelFailed      FeatureModelStatus = "failed"
+)
+
+// FeatureModel is a version of the image embedding model. Each version keeps
+// its vectors in its own collection; exactly one version is active and used
+// for similarity search and ingestion.
+type FeatureModel struct {
+	ID         uint               `json:"id" gorm:"primaryKey"`
+	Name       string             `json:"name" gorm:"uniqueIndex:idx_feature_model_version"`
+	Version    string             `json:"version" gorm:"uniqueIndex:idx_feature_model_version"`
+	Dimension  int                `json:"dimension"`
+	Collection string             `json:"collection" gorm:"uniqueIndex"`
+	Active     bool               `json:"active" gorm:"index"`
+	Status     FeatureModelStatus `json:"status" gorm:"type:varchar(20);default:'pending'"`
+	Total      int                `json:"total"`
+	Processed  int                `json:"processed"`
+	Failed     int                `json:"failed"`
+	Error      string             `json:"error,omitempty"`
+	CreatedAt  time.Time          `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt  time.Time          `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// Key identifies the model version on wallpapers, e.g. "efficientnetv2-b0:1"
+func (m *FeatureModel) Key() string {
+	return m.Name + ":" + m.Version
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"time"
+)
+
+// GenerationJobStatus is the state of a generator task as last seen
+type GenerationJobStatus string
+
+const (
+	GenerationJobPending   GenerationJobStatus = "pending"
+	GenerationJobRunning   GenerationJobStatus = "running"
+	GenerationJobCompleted GenerationJobStatus = "completed"
+	GenerationJobFailed    GenerationJobStatus = "failed"
+)
+
+// PublishStatus is how far a completed generation got towards becoming a
+// wallpaper
+type PublishStatus string
+
+const (
+	PublishNone       PublishStatus = ""
+	PublishPending    PublishStatus = "pending"
+	PublishInProgress PublishStatus = "publishing"
+	PublishPublished  PublishStatus = "published"
+	PublishFailed     PublishStatus = "failed"
+)
+
+// GenerationJob records an image generation request submitted by a user and
+// the generator task running it. The server polls the generator for pending
+// jobs and records the result.
+type GenerationJob struct {
+	ID             uint                `json:"id" gorm:"primaryKey"`
+	UserID         uint                `json:"user_id" gorm:"index"`
+	TaskID         string              `json:"task_id" gorm:"uniqueIndex"`
+	Prompt         string              `json:"prompt"`
+	NegativePrompt *string             `json:"negative_prompt,omitempty"`
+	Width          int                 `json:"width"`
+	Height         int                 `json:"height"`
+	GeneratorType  *string             `json:"generator_type,omitempty"`
+	CategoryID     uint                `json:"category_id"`
+	Category       Category            `json:"category" gorm:"foreignKey:CategoryID"`
+	Tags           []string            `json:"tags" gorm:"serializer:json;type:jsonb"`
+	Status         GenerationJobStatus `json:"status" gorm:"type:varchar(20);default:'pending';index"`
+	// Progress is the percentage of the task done, as reported by the
+	// generator
+	Progress      int        `json:"progress"`
+	URLPath       string     `json:"url_path,omitempty"`
+	URLPathThumb  string     `json:"url_path_thumb,omitempty"`
+	URLPathMedium *string    `json:"url_path_medium,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt     time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+	// NextPollAt is when the generator is next asked about a pending task.
+	// It is NULL for jobs created before background polling, which are due
+	// right away.
+	NextPollAt   *time.Time `json:"-" gorm:"index"`
+	PollAttempts int        `json:"-"`
+	// PublishStatus is empty unless the result is to become a wallpaper
+	PublishStatus PublishStatus `json:"publish_status,omitempty" gorm:"type:varchar(20)"`
+	WallpaperID   *uint         `json:"wallpaper_id,omitempty"`
+	PublishError  string        `json:"publish_error,omitempty"`
+}
+
+// Active reports whether the generator is still working on the job's task
+func (j *GenerationJob) Active() bool {
+	return j.Status == GenerationJobPending || j.Status == GenerationJobRunning
+}
+
+// Settled reports whether the job will not change any more: its task has
+// finished and publishing, if any, is done
+func (j *GenerationJob) Settled() bool {
+	return !j.Active() && j.PublishStatus != PublishPending && j.PublishStatus != PublishInProgress
+}
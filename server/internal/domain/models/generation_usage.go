@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// Generation quota periods
+const (
+	QuotaPeriodDay   = "day"
+	QuotaPeriodMonth = "month"
+)
+
+// GenerationUsage counts the images a user generated in one quota period,
+// the UTC day or month starting at PeriodStart
+type GenerationUsage struct {
+	UserID      uint      `json:"user_id" gorm:"primaryKey"`
+	Period      string    `json:"period" gorm:"primaryKey;type:varchar(10)"`
+	PeriodStart time.Time `json:"period_start" gorm:"primaryKey"`
+	Count       int       `json:"count"`
+	UpdatedAt   time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
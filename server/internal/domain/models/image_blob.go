@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// ImageBlob is an image stored once under its SHA-256 hash, or for an
+// image derived from an original, the hash of its key. RefCount is the
+// number of wallpaper images and variants using it; the file is removed
+// with the last one.
+type ImageBlob struct {
+	Hash      string    `json:"hash" gorm:"primaryKey;type:char(64)"`
+	Key       string    `json:"key"`
+	Bytes     int64     `json:"bytes"`
+	MimeType  string    `json:"mime_type"`
+	RefCount  int       `json:"ref_count"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// StreamTicket lets a client that cannot send an Authorization header, such
+// as EventSource or a browser WebSocket, follow one of its generation jobs.
+// Only the SHA-256 hash of the ticket is stored; a ticket is used once and
+// expires shortly after it is issued.
+type StreamTicket struct {
+	Hash      string    `gorm:"primaryKey;type:char(64)"`
+	UserID    uint      `gorm:"not null"`
+	JobID     uint      `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+)
+
+// ClusterRunStatus is the state of a clustering run
+type ClusterRunStatus string
+
+const (
+	ClusterRunRunning ClusterRunStatus = "running"
+	ClusterRunReady   ClusterRunStatus = "ready"
+	ClusterRunFailed  ClusterRunStatus = "failed"
+)
+
+// ClusterRun is one pass of the visual clustering job over the active
+// feature model's vectors. Only the clusters of the latest ready run are kept.
+type ClusterRun struct {
+	ID           uint             `json:"id" gorm:"primaryKey"`
+	FeatureModel string           `json:"feature_model"`
+	K            int              `json:"k"`
+	Status       ClusterRunStatus `json:"status" gorm:"type:varchar(20);default:'running'"`
+	Total        int              `json:"total"`
+	Iterations   int              `json:"iterations"`
+	Error        string           `json:"error,omitempty"`
+	CreatedAt    time.Time        `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt    time.Time        `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// VisualCluster is a group of visually similar wallpapers
+type VisualCluster struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	RunID     uint      `json:"run_id" gorm:"index"`
+	Size      int       `json:"size"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// VisualClusterMember places a wallpaper in a cluster. Distance is the cosine
+// distance to the cluster centroid; the closest members are the most typical.
+// Memberships are deleted with the wallpaper.
+type VisualClusterMember struct {
+	ClusterID   uint    `json:"cluster_id" gorm:"primaryKey"`
+	WallpaperID uint    `json:"wallpaper_id" gorm:"primaryKey;index"`
+	Distance    float32 `json:"distance"`
+}
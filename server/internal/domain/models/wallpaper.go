@@ -5,15 +5,30 @@ import (
 )
 
 type Wallpaper struct {
-	ID             uint      `json:"id" gorm:"primaryKey"`
-	ImageURL       string    `json:"image_url"`
-	ImageThumbURL  string    `json:"image_thumb_url"`
-	ImageMediumURL *string   `json:"image_medium_url,omitempty"`
-	CategoryID     uint      `json:"category_id"`
-	FeatureID      int64     `json:"feature_id" gorm:"index"`
-	Category       Category  `json:"category" gorm:"foreignKey:CategoryID"`
-	Tags           []Tag     `json:"tags" gorm:"many2many:wallpaper_tags;"`
-	Downloads      int       `json:"downloads"`
-	CreatedAt      time.Time `json:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt      time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+	ID               uint                 `json:"id" gorm:"primaryKey"`
+	ImageURL         string               `json:"image_url"`
+	ImageThumbURL    string               `json:"image_thumb_url"`
+	ImageMediumURL   *string              `json:"image_medium_url,omitempty"`
+	CategoryID       uint                 `json:"category_id"`
+	FeatureID        int64                `json:"feature_id" gorm:"index"`
+	FeatureModel     string               `json:"feature_model" gorm:"index"`
+	FeaturePartition string               `json:"feature_partition,omitempty" gorm:"index"`
+	ContentHash      string               `json:"content_hash,omitempty" gorm:"index"`
+	Width            int                  `json:"width" gorm:"index"`
+	Height           int                  `json:"height" gorm:"index"`
+	AspectRatio      float64              `json:"aspect_ratio" gorm:"index"`
+	Orientation      string               `json:"orientation,omitempty" gorm:"type:varchar(10);index"`
+	Bytes            int64                `json:"bytes"`
+	MimeType         string               `json:"mime_type,omitempty"`
+	DuplicateOfID    *uint                `json:"duplicate_of_id,omitempty" gorm:"index"`
+	GenerationJobID  *uint                `json:"generation_job_id,omitempty" gorm:"uniqueIndex"`
+	Prompt           string               `json:"prompt,omitempty"`
+	Category         Category             `json:"category" gorm:"foreignKey:CategoryID"`
+	Tags             []Tag                `json:"tags" gorm:"many2many:wallpaper_tags;"`
+	Variants         []WallpaperVariant   `json:"variants,omitempty" gorm:"foreignKey:WallpaperID;constraint:OnDelete:CASCADE"`
+	Colors           []WallpaperColor     `json:"colors,omitempty" gorm:"foreignKey:WallpaperID;constraint:OnDelete:CASCADE"`
+	Renditions       []WallpaperRendition `json:"-" gorm:"foreignKey:WallpaperID;constraint:OnDelete:CASCADE"`
+	Downloads        int                  `json:"downloads"`
+	CreatedAt        time.Time            `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt        time.Time            `json:"updated_at" gorm:"autoUpdateTime"`
 }
@@ -0,0 +1,14 @@
+package models
+
+// WallpaperColor is one colour of a wallpaper's dominant palette. Weight is
+// the share of the image the colour covers; L, A and B are its CIELAB
+// coordinates, used to search by colour.
+type WallpaperColor struct {
+	WallpaperID uint    `json:"-" gorm:"primaryKey"`
+	Rank        int     `json:"-" gorm:"primaryKey"`
+	Hex         string  `json:"hex" gorm:"type:varchar(7)"`
+	Weight      float64 `json:"weight"`
+	L           float64 `json:"-"`
+	A           float64 `json:"-"`
+	B           float64 `json:"-"`
+}
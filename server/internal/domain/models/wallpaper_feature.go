@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// WallpaperFeature records a wallpaper's vector in a feature model's
+// collection. It is written while a model version is being backfilled and
+// deleted along with the wallpaper's vectors when the wallpaper is deleted.
+type WallpaperFeature struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	WallpaperID    uint      `json:"wallpaper_id" gorm:"uniqueIndex:idx_wallpaper_feature_model"`
+	FeatureModelID uint      `json:"feature_model_id" gorm:"uniqueIndex:idx_wallpaper_feature_model;index"`
+	FeatureID      int64     `json:"feature_id"`
+	Partition      string    `json:"partition"`
+	CreatedAt      time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
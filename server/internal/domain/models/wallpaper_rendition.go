@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// WallpaperRendition is a copy of a wallpaper's image rendered for a device
+// resolution on download and cached in storage under Key
+type WallpaperRendition struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	WallpaperID uint      `json:"wallpaper_id" gorm:"uniqueIndex:idx_wallpaper_rendition"`
+	Width       int       `json:"width" gorm:"uniqueIndex:idx_wallpaper_rendition"`
+	Height      int       `json:"height" gorm:"uniqueIndex:idx_wallpaper_rendition"`
+	Fit         string    `json:"fit" gorm:"type:varchar(10);uniqueIndex:idx_wallpaper_rendition"`
+	Key         string    `json:"key"`
+	Bytes       int64     `json:"bytes"`
+	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// WallpaperVariant is a resized copy of a wallpaper's image rendered by the
+// server. MaxWidth and Quality record the settings it was rendered with, so
+// variants whose configuration changed can be re-rendered.
+type WallpaperVariant struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	WallpaperID uint      `json:"wallpaper_id" gorm:"uniqueIndex:idx_wallpaper_variant"`
+	Name        string    `json:"name" gorm:"uniqueIndex:idx_wallpaper_variant"`
+	Format      string    `json:"format" gorm:"uniqueIndex:idx_wallpaper_variant"`
+	MaxWidth    int       `json:"-"`
+	Quality     int       `json:"-"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	Bytes       int64     `json:"bytes"`
+	URL         string    `json:"url"`
+	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt   time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
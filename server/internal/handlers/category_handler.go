@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 
 	"wallpaperio/server/internal/services"
 
@@ -10,11 +14,15 @@ import (
 
 type CategoryHandler struct {
 	categorySvc *services.CategoryService
+	signer      *services.URLSigner
+	maxBytes    int64
 }
 
-func NewCategoryHandler(categorySvc *services.CategoryService) *CategoryHandler {
+func NewCategoryHandler(categorySvc *services.CategoryService, signer *services.URLSigner, maxBytes int64) *CategoryHandler {
 	return &CategoryHandler{
 		categorySvc: categorySvc,
+		signer:      signer,
+		maxBytes:    maxBytes,
 	}
 }
 
@@ -25,5 +33,60 @@ func (h *CategoryHandler) GetAllCategories(c *gin.Context) {
 		return
 	}
 
+	for i := range categories {
+		categories[i].ImageURL = h.signer.URL(categories[i].ImageURL)
+	}
 	c.JSON(http.StatusOK, categories)
 }
+
+// SetCategoryImage replaces the category's image with the multipart upload
+// in field "image"
+func (h *CategoryHandler) SetCategoryImage(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxBytes+uploadFormOverhead)
+	file, _, err := c.Request.FormFile("image")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An image file is required"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, h.maxBytes+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read image"})
+		return
+	}
+	if int64(len(data)) > h.maxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image is too large"})
+		return
+	}
+
+	category, err := h.categorySvc.SetCategoryImage(uint(id), data)
+	switch {
+	case errors.Is(err, services.ErrCategoryNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	case errors.Is(err, services.ErrUnsupportedImage):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrInvalidImage):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to set category image: %v", err)})
+		return
+	}
+
+	category.ImageURL = h.signer.URL(category.ImageURL)
+	c.JSON(http.StatusOK, category)
+}
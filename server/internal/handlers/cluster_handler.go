@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"wallpaperio/server/internal/domain/models/dto"
+	"wallpaperio/server/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ClusterHandler struct {
+	clusterSvc *services.ClusterService
+	signer     *services.URLSigner
+}
+
+func NewClusterHandler(clusterSvc *services.ClusterService, signer *services.URLSigner) *ClusterHandler {
+	return &ClusterHandler{
+		clusterSvc: clusterSvc,
+		signer:     signer,
+	}
+}
+
+// GetClusters lists the visual clusters of the latest clustering run
+func (h *ClusterHandler) GetClusters(c *gin.Context) {
+	samples := services.DefaultClusterSamples
+	if samplesStr := c.Query("samples"); samplesStr != "" {
+		if s, err := strconv.Atoi(samplesStr); err == nil && s > 0 && s <= 50 {
+			samples = s
+		}
+	}
+
+	report, err := h.clusterSvc.Report(samples)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to fetch clusters: %v", err)})
+		return
+	}
+
+	for _, cluster := range report.Clusters {
+		h.signer.SignWallpapers(cluster.Samples)
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// RunClustering starts a clustering run in the background
+func (h *ClusterHandler) RunClustering(c *gin.Context) {
+	var req dto.ClusterRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	run, err := h.clusterSvc.StartRun(req.K)
+	if errors.Is(err, services.ErrClusteringInProgress) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to start clustering: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, run)
+}
+
+// AssignCluster sets a category and/or adds tags to every wallpaper in a cluster
+func (h *ClusterHandler) AssignCluster(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cluster ID"})
+		return
+	}
+
+	var req dto.ClusterAssignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Category == "" && len(req.Tags) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "category or tags are required"})
+		return
+	}
+
+	result, err := h.clusterSvc.Assign(uint(id), req)
+	if errors.Is(err, services.ErrClusterNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to assign cluster: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
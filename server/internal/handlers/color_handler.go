@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"wallpaperio/server/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ColorHandler struct {
+	colorSvc *services.ColorService
+}
+
+func NewColorHandler(colorSvc *services.ColorService) *ColorHandler {
+	return &ColorHandler{
+		colorSvc: colorSvc,
+	}
+}
+
+// GetStatus reports the progress of the latest palette backfill
+func (h *ColorHandler) GetStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, h.colorSvc.Status())
+}
+
+// Backfill starts extracting the palette of wallpapers without one in the
+// background
+func (h *ColorHandler) Backfill(c *gin.Context) {
+	status, err := h.colorSvc.StartBackfill()
+	if errors.Is(err, services.ErrBackfillInProgress) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "backfill": status})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, status)
+}
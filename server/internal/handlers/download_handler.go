@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"wallpaperio/server/internal/domain/models/dto"
+	"wallpaperio/server/internal/services"
+	"wallpaperio/server/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DownloadHandler struct {
+	downloadSvc *services.DownloadService
+}
+
+func NewDownloadHandler(downloadSvc *services.DownloadService) *DownloadHandler {
+	return &DownloadHandler{
+		downloadSvc: downloadSvc,
+	}
+}
+
+// DownloadWallpaper sends the wallpaper's image as an attachment, resized to
+// the device resolution given by "w" and "h". With fit=crop (the default)
+// the image fills the resolution, cropped around its subject; with
+// fit=contain it fits inside it uncropped. Resizing requires a signed-in
+// user, and so does the original when links are signed unless the request
+// carries the image link's signature.
+func (h *DownloadHandler) DownloadWallpaper(c *gin.Context) {
+	wallpaperID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid wallpaper ID"})
+		return
+	}
+
+	var req dto.DownloadRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid w or h"})
+		return
+	}
+	if (req.Width != 0 || req.Height != 0) && utils.CurrentUser(c) == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign in to download resized wallpapers"})
+		return
+	}
+
+	download, err := h.downloadSvc.Download(uint(wallpaperID), req, utils.CurrentUser(c) != nil)
+	switch {
+	case errors.Is(err, services.ErrInvalidDownloadSize), errors.Is(err, services.ErrInvalidFit):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrLinkExpired):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Link has expired"})
+		return
+	case errors.Is(err, services.ErrInvalidSignature):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Sign in or use a signed link to download this wallpaper"})
+		return
+	case errors.Is(err, services.ErrWallpaperNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Wallpaper not found"})
+		return
+	case err != nil:
+		log.Printf("Failed to download wallpaper %d: %v", wallpaperID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to download wallpaper"})
+		return
+	}
+	defer download.Content.Close()
+
+	c.DataFromReader(http.StatusOK, download.Size, download.ContentType, download.Content, map[string]string{
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s"`, download.Filename),
+	})
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"wallpaperio/server/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DuplicateHandler struct {
+	duplicateSvc *services.DuplicateService
+	signer       *services.URLSigner
+}
+
+func NewDuplicateHandler(duplicateSvc *services.DuplicateService, signer *services.URLSigner) *DuplicateHandler {
+	return &DuplicateHandler{
+		duplicateSvc: duplicateSvc,
+		signer:       signer,
+	}
+}
+
+// GetReport returns the progress of the latest duplicate scan and the
+// clusters of duplicate wallpapers it found
+func (h *DuplicateHandler) GetReport(c *gin.Context) {
+	report := h.duplicateSvc.Report()
+	for _, cluster := range report.Clusters {
+		for i, w := range cluster.Wallpapers {
+			cluster.Wallpapers[i].ImageThumbURL = h.signer.URL(w.ImageThumbURL)
+		}
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// StartScan starts a duplicate scan of the catalogue in the background
+func (h *DuplicateHandler) StartScan(c *gin.Context) {
+	var threshold float64
+	if raw := c.Query("threshold"); raw != "" {
+		var err error
+		threshold, err = strconv.ParseFloat(raw, 32)
+		if err != nil || threshold <= 0 || threshold > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid threshold"})
+			return
+		}
+	}
+
+	report, err := h.duplicateSvc.StartScan(float32(threshold))
+	if errors.Is(err, services.ErrDuplicateScanInProgress) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, report)
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"wallpaperio/server/internal/domain/models/dto"
+	"wallpaperio/server/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FeatureModelHandler struct {
+	featureModelSvc *services.FeatureModelService
+}
+
+func NewFeatureModelHandler(featureModelSvc *services.FeatureModelService) *FeatureModelHandler {
+	return &FeatureModelHandler{
+		featureModelSvc: featureModelSvc,
+	}
+}
+
+// GetFeatureModels lists feature model versions with their backfill progress
+func (h *FeatureModelHandler) GetFeatureModels(c *gin.Context) {
+	list, err := h.featureModelSvc.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch feature models"})
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// Reembed starts backfilling a new feature model version in the background
+func (h *FeatureModelHandler) Reembed(c *gin.Context) {
+	var req dto.ReembedRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	model, err := h.featureModelSvc.StartReembed(req)
+	if errors.Is(err, services.ErrReembedInProgress) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to start re-embedding: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, model)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"wallpaperio/server/internal/domain/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// notModified sets the response's validators and, if the request's
+// conditional headers show the client already has this version, responds
+// 304 and returns true. A zero lastModified sends no Last-Modified.
+func notModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	c.Header("ETag", etag)
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		return false
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		if !etagMatches(match, etag) {
+			return false
+		}
+	} else if since := c.GetHeader("If-Modified-Since"); since != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(since)
+		if err != nil || lastModified.Truncate(time.Second).After(t) {
+			return false
+		}
+	} else {
+		return false
+	}
+
+	c.Status(http.StatusNotModified)
+	return true
+}
+
+// etagMatches compares an If-None-Match header against etag using the weak
+// comparison GET requires
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// weakETag hashes everything a response depends on into a weak ETag
+func weakETag(parts ...interface{}) string {
+	h := sha256.New()
+	for _, p := range parts {
+		fmt.Fprintf(h, "%v\x00", p)
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// wallpapersETag is the weak ETag of a response listing the wallpapers; it
+// changes when one of them is updated or the list itself changes. extra
+// holds whatever else the response depends on.
+func wallpapersETag(wallpapers []models.Wallpaper, extra ...interface{}) string {
+	parts := extra
+	for _, w := range wallpapers {
+		parts = append(parts, w.ID, w.UpdatedAt.UnixNano())
+	}
+	return weakETag(parts...)
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"wallpaperio/server/internal/domain/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestETagMatches(t *testing.T) {
+	const etag = `W/"abc"`
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"same weak tag", `W/"abc"`, true},
+		{"strong form of the tag", `"abc"`, true},
+		{"wildcard", "*", true},
+		{"one of several", `"xyz", W/"abc"`, true},
+		{"several without spaces", `"xyz",W/"abc"`, true},
+		{"other tag", `W/"xyz"`, false},
+		{"unquoted", "abc", false},
+		{"prefix of the tag", `W/"ab"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(tt.header, etag); got != tt.want {
+				t.Errorf("etagMatches(%q, %q) = %v, want %v", tt.header, etag, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const etag = `W/"abc"`
+	modified := time.Date(2026, 3, 10, 12, 0, 0, 500_000_000, time.UTC)
+
+	tests := []struct {
+		name         string
+		method       string
+		headers      map[string]string
+		lastModified time.Time
+		want         bool
+	}{
+		{name: "no conditional headers", method: http.MethodGet, lastModified: modified},
+		{name: "matching etag", method: http.MethodGet, headers: map[string]string{"If-None-Match": etag}, want: true},
+		{name: "matching etag on HEAD", method: http.MethodHead, headers: map[string]string{"If-None-Match": etag}, want: true},
+		{name: "matching etag on POST", method: http.MethodPost, headers: map[string]string{"If-None-Match": etag}},
+		{name: "other etag", method: http.MethodGet, headers: map[string]string{"If-None-Match": `W/"xyz"`}},
+		{
+			name:    "etag takes precedence over date",
+			method:  http.MethodGet,
+			headers: map[string]string{"If-None-Match": `W/"xyz"`, "If-Modified-Since": modified.Add(time.Hour).Format(http.TimeFormat)},
+			// Would be not modified by date alone
+			lastModified: modified,
+		},
+		{
+			name:         "not modified since",
+			method:       http.MethodGet,
+			headers:      map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)},
+			lastModified: modified,
+			want:         true,
+		},
+		{
+			name:         "modified since",
+			method:       http.MethodGet,
+			headers:      map[string]string{"If-Modified-Since": modified.Add(-time.Second).Format(http.TimeFormat)},
+			lastModified: modified,
+		},
+		{
+			name:    "date without last modified",
+			method:  http.MethodGet,
+			headers: map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)},
+		},
+		{
+			name:         "unparsable date",
+			method:       http.MethodGet,
+			headers:      map[string]string{"If-Modified-Since": "yesterday"},
+			lastModified: modified,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(tt.method, "/", nil)
+			for name, value := range tt.headers {
+				c.Request.Header.Set(name, value)
+			}
+
+			if got := notModified(c, etag, tt.lastModified); got != tt.want {
+				t.Fatalf("notModified() = %v, want %v", got, tt.want)
+			}
+			c.Writer.WriteHeaderNow()
+			if tt.want && w.Code != http.StatusNotModified {
+				t.Errorf("status = %d, want 304", w.Code)
+			}
+			if got := w.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %q, want %q", got, etag)
+			}
+			wantLastModified := ""
+			if !tt.lastModified.IsZero() {
+				wantLastModified = tt.lastModified.Format(http.TimeFormat)
+			}
+			if got := w.Header().Get("Last-Modified"); got != wantLastModified {
+				t.Errorf("Last-Modified = %q, want %q", got, wantLastModified)
+			}
+		})
+	}
+}
+
+func TestWallpapersETag(t *testing.T) {
+	updated := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	base := []models.Wallpaper{{ID: 1, UpdatedAt: updated}, {ID: 2, UpdatedAt: updated}}
+	tests := []struct {
+		name       string
+		wallpapers []models.Wallpaper
+		extra      []interface{}
+		wantSame   bool
+	}{
+		{"same list", []models.Wallpaper{{ID: 1, UpdatedAt: updated}, {ID: 2, UpdatedAt: updated}}, nil, true},
+		{"one updated", []models.Wallpaper{{ID: 1, UpdatedAt: updated}, {ID: 2, UpdatedAt: updated.Add(time.Nanosecond)}}, nil, false},
+		{"reordered", []models.Wallpaper{{ID: 2, UpdatedAt: updated}, {ID: 1, UpdatedAt: updated}}, nil, false},
+		{"one removed", []models.Wallpaper{{ID: 1, UpdatedAt: updated}}, nil, false},
+		{"other extra", base, []interface{}{"page 2"}, false},
+	}
+	want := wallpapersETag(base)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := wallpapersETag(tt.wallpapers, tt.extra...)
+			if (got == want) != tt.wantSame {
+				t.Errorf("wallpapersETag() = %s, base %s; want same %v", got, want, tt.wantSame)
+			}
+		})
+	}
+}
@@ -1,33 +1,62 @@
 package handlers
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"io"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
-	"wallpaperio/server/internal/config"
 	"wallpaperio/server/internal/domain/models"
 	"wallpaperio/server/internal/domain/models/dto"
 	"wallpaperio/server/internal/services"
+	"wallpaperio/server/internal/utils"
 	"wallpaperio/server/pkg/image_generator"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// streamKeepAlive is how often an idle event stream is pinged so proxies
+	// keep it open
+	streamKeepAlive = 30 * time.Second
+	// streamWriteTimeout bounds each write to a WebSocket
+	streamWriteTimeout = 10 * time.Second
 )
 
 type ImageHandler struct {
-	config *config.ImageGeneratorConfig
-	client *image_generator.Client
-	db     *gorm.DB
-	tagSvc *services.TagService
+	client        *image_generator.Client
+	generationSvc *services.GenerationService
+	upgrader      websocket.Upgrader
 }
 
-func NewImageHandler(cfg *config.ImageGeneratorConfig, db *gorm.DB) *ImageHandler {
+// NewImageHandler accepts job WebSockets from browsers on the given
+// frontend origins, or from this server's host if there are none
+func NewImageHandler(client *image_generator.Client, generationSvc *services.GenerationService, frontendOrigins []string) *ImageHandler {
 	return &ImageHandler{
-		config: cfg,
-		client: image_generator.NewClient(cfg.URL),
-		db:     db,
-		tagSvc: services.NewTagService(db),
+		client:        client,
+		generationSvc: generationSvc,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				origin := r.Header.Get("Origin")
+				if origin == "" {
+					// Not a browser; authentication is all that matters
+					return true
+				}
+				if len(frontendOrigins) > 0 {
+					return slices.Contains(frontendOrigins, strings.TrimSuffix(origin, "/"))
+				}
+				u, err := url.Parse(origin)
+				return err == nil && strings.EqualFold(u.Host, r.Host)
+			},
+		},
 	}
 }
 
@@ -40,57 +69,73 @@ func (h *ImageHandler) GenerateImage(c *gin.Context) {
 		})
 		return
 	}
+	req.Tags = splitTags(req.Tags)
 
-	// Check category
-	var category models.Category
-	if err := h.db.Where("name = ?", req.Category).First(&category).Error; err != nil {
-		c.JSON(http.StatusBadRequest, dto.FailedResponseImageStatus{
+	user := utils.CurrentUser(c)
+	job, quota, err := h.generationSvc.Submit(user.UserID, user.Role, req)
+	var exceeded *services.QuotaExceededError
+	if errors.As(err, &exceeded) {
+		setQuotaHeaders(c, exceeded.Quota)
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(exceeded.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, dto.FailedResponseImageStatus{
 			Status: "failed",
-			Error:  "Category not found",
+			Error:  exceeded.Error(),
 		})
 		return
 	}
-
-	// Call image generator service
-	genReq := &image_generator.GenerateRequest{
-		Prompt:         req.Prompt,
-		NegativePrompt: req.NegativePrompt,
-		Width:          req.Width,
-		Height:         req.Height,
-		GeneratorType:  req.GeneratorType,
-	}
-
-	genResp, err := h.client.GenerateImageAI(genReq)
-	fmt.Println("Generated image response ", genResp)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, dto.FailedResponseImageStatus{
+		status := http.StatusInternalServerError
+		message := err.Error()
+		switch {
+		case errors.Is(err, services.ErrCategoryNotFound):
+			status, message = http.StatusBadRequest, "Category not found"
+		case errors.Is(err, services.ErrNoTaskID):
+			status, message = http.StatusBadRequest, "No task ID or saved path received"
+		}
+		log.Printf("Failed to submit generation: %v", err)
+		c.JSON(status, dto.FailedResponseImageStatus{
 			Status: "failed",
-			Error:  err.Error(),
+			Error:  message,
 		})
 		return
 	}
 
-	// If we got a task ID, return pending response
-	if genResp.TaskID != nil {
-		c.JSON(http.StatusOK, dto.PendingResponseImage{
-			Status: "pending",
-			TaskID: *genResp.TaskID,
-		})
+	setQuotaHeaders(c, quota)
+	c.JSON(http.StatusOK, dto.PendingResponseImage{
+		Status: "pending",
+		TaskID: job.TaskID,
+		JobID:  job.ID,
+	})
+}
+
+// GetQuota shows the caller's generation quotas and usage
+func (h *ImageHandler) GetQuota(c *gin.Context) {
+	user := utils.CurrentUser(c)
+	quota, err := h.generationSvc.Quota(user.UserID, user.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get generation quota"})
 		return
 	}
+	setQuotaHeaders(c, quota)
+	c.JSON(http.StatusOK, quota)
+}
 
-	// If we got neither task ID nor saved path, return error
-	c.JSON(http.StatusBadRequest, dto.FailedResponseImageStatus{
-		Status: "failed",
-		Error:  "No task ID or saved path received",
-	})
+// setQuotaHeaders reports the generations left today and this month;
+// unlimited periods are left out
+func setQuotaHeaders(c *gin.Context, quota *dto.GenerationQuota) {
+	if quota.Daily.Remaining != nil {
+		c.Header("X-Quota-Daily-Limit", strconv.Itoa(*quota.Daily.Limit))
+		c.Header("X-Quota-Daily-Remaining", strconv.Itoa(*quota.Daily.Remaining))
+	}
+	if quota.Monthly.Remaining != nil {
+		c.Header("X-Quota-Monthly-Limit", strconv.Itoa(*quota.Monthly.Limit))
+		c.Header("X-Quota-Monthly-Remaining", strconv.Itoa(*quota.Monthly.Remaining))
+	}
 }
 
 func (h *ImageHandler) GetGenerationStatus(c *gin.Context) {
-	log.Printf("GetGenerationStatus called with task_id: %s", c.Param("task_id"))
 	taskID := c.Param("task_id")
 	if taskID == "" {
-		log.Printf("Task ID is empty")
 		c.JSON(http.StatusBadRequest, dto.FailedResponseImageStatus{
 			Status: "failed",
 			Error:  "Task ID is required",
@@ -98,8 +143,16 @@ func (h *ImageHandler) GetGenerationStatus(c *gin.Context) {
 		return
 	}
 
-	taskStatus, err := h.client.GetTaskStatus(taskID)
+	user := utils.CurrentUser(c)
+	job, err := h.generationSvc.Status(user.UserID, taskID)
 	if err != nil {
+		if errors.Is(err, services.ErrGenerationJobNotFound) {
+			c.JSON(http.StatusNotFound, dto.FailedResponseImageStatus{
+				Status: "failed",
+				Error:  "Generation job not found",
+			})
+			return
+		}
 		log.Printf("Error getting task status: %v", err)
 		c.JSON(http.StatusInternalServerError, dto.FailedResponseImageStatus{
 			Status: "failed",
@@ -108,19 +161,200 @@ func (h *ImageHandler) GetGenerationStatus(c *gin.Context) {
 		return
 	}
 
-	if taskStatus.Status == "completed" {
+	// Clients expect the generator's statuses here
+	status := services.TaskStatus(job.Status)
+	switch job.Status {
+	case models.GenerationJobCompleted:
 		c.JSON(http.StatusOK, dto.CompletedResponseImage{
-			Status:        "completed",
-			UrlPath:       taskStatus.UrlPath,
-			UrlPathThumb:  taskStatus.UrlPathThumb,
-			UrlPathMedium: taskStatus.UrlPathMedium,
+			Status:        status,
+			UrlPath:       job.URLPath,
+			UrlPathThumb:  job.URLPathThumb,
+			UrlPathMedium: job.URLPathMedium,
+			PublishStatus: string(job.PublishStatus),
+			WallpaperID:   job.WallpaperID,
+			PublishError:  job.PublishError,
+		})
+	case models.GenerationJobFailed:
+		c.JSON(http.StatusOK, dto.FailedResponseImageStatus{
+			Status: status,
+			Error:  job.Error,
+		})
+	default:
+		c.JSON(http.StatusOK, dto.PendingResponseImage{
+			Status:   status,
+			TaskID:   job.TaskID,
+			JobID:    job.ID,
+			Progress: job.Progress,
 		})
+	}
+}
+
+// GetJobs lists the caller's generation jobs, newest first
+func (h *ImageHandler) GetJobs(c *gin.Context) {
+	user := utils.CurrentUser(c)
+
+	limit := 20
+	offset := 0
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	jobs, total, err := h.generationSvc.ListJobs(user.UserID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get generation jobs"})
 		return
 	}
 
-	// For pending or failed status, return as is
-	log.Printf("Task status: %s", taskStatus.Status)
-	c.JSON(http.StatusOK, taskStatus)
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":   jobs,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// GetJobEvents streams the state of one of the caller's generation jobs as
+// Server-Sent Events until the job settles
+func (h *ImageHandler) GetJobEvents(c *gin.Context) {
+	events, ok := h.followJob(c.Request.Context(), c)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.Type, event)
+			return true
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		}
+	})
+}
+
+// GetJobEventsWebSocket streams the state of one of the caller's generation
+// jobs over a WebSocket, one JSON message per change, and closes it once
+// the job settles
+func (h *ImageHandler) GetJobEventsWebSocket(c *gin.Context) {
+	// The request's context is not cancelled when a hijacked connection
+	// closes, so the reader below cancels this one
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	events, ok := h.followJob(ctx, c)
+	if !ok {
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// GetStreamTicket issues a single-use ticket for following one of the
+// caller's jobs from clients that cannot send an Authorization header
+func (h *ImageHandler) GetStreamTicket(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	user := utils.CurrentUser(c)
+	ticket, err := h.generationSvc.IssueStreamTicket(user.UserID, uint(id))
+	if err != nil {
+		if errors.Is(err, services.ErrGenerationJobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Generation job not found"})
+			return
+		}
+		log.Printf("Failed to issue stream ticket: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue stream ticket"})
+		return
+	}
+	c.JSON(http.StatusOK, ticket)
+}
+
+// followJob starts following the job in the path, responding with an error
+// if it is not one of the caller's. The caller is the signed-in user or the
+// one a ticket in the "ticket" query parameter was issued to.
+func (h *ImageHandler) followJob(ctx context.Context, c *gin.Context) (<-chan dto.GenerationEvent, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return nil, false
+	}
+
+	var userID uint
+	if user := utils.CurrentUser(c); user != nil {
+		userID = user.UserID
+	} else {
+		userID, err = h.generationSvc.RedeemStreamTicket(c.Query("ticket"), uint(id))
+		if err != nil {
+			if !errors.Is(err, services.ErrInvalidStreamTicket) {
+				log.Printf("Failed to redeem stream ticket: %v", err)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header or a valid ticket required"})
+			return nil, false
+		}
+	}
+
+	events, err := h.generationSvc.Follow(ctx, userID, uint(id))
+	if err != nil {
+		if errors.Is(err, services.ErrGenerationJobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Generation job not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to follow generation job"})
+		return nil, false
+	}
+	return events, true
 }
 
 func (h *ImageHandler) GetAvailableGenerators(c *gin.Context) {
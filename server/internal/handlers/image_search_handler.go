@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"wallpaperio/server/internal/domain/models/dto"
+	"wallpaperio/server/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ImageSearchHandler struct {
+	imageSearchSvc *services.ImageSearchService
+	signer         *services.URLSigner
+}
+
+func NewImageSearchHandler(imageSearchSvc *services.ImageSearchService, signer *services.URLSigner) *ImageSearchHandler {
+	return &ImageSearchHandler{
+		imageSearchSvc: imageSearchSvc,
+		signer:         signer,
+	}
+}
+
+// SearchByImage finds wallpapers similar to an uploaded image (multipart
+// field "image") or to the image at "image_url"
+func (h *ImageSearchHandler) SearchByImage(c *gin.Context) {
+	// Bound the body before the multipart form is parsed
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, services.MaxSearchImageSize+uploadFormOverhead)
+
+	limit := 20
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+	threshold := services.DefaultSimilarityThreshold
+	if thresholdStr := c.Query("threshold"); thresholdStr != "" {
+		if t, err := strconv.ParseFloat(thresholdStr, 32); err == nil {
+			threshold = float32(t)
+		}
+	}
+
+	var results []dto.ScoredWallpaper
+	var err error
+	file, header, formErr := c.Request.FormFile("image")
+	var tooLarge *http.MaxBytesError
+	if errors.As(formErr, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image is too large"})
+		return
+	}
+	if formErr == nil {
+		defer file.Close()
+		if header.Size > services.MaxSearchImageSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image is too large"})
+			return
+		}
+
+		data, readErr := io.ReadAll(io.LimitReader(file, services.MaxSearchImageSize+1))
+		if readErr != nil || len(data) > services.MaxSearchImageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read image"})
+			return
+		}
+
+		results, err = h.imageSearchSvc.SearchByUpload(data, limit, threshold)
+	} else {
+		imageURL := c.PostForm("image_url")
+		if imageURL == "" {
+			var req struct {
+				ImageURL string `json:"image_url"`
+			}
+			if c.ShouldBindJSON(&req) == nil {
+				imageURL = req.ImageURL
+			}
+		}
+		if imageURL == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "An image file or image_url is required"})
+			return
+		}
+
+		results, err = h.imageSearchSvc.SearchByURL(imageURL, limit, threshold)
+	}
+
+	switch {
+	case errors.Is(err, services.ErrInvalidImageURL), errors.Is(err, services.ErrImageURLForbidden):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrImageURLFetch):
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch image_url"})
+		return
+	case errors.Is(err, services.ErrSearchImageSize):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrUnsupportedImage):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Image must be JPEG, PNG or WebP"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to search by image: %v", err)})
+		return
+	}
+
+	h.signer.SignScored(results)
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"wallpaperio/server/internal/services"
+	"wallpaperio/server/internal/services/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MediaHandler serves stored images of the local backend, and of any
+// backend when links are signed, checking the signature of gated ones
+type MediaHandler struct {
+	storage      storage.Storage
+	signer       *services.URLSigner
+	cacheControl string
+}
+
+func NewMediaHandler(storage storage.Storage, signer *services.URLSigner, cacheControl string) *MediaHandler {
+	return &MediaHandler{
+		storage:      storage,
+		signer:       signer,
+		cacheControl: cacheControl,
+	}
+}
+
+// ServeMedia sends the object under the path's key with a strong ETag,
+// answering conditional requests with 304 and rejecting expired and
+// tampered links to gated images
+func (h *MediaHandler) ServeMedia(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if h.signer.Gated(key) {
+		err := h.signer.Verify(key, c.Query("expires"), c.Query("signature"))
+		switch {
+		case errors.Is(err, services.ErrLinkExpired):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Link has expired"})
+			return
+		case err != nil:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid signature"})
+			return
+		}
+	}
+
+	info, err := h.storage.Stat(key)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Failed to serve %s: %v", key, err)
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+
+	h.setCacheControl(c, key)
+	if notModified(c, `"`+info.ETag+`"`, info.ModTime) {
+		return
+	}
+
+	obj, err := h.storage.Get(key)
+	if err != nil {
+		log.Printf("Failed to serve %s: %v", key, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	defer obj.Close()
+
+	// Both backends return seekable objects, which also gives range
+	// requests
+	if content, ok := obj.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, key, info.ModTime, content)
+		return
+	}
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.DataFromReader(http.StatusOK, info.Size, contentType, obj, nil)
+}
+
+// setCacheControl applies the configured Cache-Control, except that signed
+// links are kept private and only cached until they expire
+func (h *MediaHandler) setCacheControl(c *gin.Context, key string) {
+	if !h.signer.Gated(key) {
+		if h.cacheControl != "" {
+			c.Header("Cache-Control", h.cacheControl)
+		}
+		return
+	}
+	expires, _ := strconv.ParseInt(c.Query("expires"), 10, 64)
+	maxAge := max(0, expires-time.Now().Unix())
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+}
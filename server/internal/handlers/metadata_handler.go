@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"wallpaperio/server/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MetadataHandler struct {
+	metadataSvc *services.MetadataService
+}
+
+func NewMetadataHandler(metadataSvc *services.MetadataService) *MetadataHandler {
+	return &MetadataHandler{
+		metadataSvc: metadataSvc,
+	}
+}
+
+// GetStatus reports the progress of the latest metadata backfill
+func (h *MetadataHandler) GetStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, h.metadataSvc.Status())
+}
+
+// Backfill starts reading the metadata of wallpapers missing it in the
+// background
+func (h *MetadataHandler) Backfill(c *gin.Context) {
+	status, err := h.metadataSvc.StartBackfill()
+	if errors.Is(err, services.ErrBackfillInProgress) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "backfill": status})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, status)
+}
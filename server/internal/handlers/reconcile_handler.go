@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"wallpaperio/server/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReconcileHandler struct {
+	reconcileSvc *services.ReconcileService
+}
+
+func NewReconcileHandler(reconcileSvc *services.ReconcileService) *ReconcileHandler {
+	return &ReconcileHandler{
+		reconcileSvc: reconcileSvc,
+	}
+}
+
+// GetReport reports inconsistencies between Postgres and the vector store
+func (h *ReconcileHandler) GetReport(c *gin.Context) {
+	report, err := h.reconcileSvc.Reconcile(false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to reconcile: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// Repair deletes orphan vectors and re-extracts missing features
+func (h *ReconcileHandler) Repair(c *gin.Context) {
+	report, err := h.reconcileSvc.Reconcile(true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to reconcile: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
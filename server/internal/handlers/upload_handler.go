@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"wallpaperio/server/internal/domain/models/dto"
+	"wallpaperio/server/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadFormOverhead leaves room for the form fields next to the image
+const uploadFormOverhead = 1 << 20
+
+type UploadHandler struct {
+	uploadSvc *services.UploadService
+	signer    *services.URLSigner
+	maxBytes  int64
+}
+
+func NewUploadHandler(uploadSvc *services.UploadService, signer *services.URLSigner, maxBytes int64) *UploadHandler {
+	return &UploadHandler{
+		uploadSvc: uploadSvc,
+		signer:    signer,
+		maxBytes:  maxBytes,
+	}
+}
+
+// UploadWallpaper creates a wallpaper from a multipart upload: the image in
+// field "image" plus "category", "tags" and optionally "on_duplicate"
+func (h *UploadHandler) UploadWallpaper(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxBytes+uploadFormOverhead)
+
+	var req dto.UploadWallpaper
+	if err := c.ShouldBind(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: category is required"})
+		return
+	}
+	req.Tags = splitTags(req.Tags)
+
+	file, header, err := c.Request.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An image file is required"})
+		return
+	}
+	defer file.Close()
+	if header.Size > h.maxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image is too large"})
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, h.maxBytes+1))
+	if err != nil || int64(len(data)) > h.maxBytes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read image"})
+		return
+	}
+
+	wallpaper, err := h.uploadSvc.UploadWallpaper(data, req)
+	var dup *services.DuplicateError
+	switch {
+	case errors.As(err, &dup):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":        dup.Error(),
+			"duplicate_of": dup.ExistingID,
+			"score":        dup.Score,
+		})
+		return
+	case errors.Is(err, services.ErrUnsupportedImage):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrInvalidImage):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrImageTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to upload wallpaper: %v", err)})
+		return
+	}
+
+	h.signer.SignWallpaper(wallpaper)
+	c.JSON(http.StatusCreated, wallpaper)
+}
+
+// splitTags accepts tags as repeated fields, comma separated, or both
+func splitTags(values []string) []string {
+	var tags []string
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"wallpaperio/server/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type VariantHandler struct {
+	variantSvc *services.VariantService
+}
+
+func NewVariantHandler(variantSvc *services.VariantService) *VariantHandler {
+	return &VariantHandler{
+		variantSvc: variantSvc,
+	}
+}
+
+// GetVariants reports the configured image variants and backfill progress
+func (h *VariantHandler) GetVariants(c *gin.Context) {
+	c.JSON(http.StatusOK, h.variantSvc.Report())
+}
+
+// Backfill starts rendering missing and outdated variants in the background
+func (h *VariantHandler) Backfill(c *gin.Context) {
+	status, err := h.variantSvc.StartBackfill()
+	if errors.Is(err, services.ErrBackfillInProgress) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "backfill": status})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, status)
+}
@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"wallpaperio/server/internal/domain/models"
 	"wallpaperio/server/internal/domain/models/dto"
@@ -19,6 +22,7 @@ type WallpaperHandler struct {
 	tagSvc       *services.TagService
 	db           *gorm.DB
 	favoriteSvc  *services.WallpaperFavoriteService
+	signer       *services.URLSigner
 }
 
 type SimilarWallpapersResponse struct {
@@ -26,13 +30,14 @@ type SimilarWallpapersResponse struct {
 	TotalCount int64              `json:"total_count"`
 }
 
-func NewWallpaperHandler(wallpaperSvc *services.WallpaperService, tagSvc *services.TagService, db *gorm.DB) *WallpaperHandler {
+func NewWallpaperHandler(wallpaperSvc *services.WallpaperService, tagSvc *services.TagService, db *gorm.DB, signer *services.URLSigner) *WallpaperHandler {
 	favoriteSvc := services.NewWallpaperFavoriteService(db)
 	return &WallpaperHandler{
 		wallpaperSvc: wallpaperSvc,
 		tagSvc:       tagSvc,
 		db:           db,
 		favoriteSvc:  favoriteSvc,
+		signer:       signer,
 	}
 }
 
@@ -54,19 +59,31 @@ func (h *WallpaperHandler) GetWallpapers(c *gin.Context) {
 		}
 	}
 
-	// Get wallpapers with filters and pagination
-	result, err := h.wallpaperSvc.GetWallpapers(dto.WallpaperFilter{
+	filter := dto.WallpaperFilter{
 		Tags:     tags,
 		Category: category,
 		Search:   search,
 		Limit:    limit,
 		Offset:   offset,
-	})
+	}
+	if err := parseImageFilters(c, &filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Get wallpapers with filters and pagination
+	result, err := h.wallpaperSvc.GetWallpapers(filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wallpapers"})
 		return
 	}
 
+	// Lists carry no Last-Modified: removing a wallpaper changes the list
+	// without making anything in it newer
+	if notModified(c, wallpapersETag(result.Wallpapers, result.Total, h.signer.Version()), time.Time{}) {
+		return
+	}
+	h.signer.SignWallpapers(result.Wallpapers)
 	c.JSON(http.StatusOK, gin.H{
 		"wallpapers": result.Wallpapers,
 		"total":      result.Total,
@@ -75,6 +92,59 @@ func (h *WallpaperHandler) GetWallpapers(c *gin.Context) {
 	})
 }
 
+// parseImageFilters reads min_width, min_height, orientation, aspect (e.g.
+// "16:9", repeated or comma separated), color and color_distance from the
+// query
+func parseImageFilters(c *gin.Context, filter *dto.WallpaperFilter) error {
+	if v := c.Query("min_width"); v != "" {
+		w, err := strconv.Atoi(v)
+		if err != nil || w < 0 {
+			return fmt.Errorf("invalid min_width")
+		}
+		filter.MinWidth = w
+	}
+	if v := c.Query("min_height"); v != "" {
+		h, err := strconv.Atoi(v)
+		if err != nil || h < 0 {
+			return fmt.Errorf("invalid min_height")
+		}
+		filter.MinHeight = h
+	}
+	if v := c.Query("orientation"); v != "" {
+		if !services.ValidOrientation(v) {
+			return fmt.Errorf("orientation must be portrait, landscape or square")
+		}
+		filter.Orientation = v
+	}
+	for _, value := range c.QueryArray("aspect") {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v == "" {
+				continue
+			}
+			ratio, err := services.ParseAspectRatio(v)
+			if err != nil {
+				return err
+			}
+			filter.AspectRatios = append(filter.AspectRatios, ratio)
+		}
+	}
+	if v := c.Query("color"); v != "" {
+		if _, err := services.ParseHexColor(v); err != nil {
+			return err
+		}
+		filter.Color = v
+		filter.ColorDistance = services.DefaultColorDistance
+		if d := c.Query("color_distance"); d != "" {
+			distance, err := strconv.ParseFloat(d, 64)
+			if err != nil || distance <= 0 || distance > services.MaxColorDistance {
+				return fmt.Errorf("color_distance must be between 0 and %d", services.MaxColorDistance)
+			}
+			filter.ColorDistance = distance
+		}
+	}
+	return nil
+}
+
 func (h *WallpaperHandler) DeleteWallpaper(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
@@ -134,6 +204,7 @@ func (h *WallpaperHandler) GetAdjacentWallpaper(c *gin.Context) {
 		}
 	}
 
+	h.signer.SignWallpaper(wallpaper)
 	c.JSON(http.StatusOK, gin.H{
 		"wallpaper":   wallpaper,
 		"is_favorite": isFavorite,
@@ -147,22 +218,76 @@ func (h *WallpaperHandler) GetSimilarWallpapers(c *gin.Context) {
 		return
 	}
 
-	limit := 150 // Default limit
+	filter := dto.SimilarWallpaperFilter{
+		Limit:        150, // Default limit
+		Threshold:    services.DefaultSimilarityThreshold,
+		Category:     c.Query("category"),
+		SameCategory: c.Query("same_category") == "true",
+		Tags:         c.QueryArray("tags"),
+	}
 	if limitStr := c.Query("limit"); limitStr != "" {
 		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
+			filter.Limit = l
+		}
+	}
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			filter.Offset = o
+		}
+	}
+	if thresholdStr := c.Query("threshold"); thresholdStr != "" {
+		if t, err := strconv.ParseFloat(thresholdStr, 32); err == nil {
+			filter.Threshold = float32(t)
 		}
 	}
 
-	wallpapers, err := h.wallpaperSvc.GetSimilarWallpapers(uint(id), limit)
+	wallpapers, err := h.wallpaperSvc.GetSimilarWallpapers(uint(id), filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to fetch similar wallpapers: %v", err)})
 		return
 	}
 
+	h.signer.SignScored(wallpapers)
 	c.JSON(http.StatusOK, wallpapers)
 }
 
+func (h *WallpaperHandler) SemanticSearch(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter q is required"})
+		return
+	}
+
+	limit := 20
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+	threshold := float32(0)
+	if thresholdStr := c.Query("threshold"); thresholdStr != "" {
+		if t, err := strconv.ParseFloat(thresholdStr, 32); err == nil {
+			threshold = float32(t)
+		}
+	}
+
+	results, err := h.wallpaperSvc.SemanticSearch(query, limit, threshold)
+	if errors.Is(err, services.ErrSemanticSearchUnsupported) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to search wallpapers: %v", err)})
+		return
+	}
+
+	h.signer.SignScored(results)
+	c.JSON(http.StatusOK, gin.H{
+		"query":   query,
+		"results": results,
+	})
+}
+
 func (h *WallpaperHandler) CreateWallpaper(c *gin.Context) {
 	var req dto.CreateWallpaper
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -171,14 +296,62 @@ func (h *WallpaperHandler) CreateWallpaper(c *gin.Context) {
 	}
 	// Create wallpaper
 	wallpaper, err := h.wallpaperSvc.CreateWallpaper(req)
+	var dup *services.DuplicateError
+	if errors.As(err, &dup) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":        dup.Error(),
+			"duplicate_of": dup.ExistingID,
+			"score":        dup.Score,
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create wallpaper: %v", err)})
 		return
 	}
 
+	h.signer.SignWallpaper(wallpaper)
 	c.JSON(http.StatusCreated, wallpaper)
 }
 
+func (h *WallpaperHandler) CreateWallpapersBatch(c *gin.Context) {
+	var req dto.BatchCreateWallpapers
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if len(req.Wallpapers) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No wallpapers provided"})
+		return
+	}
+	if len(req.Wallpapers) > services.MaxBatchSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Batch size exceeds maximum of %d", services.MaxBatchSize)})
+		return
+	}
+
+	results, err := h.wallpaperSvc.CreateWallpapersBatch(req.Wallpapers)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create wallpapers: %v", err)})
+		return
+	}
+
+	created := 0
+	for _, r := range results {
+		if r.Success {
+			created++
+		}
+		if r.Wallpaper != nil {
+			h.signer.SignWallpaper(r.Wallpaper)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+		"created": created,
+		"failed":  len(results) - created,
+	})
+}
+
 // favorites
 
 func (h *WallpaperHandler) AddFavorite(c *gin.Context) {
@@ -210,6 +383,28 @@ func (h *WallpaperHandler) RemoveFavorite(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+// GetRecommendedWallpapers returns a personalised feed based on the user's
+// favorites
+func (h *WallpaperHandler) GetRecommendedWallpapers(c *gin.Context) {
+	user := utils.CurrentUser(c)
+
+	limit := 20 // Default limit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+
+	wallpapers, err := h.wallpaperSvc.GetRecommendedWallpapers(user.UserID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get recommendations: %v", err)})
+		return
+	}
+
+	h.signer.SignScored(wallpapers)
+	c.JSON(http.StatusOK, wallpapers)
+}
+
 func (h *WallpaperHandler) GetFavorites(c *gin.Context) {
 	user := utils.CurrentUser(c)
 
@@ -233,6 +428,10 @@ func (h *WallpaperHandler) GetFavorites(c *gin.Context) {
 		return
 	}
 
+	if notModified(c, wallpapersETag(wallpapers, total, h.signer.Version()), time.Time{}) {
+		return
+	}
+	h.signer.SignWallpapers(wallpapers)
 	c.JSON(http.StatusOK, gin.H{
 		"wallpapers": wallpapers,
 		"total":      total,
@@ -241,6 +440,35 @@ func (h *WallpaperHandler) GetFavorites(c *gin.Context) {
 	})
 }
 
+// GetWallpapersByHash looks up the wallpapers whose image has the SHA-256
+// hash in the path
+func (h *WallpaperHandler) GetWallpapersByHash(c *gin.Context) {
+	hash := strings.ToLower(c.Param("sha256"))
+	if !services.ValidContentHash(hash) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid SHA-256 hash"})
+		return
+	}
+
+	wallpapers, err := h.wallpaperSvc.GetWallpapersByHash(hash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wallpapers"})
+		return
+	}
+	if len(wallpapers) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No wallpaper has this hash"})
+		return
+	}
+
+	if notModified(c, wallpapersETag(wallpapers, h.signer.Version()), time.Time{}) {
+		return
+	}
+	h.signer.SignWallpapers(wallpapers)
+	c.JSON(http.StatusOK, gin.H{
+		"hash":       hash,
+		"wallpapers": wallpapers,
+	})
+}
+
 func (h *WallpaperHandler) GetWallpaperInfo(c *gin.Context) {
 	user := utils.CurrentUser(c)
 	wallpaperID, err := strconv.ParseUint(c.Param("id"), 10, 32)
@@ -256,13 +484,27 @@ func (h *WallpaperHandler) GetWallpaperInfo(c *gin.Context) {
 	}
 
 	isFavorite := false
+	lastModified := wallpaper.UpdatedAt
 	if user != nil {
 		var fav models.WallpaperFavorite
 		if err := h.db.Where("user_id = ? AND wallpaper_id = ?", user.UserID, wallpaperID).First(&fav).Error; err == nil {
 			isFavorite = true
 		}
+		// Un-favoriting leaves no newer time behind, so for signed-in users
+		// only the ETag can tell whether the client's copy is current
+		lastModified = time.Time{}
 	}
 
+	// Signed links change without the wallpaper changing, so the same goes
+	// for them
+	if h.signer.Enabled() {
+		lastModified = time.Time{}
+	}
+	etag := weakETag(wallpaper.ID, wallpaper.UpdatedAt.UnixNano(), isFavorite, h.signer.Version())
+	if notModified(c, etag, lastModified) {
+		return
+	}
+	h.signer.SignWallpaper(wallpaper)
 	c.JSON(http.StatusOK, gin.H{
 		"wallpaper":   wallpaper,
 		"is_favorite": isFavorite,
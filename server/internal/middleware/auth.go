@@ -81,3 +81,17 @@ func RequireAuth(jwtService *auth.JWTService) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// OptionalAuth sets the claims of a valid token, if one is sent, and lets
+// the request through either way
+func OptionalAuth(jwtService *auth.JWTService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := c.GetHeader("Authorization"); token != "" {
+			claims, err := jwtService.ValidateToken(strings.TrimPrefix(token, "Bearer "))
+			if err == nil {
+				c.Set("claims", claims)
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CacheControl sets the Cache-Control header of GET and HEAD responses.
+// Responses may depend on the signed-in user, so caches keep them apart by
+// Authorization. An empty value sends no header.
+func CacheControl(value string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if value != "" && (c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead) {
+			c.Header("Cache-Control", value)
+			c.Header("Vary", "Authorization")
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// redactedParams are query parameters that carry credentials
+var redactedParams = []string{"ticket", "token", "signature"}
+
+// Logger is gin's request logger with credentials in the query string
+// replaced, so they do not end up in the logs
+func Logger() gin.HandlerFunc {
+	return gin.LoggerWithFormatter(func(p gin.LogFormatterParams) string {
+		return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+			p.TimeStamp.Format("2006/01/02 - 15:04:05"),
+			p.StatusCode,
+			p.Latency.Truncate(time.Microsecond),
+			p.ClientIP,
+			p.Method,
+			redactQuery(p.Path),
+			p.ErrorMessage,
+		)
+	})
+}
+
+func redactQuery(path string) string {
+	u, err := url.Parse(path)
+	if err != nil || u.RawQuery == "" {
+		return path
+	}
+	query := u.Query()
+	redacted := false
+	for _, name := range redactedParams {
+		if query.Has(name) {
+			query.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return path
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
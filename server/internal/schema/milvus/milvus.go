@@ -2,19 +2,39 @@ package schema
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/milvus-io/milvus-sdk-go/v2/entity"
 )
 
 const (
 	CollectionName = "wallpaper_features"
-	Dimension      = 1280 // EfficientNet feature dimension
+	Dimension      = 1280 // EfficientNet feature dimension, the default model
 )
 
-// GetWallpaperSchema returns the schema for the wallpaper features collection
-func GetWallpaperSchema() *entity.Schema {
+// CollectionNameFor returns the collection holding vectors produced by the
+// given feature model version
+func CollectionNameFor(model, version string) string {
+	name := strings.ToLower(fmt.Sprintf("%s_%s_%s", CollectionName, model, version))
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// PartitionNameFor returns the partition holding the vectors of a category's
+// wallpapers. IDs are used because category names may contain characters
+// Milvus does not allow.
+func PartitionNameFor(categoryID uint) string {
+	return fmt.Sprintf("category_%d", categoryID)
+}
+
+// GetWallpaperSchema returns the schema for a wallpaper features collection
+func GetWallpaperSchema(collection string, dimension int) *entity.Schema {
 	return &entity.Schema{
-		CollectionName: CollectionName,
+		CollectionName: collection,
 		Fields: []*entity.Field{
 			{
 				Name:       "id",
@@ -26,7 +46,7 @@ func GetWallpaperSchema() *entity.Schema {
 				Name:     "features",
 				DataType: entity.FieldTypeFloatVector,
 				TypeParams: map[string]string{
-					"dim": fmt.Sprintf("%d", Dimension),
+					"dim": fmt.Sprintf("%d", dimension),
 				},
 			},
 		},
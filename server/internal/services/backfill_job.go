@@ -0,0 +1,138 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/domain/models/dto"
+
+	"gorm.io/gorm"
+)
+
+var ErrBackfillInProgress = errors.New("backfill is already running")
+
+const (
+	backfillPageSize = 50
+	// maxBackfillErrors caps the errors kept in a backfill's status
+	maxBackfillErrors = 100
+)
+
+// backfillJob visits every wallpaper page by page, either in the background
+// or while the caller waits, and tracks the progress of the latest run
+type backfillJob struct {
+	name string
+	db   *gorm.DB
+	// scope narrows the wallpapers visited or adds preloads
+	scope func(*gorm.DB) *gorm.DB
+	// process handles one wallpaper and reports whether it changed anything
+	process func(*models.Wallpaper) (bool, error)
+
+	running atomic.Bool
+	mu      sync.Mutex
+	status  dto.BackfillStatus
+}
+
+func newBackfillJob(name string, db *gorm.DB, scope func(*gorm.DB) *gorm.DB, process func(*models.Wallpaper) (bool, error)) *backfillJob {
+	return &backfillJob{
+		name:    name,
+		db:      db,
+		scope:   scope,
+		process: process,
+	}
+}
+
+func (j *backfillJob) Status() dto.BackfillStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := j.status
+	status.Errors = append([]string(nil), j.status.Errors...)
+	return status
+}
+
+// Start runs the backfill in the background
+func (j *backfillJob) Start() (dto.BackfillStatus, error) {
+	if !j.running.CompareAndSwap(false, true) {
+		return j.Status(), ErrBackfillInProgress
+	}
+	j.reset()
+
+	go func() {
+		defer j.running.Store(false)
+		if err := j.run(); err != nil {
+			log.Printf("%s backfill failed: %v", j.name, err)
+		}
+	}()
+
+	return j.Status(), nil
+}
+
+// Run runs the backfill and waits for the result
+func (j *backfillJob) Run() (dto.BackfillStatus, error) {
+	if !j.running.CompareAndSwap(false, true) {
+		return j.Status(), ErrBackfillInProgress
+	}
+	defer j.running.Store(false)
+	j.reset()
+
+	err := j.run()
+	return j.Status(), err
+}
+
+func (j *backfillJob) reset() {
+	now := time.Now()
+	j.mu.Lock()
+	j.status = dto.BackfillStatus{Running: true, StartedAt: &now}
+	j.mu.Unlock()
+}
+
+func (j *backfillJob) run() error {
+	defer func() {
+		now := time.Now()
+		j.mu.Lock()
+		j.status.Running = false
+		j.status.FinishedAt = &now
+		j.mu.Unlock()
+	}()
+
+	lastID := uint(0)
+	for {
+		var page []models.Wallpaper
+		err := j.scope(j.db).
+			Where("wallpapers.id > ?", lastID).
+			Order("wallpapers.id").
+			Limit(backfillPageSize).
+			Find(&page).Error
+		if err != nil {
+			return fmt.Errorf("failed to list wallpapers: %w", err)
+		}
+		if len(page) == 0 {
+			return nil
+		}
+
+		for i := range page {
+			changed, err := j.process(&page[i])
+			j.record(page[i].ID, changed, err)
+		}
+		lastID = page[len(page)-1].ID
+	}
+}
+
+func (j *backfillJob) record(wallpaperID uint, changed bool, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Scanned++
+	switch {
+	case err != nil:
+		j.status.Failed++
+		if len(j.status.Errors) < maxBackfillErrors {
+			j.status.Errors = append(j.status.Errors, fmt.Sprintf("wallpaper %d: %v", wallpaperID, err))
+		}
+	case changed:
+		j.status.Updated++
+	}
+}
@@ -0,0 +1,362 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/services/storage"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// blobKeyPrefix is the storage prefix of content-addressed originals
+	blobKeyPrefix = "blobs/"
+	// derivedKeyPrefix is the storage prefix of images derived from an
+	// original, such as thumbs and other variants
+	derivedKeyPrefix = "variants/"
+)
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ValidContentHash reports whether hash is a lowercase hex SHA-256
+func ValidContentHash(hash string) bool {
+	return sha256Pattern.MatchString(hash)
+}
+
+// BlobStore keeps original images in storage under their SHA-256 hash, and
+// images derived from them under that hash and how they were derived, so
+// an image is stored once however many wallpapers use it. ImageBlob rows
+// count the references to each blob: one per wallpaper image URL or
+// variant using it, taken in the transaction creating that and dropped in
+// the one deleting it, plus one held from Put until then. A blob's file is
+// only deleted with its row locked and no references left.
+type BlobStore struct {
+	db      *gorm.DB
+	storage storage.Storage
+}
+
+func NewBlobStore(db *gorm.DB, storage storage.Storage) *BlobStore {
+	return &BlobStore{
+		db:      db,
+		storage: storage,
+	}
+}
+
+// blobKey shards blobs by the first byte of their hash
+func blobKey(hash, ext string) string {
+	return blobKeyPrefix + hash[:2] + "/" + hash + ext
+}
+
+// derivedKey names the image derived from the original with sourceHash;
+// name says how it was derived, so identical originals share it
+func derivedKey(sourceHash, name, ext string) string {
+	return derivedKeyPrefix + sourceHash[:2] + "/" + sourceHash + "/" + name + ext
+}
+
+// blobHash returns the hash the blob under key is counted by, or false if
+// key is not a blob. Derived images are counted by the hash of their key.
+func blobHash(key string) (string, bool) {
+	switch {
+	case strings.HasPrefix(key, blobKeyPrefix):
+		name := path.Base(key)
+		hash := strings.TrimSuffix(name, path.Ext(name))
+		return hash, ValidContentHash(hash)
+	case strings.HasPrefix(key, derivedKeyPrefix):
+		// Variants stored before they were shared sit under the wallpaper ID
+		if !ValidContentHash(path.Base(path.Dir(key))) {
+			return "", false
+		}
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:]), true
+	}
+	return "", false
+}
+
+// Put stores the image unless a blob with the same content exists and
+// returns its key and what was read from it. The blob is referenced until
+// unpin is called, which the caller must do once the wallpaper using it has
+// been created or has failed to be.
+func (b *BlobStore) Put(data []byte, contentType string) (string, *imageInfo, error) {
+	info, err := inspectImage(bytes.NewReader(data))
+	if err != nil {
+		return "", nil, err
+	}
+
+	// The extension follows the content so identical files get one key
+	ext, ok := uploadImageTypes[info.mimeType]
+	if !ok {
+		ext = ".bin"
+	}
+	blob := models.ImageBlob{
+		Hash:     info.contentHash,
+		Key:      blobKey(info.contentHash, ext),
+		Bytes:    info.bytes,
+		MimeType: info.mimeType,
+		RefCount: 1,
+	}
+	// The key of an existing blob is kept even if its extension differs
+	key, stored, err := b.pin(&blob)
+	if err != nil {
+		return "", nil, err
+	}
+	if !stored {
+		if err := b.storage.Put(key, bytes.NewReader(data), contentType); err != nil {
+			b.unpin(key)
+			return "", nil, fmt.Errorf("failed to store %s: %w", key, err)
+		}
+	}
+	return key, info, nil
+}
+
+// PutDerived stores the image derived from the original with sourceHash
+// under a key made from the hash and name, unless it is stored already, and
+// returns the key and the image's size. render is only called if the image
+// must be stored. Like with Put the caller must unpin the key.
+func (b *BlobStore) PutDerived(sourceHash, name, ext, contentType string, render func() ([]byte, error)) (string, int64, error) {
+	key := derivedKey(sourceHash, name, ext)
+	hash, _ := blobHash(key)
+	blob := models.ImageBlob{
+		Hash:     hash,
+		Key:      key,
+		MimeType: contentType,
+		RefCount: 1,
+	}
+	_, stored, err := b.pin(&blob)
+	if err != nil {
+		return "", 0, err
+	}
+	if stored {
+		info, err := b.storage.Stat(key)
+		if err != nil {
+			b.unpin(key)
+			return "", 0, fmt.Errorf("failed to stat %s: %w", key, err)
+		}
+		return key, info.Size, nil
+	}
+
+	data, err := render()
+	if err == nil {
+		if err = b.storage.Put(key, bytes.NewReader(data), contentType); err != nil {
+			err = fmt.Errorf("failed to store %s: %w", key, err)
+		}
+	}
+	if err != nil {
+		b.unpin(key)
+		return "", 0, err
+	}
+	size := int64(len(data))
+	if err := b.db.Model(&blob).Where("hash = ?", hash).UpdateColumn("bytes", size).Error; err != nil {
+		log.Printf("Failed to record the size of blob %s: %v", hash, err)
+	}
+	return key, size, nil
+}
+
+// pin references the blob, creating its row if needed, and reports its key
+// and whether its file is stored. Referencing the blob first keeps its file
+// from being deleted while it is checked and stored; this waits for a
+// deletion in progress.
+func (b *BlobStore) pin(blob *models.ImageBlob) (string, bool, error) {
+	err := b.db.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "hash"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("image_blobs.ref_count + 1")}),
+		},
+		clause.Returning{Columns: []clause.Column{{Name: "key"}}},
+	).Create(blob).Error
+	if err != nil {
+		return "", false, fmt.Errorf("failed to reference blob: %w", err)
+	}
+
+	exists, err := b.storage.Exists(blob.Key)
+	if err != nil {
+		b.unpin(blob.Key)
+		return "", false, fmt.Errorf("failed to check %s: %w", blob.Key, err)
+	}
+	return blob.Key, exists, nil
+}
+
+// imageURLs returns the wallpaper's own image URLs, which may be blobs
+func imageURLs(wallpaper *models.Wallpaper) []string {
+	urls := []string{wallpaper.ImageURL, wallpaper.ImageThumbURL}
+	if wallpaper.ImageMediumURL != nil {
+		urls = append(urls, *wallpaper.ImageMediumURL)
+	}
+	return urls
+}
+
+// acquire references the blobs the wallpaper's images are stored in, if
+// any, within tx
+func (b *BlobStore) acquire(tx *gorm.DB, wallpaper *models.Wallpaper) error {
+	for i, url := range imageURLs(wallpaper) {
+		var err error
+		if i == 0 {
+			err = b.reference(tx, url, wallpaper.Bytes, wallpaper.MimeType)
+		} else {
+			err = b.retain(tx, url)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retain references the blob under the URL, if it is one, within tx
+func (b *BlobStore) retain(tx *gorm.DB, url string) error {
+	return b.reference(tx, url, 0, "")
+}
+
+// reference counts a reference to the blob under the URL, recording the
+// given size and type if it was not counted before
+func (b *BlobStore) reference(tx *gorm.DB, url string, size int64, mimeType string) error {
+	key, ok := b.storage.KeyForURL(url)
+	if !ok {
+		return nil
+	}
+	hash, ok := blobHash(key)
+	if !ok {
+		return nil
+	}
+
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("image_blobs.ref_count + 1")}),
+	}).Create(&models.ImageBlob{
+		Hash:     hash,
+		Key:      key,
+		Bytes:    size,
+		MimeType: mimeType,
+		RefCount: 1,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to reference blob: %w", err)
+	}
+	return nil
+}
+
+// release drops the references of the wallpaper and its variants to their
+// blobs within tx. It returns the keys of blobs that may have lost their
+// last reference; pass them to remove once tx commits.
+func (b *BlobStore) release(tx *gorm.DB, wallpaper *models.Wallpaper) ([]string, error) {
+	var unused []string
+	urls := imageURLs(wallpaper)
+	for _, v := range wallpaper.Variants {
+		urls = append(urls, v.URL)
+	}
+	for i, url := range urls {
+		key, counted, err := b.drop(tx, url)
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			continue
+		}
+		if !counted && i == 0 {
+			// An original from before blobs were counted; fall back to
+			// looking for other wallpapers using it
+			var others int64
+			if err := tx.Model(&models.Wallpaper{}).Where("image_url = ? AND id <> ?", wallpaper.ImageURL, wallpaper.ID).Count(&others).Error; err != nil {
+				return nil, fmt.Errorf("failed to count blob users: %w", err)
+			}
+			if others > 0 {
+				continue
+			}
+		}
+		unused = append(unused, key)
+	}
+	return unused, nil
+}
+
+// drop removes a reference to the blob under the URL within tx. It returns
+// the blob's key if that may have been the last reference, and whether the
+// blob was counted at all.
+func (b *BlobStore) drop(tx *gorm.DB, url string) (string, bool, error) {
+	key, ok := b.storage.KeyForURL(url)
+	if !ok {
+		return "", false, nil
+	}
+	hash, ok := blobHash(key)
+	if !ok {
+		return "", false, nil
+	}
+
+	var blob models.ImageBlob
+	result := tx.Model(&blob).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "ref_count"}}}).
+		Where("hash = ?", hash).
+		UpdateColumn("ref_count", gorm.Expr("ref_count - 1"))
+	if result.Error != nil {
+		return "", false, fmt.Errorf("failed to release blob: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return key, false, nil
+	}
+	if blob.RefCount > 0 {
+		return "", true, nil
+	}
+	return key, true, nil
+}
+
+// unpin drops the reference Put or PutDerived took, removing the blob if
+// it was the last
+func (b *BlobStore) unpin(key string) {
+	hash, ok := blobHash(key)
+	if !ok {
+		return
+	}
+	var blob models.ImageBlob
+	err := b.db.Model(&blob).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "ref_count"}}}).
+		Where("hash = ?", hash).
+		UpdateColumn("ref_count", gorm.Expr("ref_count - 1")).Error
+	if err != nil {
+		log.Printf("Failed to release blob %s: %v", hash, err)
+		return
+	}
+	if blob.RefCount <= 0 {
+		b.remove(key)
+	}
+}
+
+// remove deletes the blob under key if nothing references it any more. The
+// blob's row is locked while the file is deleted, so a concurrent Put
+// either references the blob first and it is kept, or waits and stores the
+// file again.
+func (b *BlobStore) remove(key string) {
+	hash, ok := blobHash(key)
+	if !ok {
+		return
+	}
+	err := b.db.Transaction(func(tx *gorm.DB) error {
+		// Blobs that were never counted have no row to lock yet
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.ImageBlob{Hash: hash, Key: key}).Error; err != nil {
+			return fmt.Errorf("failed to lock blob: %w", err)
+		}
+		var blob models.ImageBlob
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("hash = ?", hash).First(&blob).Error; err != nil {
+			return fmt.Errorf("failed to lock blob: %w", err)
+		}
+		if blob.RefCount > 0 {
+			return nil
+		}
+		if err := b.storage.Delete(key); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+		if err := tx.Delete(&blob).Error; err != nil {
+			return fmt.Errorf("failed to delete blob: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to remove blob %s: %v", hash, err)
+	}
+}
@@ -1,21 +1,31 @@
 package services
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"image"
+	"log"
+	"net/http"
 
 	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/services/storage"
 
 	"gorm.io/gorm"
 )
 
+var ErrCategoryNotFound = errors.New("category not found")
+
 type CategoryService struct {
 	db      *gorm.DB
+	storage storage.Storage
 	baseURL string
 }
 
-func NewCategoryService(db *gorm.DB, baseURL string) *CategoryService {
+func NewCategoryService(db *gorm.DB, storage storage.Storage, baseURL string) *CategoryService {
 	return &CategoryService{
 		db:      db,
+		storage: storage,
 		baseURL: baseURL,
 	}
 }
@@ -27,12 +37,59 @@ func (s *CategoryService) GetAllCategories() ([]models.Category, error) {
 		return nil, err
 	}
 
-	// Add base URL to image paths
+	// Add base URL to image paths in the generator's static directory;
+	// images in storage are stored with their full URL
 	for i := range categories {
-		if categories[i].ImageURL != "" {
+		if categories[i].ImageURL != "" && !isAbsoluteURL(categories[i].ImageURL) {
 			categories[i].ImageURL = fmt.Sprintf("%s/%s", s.baseURL, categories[i].ImageURL)
 		}
 	}
 
 	return categories, nil
 }
+
+// SetCategoryImage stores an image for the category and replaces its
+// previous one
+func (s *CategoryService) SetCategoryImage(id uint, data []byte) (*models.Category, error) {
+	var category models.Category
+	if err := s.db.First(&category, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCategoryNotFound
+		}
+		return nil, fmt.Errorf("failed to find category: %w", err)
+	}
+
+	contentType := http.DetectContentType(data)
+	ext, ok := uploadImageTypes[contentType]
+	if !ok {
+		return nil, ErrUnsupportedImage
+	}
+	if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	name, err := randomName()
+	if err != nil {
+		return nil, err
+	}
+	key := "categories/" + name + ext
+	if err := s.storage.Put(key, bytes.NewReader(data), contentType); err != nil {
+		return nil, fmt.Errorf("failed to store image: %w", err)
+	}
+
+	previous := category.ImageURL
+	category.ImageURL = s.storage.URL(key)
+	if err := s.db.Model(&category).Update("image_url", category.ImageURL).Error; err != nil {
+		if err := s.storage.Delete(key); err != nil {
+			log.Printf("Failed to delete %s: %v", key, err)
+		}
+		return nil, fmt.Errorf("failed to update category: %w", err)
+	}
+
+	if oldKey, ok := s.storage.KeyForURL(previous); ok {
+		if err := s.storage.Delete(oldKey); err != nil {
+			log.Printf("Failed to delete %s: %v", oldKey, err)
+		}
+	}
+	return &category, nil
+}
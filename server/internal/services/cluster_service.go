@@ -0,0 +1,386 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/domain/models/dto"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrClusteringInProgress = errors.New("clustering is already running")
+	ErrClusterNotFound      = errors.New("cluster not found")
+)
+
+const (
+	// MaxClusters is the largest number of clusters a run may ask for
+	MaxClusters = 200
+	// DefaultClusterSamples is how many typical wallpapers are shown per cluster
+	DefaultClusterSamples = 8
+
+	clusterMaxIterations = 50
+	clusterTopLabels     = 5
+)
+
+// ClusterService groups wallpapers into visual clusters with k-means over
+// their feature vectors, so editors can label whole groups at once
+type ClusterService struct {
+	db            *gorm.DB
+	tagSvc        *TagService
+	featureModels *FeatureModelService
+	running       atomic.Bool
+}
+
+func NewClusterService(db *gorm.DB, tagSvc *TagService, featureModels *FeatureModelService) *ClusterService {
+	return &ClusterService{
+		db:            db,
+		tagSvc:        tagSvc,
+		featureModels: featureModels,
+	}
+}
+
+// StartRun starts a clustering run in the background and returns it. A k of
+// 0 picks the number of clusters from the catalogue size.
+func (s *ClusterService) StartRun(k int) (*models.ClusterRun, error) {
+	run, err := s.prepareRun(k)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer s.running.Store(false)
+		if err := s.execute(run); err != nil {
+			log.Printf("Clustering run %d failed: %v", run.ID, err)
+		}
+	}()
+
+	return run, nil
+}
+
+// Run clusters the catalogue and waits for the result
+func (s *ClusterService) Run(k int) (*models.ClusterRun, error) {
+	run, err := s.prepareRun(k)
+	if err != nil {
+		return nil, err
+	}
+	defer s.running.Store(false)
+
+	return run, s.execute(run)
+}
+
+func (s *ClusterService) prepareRun(k int) (*models.ClusterRun, error) {
+	if k < 0 || k > MaxClusters {
+		return nil, fmt.Errorf("k must be between 0 and %d", MaxClusters)
+	}
+	if !s.running.CompareAndSwap(false, true) {
+		return nil, ErrClusteringInProgress
+	}
+
+	run := &models.ClusterRun{K: k, Status: models.ClusterRunRunning}
+	if err := s.db.Create(run).Error; err != nil {
+		s.running.Store(false)
+		return nil, fmt.Errorf("failed to create cluster run: %w", err)
+	}
+	return run, nil
+}
+
+func (s *ClusterService) execute(run *models.ClusterRun) error {
+	err := s.cluster(run)
+	if err != nil {
+		run.Status = models.ClusterRunFailed
+		run.Error = err.Error()
+		if saveErr := s.db.Save(run).Error; saveErr != nil {
+			log.Printf("Failed to record failure of clustering run %d: %v", run.ID, saveErr)
+		}
+	}
+	return err
+}
+
+// cluster loads the active model's vectors, runs k-means and replaces the
+// previous clusters with the new ones
+func (s *ClusterService) cluster(run *models.ClusterRun) error {
+	wallpaperIDs, vectors, err := s.loadVectors(run)
+	if err != nil {
+		return err
+	}
+	if len(vectors) == 0 {
+		return fmt.Errorf("no wallpaper vectors to cluster")
+	}
+
+	k := run.K
+	if k == 0 {
+		k = defaultClusterCount(len(vectors))
+	}
+	if k > len(vectors) {
+		k = len(vectors)
+	}
+	run.K = k
+	run.Total = len(vectors)
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	assign, dist, iterations := kmeans(vectors, k, clusterMaxIterations, rng)
+	run.Iterations = iterations
+
+	return s.saveClusters(run, wallpaperIDs, assign, dist)
+}
+
+// loadVectors returns the normalised vector of every wallpaper embedded with
+// the active feature model. The model is only held while scanning so a
+// re-embedding can switch over during the k-means itself.
+func (s *ClusterService) loadVectors(run *models.ClusterRun) ([]uint, [][]float32, error) {
+	model, store, release := s.featureModels.Acquire()
+	defer release()
+	run.FeatureModel = model.Key()
+
+	var wallpapers []models.Wallpaper
+	if err := s.db.Select("id", "feature_id").Where("feature_model = ?", model.Key()).Find(&wallpapers).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to list wallpapers: %w", err)
+	}
+	byFeature := make(map[int64]uint, len(wallpapers))
+	for _, w := range wallpapers {
+		byFeature[w.FeatureID] = w.ID
+	}
+
+	var wallpaperIDs []uint
+	var vectors [][]float32
+	err := store.ScanFeatures(func(featureID int64, features []float32) error {
+		wallpaperID, ok := byFeature[featureID]
+		if !ok {
+			// Orphan vector; the reconciler cleans these up
+			return nil
+		}
+		normalize(features)
+		wallpaperIDs = append(wallpaperIDs, wallpaperID)
+		vectors = append(vectors, features)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load features: %w", err)
+	}
+
+	return wallpaperIDs, vectors, nil
+}
+
+// defaultClusterCount uses the sqrt(n/2) rule of thumb, within [2, MaxClusters]
+func defaultClusterCount(n int) int {
+	k := int(math.Sqrt(float64(n) / 2))
+	if k < 2 {
+		k = 2
+	}
+	if k > MaxClusters {
+		k = MaxClusters
+	}
+	return k
+}
+
+// saveClusters stores the run's clusters and drops those of earlier runs in
+// one transaction
+func (s *ClusterService) saveClusters(run *models.ClusterRun, wallpaperIDs []uint, assign []int, dist []float32) error {
+	sizes := make([]int, run.K)
+	for _, c := range assign {
+		sizes[c]++
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		clusterIDs := make([]uint, run.K)
+		for c, size := range sizes {
+			if size == 0 {
+				continue
+			}
+			cluster := models.VisualCluster{RunID: run.ID, Size: size}
+			if err := tx.Create(&cluster).Error; err != nil {
+				return fmt.Errorf("failed to create cluster: %w", err)
+			}
+			clusterIDs[c] = cluster.ID
+		}
+
+		members := make([]models.VisualClusterMember, len(wallpaperIDs))
+		for i, wallpaperID := range wallpaperIDs {
+			members[i] = models.VisualClusterMember{
+				ClusterID:   clusterIDs[assign[i]],
+				WallpaperID: wallpaperID,
+				Distance:    dist[i],
+			}
+		}
+		if err := tx.CreateInBatches(members, 1000).Error; err != nil {
+			return fmt.Errorf("failed to create cluster members: %w", err)
+		}
+
+		previous := tx.Model(&models.VisualCluster{}).Select("id").Where("run_id <> ?", run.ID)
+		if err := tx.Where("cluster_id IN (?)", previous).Delete(&models.VisualClusterMember{}).Error; err != nil {
+			return fmt.Errorf("failed to delete previous cluster members: %w", err)
+		}
+		if err := tx.Where("run_id <> ?", run.ID).Delete(&models.VisualCluster{}).Error; err != nil {
+			return fmt.Errorf("failed to delete previous clusters: %w", err)
+		}
+
+		run.Status = models.ClusterRunReady
+		if err := tx.Save(run).Error; err != nil {
+			return fmt.Errorf("failed to update cluster run: %w", err)
+		}
+		return nil
+	})
+}
+
+// Report lists the clusters of the latest successful run, largest first,
+// with their most typical wallpapers and the tags and categories they
+// already carry
+func (s *ClusterService) Report(samples int) (*dto.ClusterReport, error) {
+	if samples <= 0 {
+		samples = DefaultClusterSamples
+	}
+	report := &dto.ClusterReport{Clusters: []dto.ClusterSummary{}}
+
+	var latest models.ClusterRun
+	err := s.db.Order("id DESC").First(&latest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return report, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch cluster runs: %w", err)
+	}
+	report.LatestRun = &latest
+
+	var run models.ClusterRun
+	err = s.db.Where("status = ?", models.ClusterRunReady).Order("id DESC").First(&run).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return report, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch cluster runs: %w", err)
+	}
+	report.Run = &run
+
+	var clusters []models.VisualCluster
+	if err := s.db.Where("run_id = ?", run.ID).Order("size DESC, id").Find(&clusters).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch clusters: %w", err)
+	}
+
+	for _, cluster := range clusters {
+		summary, err := s.summarize(cluster, samples)
+		if err != nil {
+			return nil, err
+		}
+		report.Clusters = append(report.Clusters, *summary)
+	}
+
+	return report, nil
+}
+
+func (s *ClusterService) summarize(cluster models.VisualCluster, samples int) (*dto.ClusterSummary, error) {
+	summary := &dto.ClusterSummary{
+		ID:         cluster.ID,
+		Size:       cluster.Size,
+		Samples:    []models.Wallpaper{},
+		Tags:       []dto.ClusterLabelCount{},
+		Categories: []dto.ClusterLabelCount{},
+	}
+
+	err := s.db.Joins("JOIN visual_cluster_members ON visual_cluster_members.wallpaper_id = wallpapers.id").
+		Where("visual_cluster_members.cluster_id = ?", cluster.ID).
+		Order("visual_cluster_members.distance").
+		Limit(samples).
+		Preload("Tags").
+		Preload("Category").
+		Find(&summary.Samples).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch cluster samples: %w", err)
+	}
+
+	err = s.db.Table("visual_cluster_members").
+		Select("tags.id, tags.name, COUNT(*) AS count").
+		Joins("JOIN wallpaper_tags ON wallpaper_tags.wallpaper_id = visual_cluster_members.wallpaper_id").
+		Joins("JOIN tags ON tags.id = wallpaper_tags.tag_id").
+		Where("visual_cluster_members.cluster_id = ?", cluster.ID).
+		Group("tags.id, tags.name").
+		Order("count DESC, tags.name").
+		Limit(clusterTopLabels).
+		Scan(&summary.Tags).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch cluster tags: %w", err)
+	}
+
+	err = s.db.Table("visual_cluster_members").
+		Select("categories.id, categories.name, COUNT(*) AS count").
+		Joins("JOIN wallpapers ON wallpapers.id = visual_cluster_members.wallpaper_id").
+		Joins("JOIN categories ON categories.id = wallpapers.category_id").
+		Where("visual_cluster_members.cluster_id = ?", cluster.ID).
+		Group("categories.id, categories.name").
+		Order("count DESC, categories.name").
+		Limit(clusterTopLabels).
+		Scan(&summary.Categories).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch cluster categories: %w", err)
+	}
+
+	return summary, nil
+}
+
+// Assign sets the category and/or adds the tags to every wallpaper in a
+// cluster. Existing tags are kept.
+func (s *ClusterService) Assign(clusterID uint, req dto.ClusterAssignRequest) (*dto.ClusterAssignResult, error) {
+	if req.Category == "" && len(req.Tags) == 0 {
+		return nil, fmt.Errorf("category or tags are required")
+	}
+
+	var cluster models.VisualCluster
+	err := s.db.First(&cluster, clusterID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrClusterNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch cluster: %w", err)
+	}
+
+	var wallpapers []models.Wallpaper
+	members := s.db.Model(&models.VisualClusterMember{}).Select("wallpaper_id").Where("cluster_id = ?", clusterID)
+	if err := s.db.Select("id").Where("id IN (?)", members).Find(&wallpapers).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch cluster wallpapers: %w", err)
+	}
+
+	var tags []models.Tag
+	if len(req.Tags) > 0 {
+		tags, err = s.tagSvc.GetOrCreateTags(req.Tags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process tags: %w", err)
+		}
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if req.Category != "" {
+			var category models.Category
+			if err := tx.Where("name = ?", req.Category).FirstOrCreate(&category, models.Category{Name: req.Category}).Error; err != nil {
+				return fmt.Errorf("failed to process category: %w", err)
+			}
+			if err := tx.Model(&models.Wallpaper{}).Where("id IN (?)", members).Update("category_id", category.ID).Error; err != nil {
+				return fmt.Errorf("failed to update categories: %w", err)
+			}
+		}
+
+		if len(tags) == 0 {
+			return nil
+		}
+		for i := range wallpapers {
+			if err := tx.Model(&wallpapers[i]).Association("Tags").Append(tags); err != nil {
+				return fmt.Errorf("failed to add tags to wallpaper %d: %w", wallpapers[i].ID, err)
+			}
+		}
+		if err := touchWallpapers(tx, members); err != nil {
+			return fmt.Errorf("failed to update wallpapers: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.ClusterAssignResult{ClusterID: clusterID, Wallpapers: int64(len(wallpapers))}, nil
+}
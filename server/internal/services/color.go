@@ -0,0 +1,254 @@
+package services
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// paletteSize is the number of dominant colours kept per wallpaper
+	paletteSize = 5
+	// paletteSampleSize is the longest side of the copy the palette is
+	// computed from
+	paletteSampleSize = 64
+	paletteIterations = 15
+)
+
+// Lab is a colour in CIELAB (D65), where Euclidean distance approximates
+// perceived difference (ΔE 1976)
+type Lab struct {
+	L, A, B float64
+}
+
+// DeltaE returns the perceptual distance between two colours
+func (c Lab) DeltaE(o Lab) float64 {
+	dl, da, db := c.L-o.L, c.A-o.A, c.B-o.B
+	return math.Sqrt(dl*dl + da*da + db*db)
+}
+
+// Hex returns the colour as #rrggbb, clamped to the sRGB gamut
+func (c Lab) Hex() string {
+	r, g, b := labToRGB(c)
+	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+}
+
+// ParseHexColor parses a colour written as #rrggbb or rrggbb
+func ParseHexColor(value string) (Lab, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) != 6 {
+		return Lab{}, fmt.Errorf("color %q is not #rrggbb", value)
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return Lab{}, fmt.Errorf("color %q is not #rrggbb", value)
+	}
+	return rgbToLab(uint8(rgb>>16), uint8(rgb>>8), uint8(rgb)), nil
+}
+
+// PaletteColor is one dominant colour and the share of the image it covers
+type PaletteColor struct {
+	Color  Lab
+	Weight float64
+}
+
+// extractPalette returns up to paletteSize dominant colours of the image,
+// heaviest first, by k-means clustering its pixels in Lab space
+func extractPalette(img image.Image) []PaletteColor {
+	pixels := samplePixels(img)
+	if len(pixels) == 0 {
+		return nil
+	}
+
+	k := paletteSize
+	if len(pixels) < k {
+		k = len(pixels)
+	}
+	centroids := seedPalette(pixels, k)
+	assign := make([]int, len(pixels))
+	counts := make([]int, k)
+
+	for iter := 0; iter < paletteIterations; iter++ {
+		changed := false
+		for i, p := range pixels {
+			best, bestDist := 0, math.Inf(1)
+			for c, centroid := range centroids {
+				if d := p.DeltaE(centroid); d < bestDist {
+					best, bestDist = c, d
+				}
+			}
+			if assign[i] != best {
+				assign[i] = best
+				changed = true
+			}
+		}
+		if !changed && iter > 0 {
+			break
+		}
+
+		sums := make([]Lab, k)
+		for c := range counts {
+			counts[c] = 0
+		}
+		for i, p := range pixels {
+			c := assign[i]
+			counts[c]++
+			sums[c].L += p.L
+			sums[c].A += p.A
+			sums[c].B += p.B
+		}
+		for c := range centroids {
+			if counts[c] > 0 {
+				n := float64(counts[c])
+				centroids[c] = Lab{sums[c].L / n, sums[c].A / n, sums[c].B / n}
+			}
+		}
+	}
+
+	palette := make([]PaletteColor, 0, k)
+	for c, centroid := range centroids {
+		if counts[c] > 0 {
+			palette = append(palette, PaletteColor{
+				Color:  centroid,
+				Weight: float64(counts[c]) / float64(len(pixels)),
+			})
+		}
+	}
+	sort.Slice(palette, func(i, j int) bool { return palette[i].Weight > palette[j].Weight })
+	return palette
+}
+
+// samplePixels scales the image down and returns its opaque pixels in Lab
+func samplePixels(img image.Image) []Lab {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil
+	}
+	w, h := bounds.Dx(), bounds.Dy()
+	if w > h && w > paletteSampleSize {
+		w, h = paletteSampleSize, max(1, h*paletteSampleSize/w)
+	} else if h > paletteSampleSize {
+		w, h = max(1, w*paletteSampleSize/h), paletteSampleSize
+	}
+	small := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, bounds, draw.Src, nil)
+
+	pixels := make([]Lab, 0, w*h)
+	for i := 0; i < len(small.Pix); i += 4 {
+		if small.Pix[i+3] < 128 {
+			continue
+		}
+		pixels = append(pixels, rgbToLab(small.Pix[i], small.Pix[i+1], small.Pix[i+2]))
+	}
+	return pixels
+}
+
+// seedPalette picks k starting colours deterministically, each the pixel
+// furthest from those picked so far, starting from the mean colour's
+// nearest pixel
+func seedPalette(pixels []Lab, k int) []Lab {
+	var mean Lab
+	for _, p := range pixels {
+		mean.L += p.L
+		mean.A += p.A
+		mean.B += p.B
+	}
+	n := float64(len(pixels))
+	mean = Lab{mean.L / n, mean.A / n, mean.B / n}
+
+	closest := make([]float64, len(pixels))
+	first := 0
+	for i, p := range pixels {
+		closest[i] = p.DeltaE(mean)
+		if closest[i] < closest[first] {
+			first = i
+		}
+	}
+
+	centroids := []Lab{pixels[first]}
+	for i, p := range pixels {
+		closest[i] = p.DeltaE(pixels[first])
+	}
+	for len(centroids) < k {
+		next := 0
+		for i, d := range closest {
+			if d > closest[next] {
+				next = i
+			}
+		}
+		centroids = append(centroids, pixels[next])
+		for i, p := range pixels {
+			if d := p.DeltaE(pixels[next]); d < closest[i] {
+				closest[i] = d
+			}
+		}
+	}
+	return centroids
+}
+
+// D65 reference white
+const (
+	whiteX = 0.95047
+	whiteY = 1.0
+	whiteZ = 1.08883
+)
+
+func rgbToLab(r, g, b uint8) Lab {
+	lr, lg, lb := srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)
+	x := (0.4124564*lr + 0.3575761*lg + 0.1804375*lb) / whiteX
+	y := (0.2126729*lr + 0.7151522*lg + 0.0721750*lb) / whiteY
+	z := (0.0193339*lr + 0.1191920*lg + 0.9503041*lb) / whiteZ
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return Lab{
+		L: 116*fy - 16,
+		A: 500 * (fx - fy),
+		B: 200 * (fy - fz),
+	}
+}
+
+func labToRGB(c Lab) (uint8, uint8, uint8) {
+	fy := (c.L + 16) / 116
+	fx := fy + c.A/500
+	fz := fy - c.B/200
+	x, y, z := labFInv(fx)*whiteX, labFInv(fy)*whiteY, labFInv(fz)*whiteZ
+	lr := 3.2404542*x - 1.5371385*y - 0.4985314*z
+	lg := -0.9692660*x + 1.8760108*y + 0.0415560*z
+	lb := 0.0556434*x - 0.2040259*y + 1.0572252*z
+	return linearToSRGB(lr), linearToSRGB(lg), linearToSRGB(lb)
+}
+
+func srgbToLinear(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(c float64) uint8 {
+	if c <= 0.0031308 {
+		c *= 12.92
+	} else {
+		c = 1.055*math.Pow(c, 1/2.4) - 0.055
+	}
+	return uint8(math.Round(math.Max(0, math.Min(1, c)) * 255))
+}
+
+func labF(t float64) float64 {
+	if t > 216.0/24389 {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27*t + 16) / 116
+}
+
+func labFInv(t float64) float64 {
+	if t3 := t * t * t; t3 > 216.0/24389 {
+		return t3
+	}
+	return (116*t - 16) / (24389.0 / 27)
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"fmt"
+	"image"
+
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/domain/models/dto"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultColorDistance is the ΔE within which a palette colour matches
+	// a searched colour
+	DefaultColorDistance = 20
+	// MaxColorDistance is the largest ΔE a search may ask for
+	MaxColorDistance = 100
+	// colorMinWeight ignores palette colours covering less of the image
+	// when searching by colour
+	colorMinWeight = 0.05
+)
+
+// ColorService extracts the dominant palette of wallpapers
+type ColorService struct {
+	db       *gorm.DB
+	fetcher  *ImageFetcher
+	backfill *backfillJob
+}
+
+func NewColorService(db *gorm.DB, fetcher *ImageFetcher) *ColorService {
+	s := &ColorService{
+		db:      db,
+		fetcher: fetcher,
+	}
+	s.backfill = newBackfillJob("Palette", db, func(db *gorm.DB) *gorm.DB {
+		return db.Where("NOT EXISTS (SELECT 1 FROM wallpaper_colors WHERE wallpaper_colors.wallpaper_id = wallpapers.id)")
+	}, s.backfillOne)
+	return s
+}
+
+// SavePalette extracts the palette of the wallpaper's image and replaces
+// the stored one
+func (s *ColorService) SavePalette(wallpaper *models.Wallpaper, img image.Image) error {
+	palette := extractPalette(img)
+	colors := make([]models.WallpaperColor, len(palette))
+	for i, p := range palette {
+		colors[i] = models.WallpaperColor{
+			WallpaperID: wallpaper.ID,
+			Rank:        i,
+			Hex:         p.Color.Hex(),
+			Weight:      p.Weight,
+			L:           p.Color.L,
+			A:           p.Color.A,
+			B:           p.Color.B,
+		}
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("wallpaper_id = ?", wallpaper.ID).Delete(&models.WallpaperColor{}).Error; err != nil {
+			return err
+		}
+		if len(colors) > 0 {
+			if err := tx.Create(&colors).Error; err != nil {
+				return err
+			}
+		}
+		return touchWallpapers(tx, []uint{wallpaper.ID})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save palette: %w", err)
+	}
+
+	wallpaper.Colors = colors
+	return nil
+}
+
+func (s *ColorService) Status() dto.BackfillStatus {
+	return s.backfill.Status()
+}
+
+// StartBackfill extracts the palette of every wallpaper without one in the
+// background
+func (s *ColorService) StartBackfill() (dto.BackfillStatus, error) {
+	return s.backfill.Start()
+}
+
+// Backfill extracts the palette of every wallpaper without one and waits
+// for the result
+func (s *ColorService) Backfill() (dto.BackfillStatus, error) {
+	return s.backfill.Run()
+}
+
+func (s *ColorService) backfillOne(wallpaper *models.Wallpaper) (bool, error) {
+	img, err := s.fetcher.Decode(wallpaper.ImageURL)
+	if err != nil {
+		return false, err
+	}
+	if err := s.SavePalette(wallpaper, img); err != nil {
+		return false, err
+	}
+	return true, nil
+}
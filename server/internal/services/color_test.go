@@ -0,0 +1,135 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestDeltaE(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Lab
+		want float64
+	}{
+		{"same colour", Lab{50, 10, -10}, Lab{50, 10, -10}, 0},
+		{"lightness only", Lab{40, 0, 0}, Lab{50, 0, 0}, 10},
+		{"3-4-5", Lab{50, 0, 0}, Lab{50, 3, 4}, 5},
+		{"black and white", Lab{0, 0, 0}, Lab{100, 0, 0}, 100},
+		{"symmetric", Lab{50, 3, 4}, Lab{50, 0, 0}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.DeltaE(tt.b); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("DeltaE() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHexColor(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Lab
+		wantErr bool
+	}{
+		{value: "#ffffff", want: Lab{100, 0, 0}},
+		{value: "000000", want: Lab{0, 0, 0}},
+		// sRGB red in CIELAB (D65)
+		{value: "#ff0000", want: Lab{53.24, 80.09, 67.20}},
+		{value: "#0000FF", want: Lab{32.30, 79.19, -107.86}},
+		{value: "#fff", wantErr: true},
+		{value: "#gggggg", wantErr: true},
+		{value: "", wantErr: true},
+		{value: "#ff00001", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseHexColor(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseHexColor(%q) = %v, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseHexColor(%q) failed: %v", tt.value, err)
+			}
+			if d := got.DeltaE(tt.want); d > 0.05 {
+				t.Errorf("ParseHexColor(%q) = %v, want %v (ΔE %v)", tt.value, got, tt.want, d)
+			}
+		})
+	}
+}
+
+func TestLabHexRoundTrip(t *testing.T) {
+	for _, hex := range []string{"#000000", "#ffffff", "#ff0000", "#00ff00", "#0000ff", "#1a2b3c", "#808080"} {
+		t.Run(hex, func(t *testing.T) {
+			c, err := ParseHexColor(hex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.Hex(); got != hex {
+				t.Errorf("Hex() = %s, want %s", got, hex)
+			}
+		})
+	}
+}
+
+func TestExtractPalette(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	tests := []struct {
+		name string
+		// fill returns the colour of the pixel at x of a 100 pixel wide image
+		fill func(x int) color.NRGBA
+		want []string
+		// weights of the colours in want
+		weights []float64
+	}{
+		{"single colour", func(int) color.NRGBA { return red }, []string{"#ff0000"}, []float64{1}},
+		{"three quarters red", func(x int) color.NRGBA {
+			if x < 75 {
+				return red
+			}
+			return blue
+		}, []string{"#ff0000", "#0000ff"}, []float64{0.75, 0.25}},
+		{"transparent pixels ignored", func(x int) color.NRGBA {
+			if x < 50 {
+				return color.NRGBA{}
+			}
+			return blue
+		}, []string{"#0000ff"}, []float64{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewNRGBA(image.Rect(0, 0, 100, 20))
+			for y := 0; y < 20; y++ {
+				for x := 0; x < 100; x++ {
+					img.SetNRGBA(x, y, tt.fill(x))
+				}
+			}
+
+			palette := extractPalette(img)
+			var colours []PaletteColor
+			for _, p := range palette {
+				// Scaling blends a column at the boundary
+				if p.Weight > 0.05 {
+					colours = append(colours, p)
+				}
+			}
+			if len(colours) != len(tt.want) {
+				t.Fatalf("extractPalette() = %v, want %d main colours", palette, len(tt.want))
+			}
+			for i, p := range colours {
+				if got := p.Color.Hex(); got != tt.want[i] {
+					t.Errorf("colour %d = %s, want %s", i, got, tt.want[i])
+				}
+				if math.Abs(p.Weight-tt.weights[i]) > 0.05 {
+					t.Errorf("colour %d weight = %v, want %v", i, p.Weight, tt.weights[i])
+				}
+			}
+		})
+	}
+}
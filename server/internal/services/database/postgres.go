@@ -32,6 +32,18 @@ func NewPostgresDB(cfg *config.DatabaseConfig) (*PostgresDB, error) {
 		&models.WallpaperFavorite{},
 		&models.Category{},
 		&models.Tag{},
+		&models.FeatureModel{},
+		&models.WallpaperFeature{},
+		&models.ClusterRun{},
+		&models.VisualCluster{},
+		&models.VisualClusterMember{},
+		&models.WallpaperVariant{},
+		&models.WallpaperColor{},
+		&models.WallpaperRendition{},
+		&models.ImageBlob{},
+		&models.GenerationJob{},
+		&models.GenerationUsage{},
+		&models.StreamTicket{},
 	); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %v", err)
 	}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"path"
+
+	"wallpaperio/server/internal/config"
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/domain/models/dto"
+	"wallpaperio/server/internal/services/storage"
+
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	FitCrop    = "crop"
+	FitContain = "contain"
+
+	// downloadQuality is the JPEG quality renditions are encoded with
+	downloadQuality = 90
+)
+
+var (
+	ErrWallpaperNotFound   = errors.New("wallpaper not found")
+	ErrInvalidDownloadSize = fmt.Errorf("w and h must be given together, each between 1 and %d, and cover at most %d pixels", config.MaxDownloadDimension, config.MaxDownloadPixels)
+	ErrInvalidFit          = errors.New("fit must be crop or contain")
+)
+
+// Download is an image file ready to be sent to a client; the caller closes
+// Content. Size is -1 when not known in advance.
+type Download struct {
+	Content     io.ReadCloser
+	ContentType string
+	Size        int64
+	Filename    string
+}
+
+// DownloadService serves wallpaper images for download, either as they are
+// or rendered for a device resolution. Renditions at the configured sizes
+// are cached in storage and recorded as WallpaperRenditions.
+type DownloadService struct {
+	db      *gorm.DB
+	storage storage.Storage
+	fetcher *ImageFetcher
+	signer  *URLSigner
+	sizes   []config.DownloadSize
+	// renders lets concurrent requests for the same rendition share one
+	// render
+	renders singleflight.Group
+}
+
+func NewDownloadService(db *gorm.DB, storage storage.Storage, fetcher *ImageFetcher, signer *URLSigner, sizes []config.DownloadSize) *DownloadService {
+	return &DownloadService{
+		db:      db,
+		storage: storage,
+		fetcher: fetcher,
+		signer:  signer,
+		sizes:   sizes,
+	}
+}
+
+// Download opens the wallpaper's image at the requested resolution and
+// counts the download. Originals gated by signed links are only sent to
+// signed-in users or with the link's signature.
+func (s *DownloadService) Download(id uint, req dto.DownloadRequest, signedIn bool) (*Download, error) {
+	if err := s.validateDownload(&req); err != nil {
+		return nil, err
+	}
+
+	var wallpaper models.Wallpaper
+	if err := s.db.First(&wallpaper, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWallpaperNotFound
+		}
+		return nil, fmt.Errorf("failed to find wallpaper: %w", err)
+	}
+
+	var download *Download
+	var err error
+	if req.Width == 0 {
+		if !signedIn {
+			if err := s.verifyOriginal(&wallpaper, req); err != nil {
+				return nil, err
+			}
+		}
+		download, err = s.original(&wallpaper)
+	} else {
+		download, err = s.rendition(&wallpaper, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// UpdateColumn leaves updated_at alone: a download does not change the
+	// wallpaper
+	err = s.db.Model(&models.Wallpaper{}).Where("id = ?", id).
+		UpdateColumn("downloads", gorm.Expr("downloads + 1")).Error
+	if err != nil {
+		log.Printf("Failed to count download of wallpaper %d: %v", id, err)
+	}
+	return download, nil
+}
+
+// validateDownload checks the requested size is within bounds and defaults
+// the fit to crop
+func (s *DownloadService) validateDownload(req *dto.DownloadRequest) error {
+	if req.Width == 0 && req.Height == 0 {
+		return nil
+	}
+	if req.Width < 1 || req.Height < 1 ||
+		req.Width > config.MaxDownloadDimension || req.Height > config.MaxDownloadDimension ||
+		req.Width*req.Height > config.MaxDownloadPixels {
+		return ErrInvalidDownloadSize
+	}
+	switch req.Fit {
+	case "":
+		req.Fit = FitCrop
+	case FitCrop, FitContain:
+	default:
+		return ErrInvalidFit
+	}
+	return nil
+}
+
+// cached reports whether renditions at the size are cached: those at a
+// configured size, in either orientation
+func (s *DownloadService) cached(width, height int) bool {
+	for _, size := range s.sizes {
+		if (width == size.Width && height == size.Height) ||
+			(width == size.Height && height == size.Width) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyOriginal checks the signature sent for the wallpaper's original
+// image if the image is gated
+func (s *DownloadService) verifyOriginal(wallpaper *models.Wallpaper, req dto.DownloadRequest) error {
+	key, ok := s.storage.KeyForURL(wallpaper.ImageURL)
+	if !ok || !s.signer.Gated(key) {
+		return nil
+	}
+	return s.signer.Verify(key, req.Expires, req.Signature)
+}
+
+// original opens the wallpaper's image file as it was stored
+func (s *DownloadService) original(wallpaper *models.Wallpaper) (*Download, error) {
+	content, err := s.fetcher.Open(wallpaper.ImageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := wallpaper.MimeType
+	ext, ok := uploadImageTypes[contentType]
+	if !ok {
+		contentType = "application/octet-stream"
+		ext = path.Ext(wallpaper.ImageURL)
+	}
+	return &Download{
+		Content:     content,
+		ContentType: contentType,
+		Size:        -1,
+		Filename:    fmt.Sprintf("wallpaperio-%d%s", wallpaper.ID, ext),
+	}, nil
+}
+
+// rendition opens the wallpaper's image rendered at the requested size,
+// rendering it first if needed and caching it if the size is configured
+func (s *DownloadService) rendition(wallpaper *models.Wallpaper, req dto.DownloadRequest) (*Download, error) {
+	filename := fmt.Sprintf("wallpaperio-%d-%dx%d.jpg", wallpaper.ID, req.Width, req.Height)
+	key := fmt.Sprintf("downloads/%d/%dx%d_%s.jpg", wallpaper.ID, req.Width, req.Height, req.Fit)
+	if !s.cached(req.Width, req.Height) {
+		return s.renderShared(wallpaper, req, key, false, filename)
+	}
+
+	var cached models.WallpaperRendition
+	err := s.db.Where("wallpaper_id = ? AND width = ? AND height = ? AND fit = ?", wallpaper.ID, req.Width, req.Height, req.Fit).
+		First(&cached).Error
+	switch {
+	case err == nil:
+		content, err := s.storage.Get(cached.Key)
+		if err == nil {
+			return &Download{Content: content, ContentType: "image/jpeg", Size: cached.Bytes, Filename: filename}, nil
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("failed to read %s: %w", cached.Key, err)
+		}
+		// The cached file is gone; render it again
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, fmt.Errorf("failed to fetch rendition: %w", err)
+	}
+
+	return s.renderShared(wallpaper, req, key, true, filename)
+}
+
+// renderShared renders the rendition under key, sharing the render with
+// concurrent requests for it, and caches it if asked to
+func (s *DownloadService) renderShared(wallpaper *models.Wallpaper, req dto.DownloadRequest, key string, cache bool, filename string) (*Download, error) {
+	rendered, err, _ := s.renders.Do(key, func() (interface{}, error) {
+		return s.render(wallpaper, req, key, cache)
+	})
+	if err != nil {
+		return nil, err
+	}
+	data := rendered.([]byte)
+	return &Download{
+		Content:     io.NopCloser(bytes.NewReader(data)),
+		ContentType: "image/jpeg",
+		Size:        int64(len(data)),
+		Filename:    filename,
+	}, nil
+}
+
+// render renders the wallpaper's image at the requested size, caching it
+// under key if asked to, and returns the encoded JPEG
+func (s *DownloadService) render(wallpaper *models.Wallpaper, req dto.DownloadRequest, key string, cache bool) ([]byte, error) {
+	img, err := s.fetcher.Decode(wallpaper.ImageURL)
+	if err != nil {
+		return nil, err
+	}
+	var rendered image.Image
+	if req.Fit == FitContain {
+		rendered = fitWithin(img, req.Width, req.Height)
+	} else {
+		rendered = cropToFill(img, req.Width, req.Height)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, rendered, &jpeg.Options{Quality: downloadQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode rendition: %w", err)
+	}
+	data := buf.Bytes()
+	if !cache {
+		return data, nil
+	}
+
+	if err := s.storage.Put(key, bytes.NewReader(data), "image/jpeg"); err != nil {
+		// The rendition can still be served, it just is not cached
+		log.Printf("Failed to cache %s: %v", key, err)
+	} else {
+		err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.WallpaperRendition{
+			WallpaperID: wallpaper.ID,
+			Width:       req.Width,
+			Height:      req.Height,
+			Fit:         req.Fit,
+			Key:         key,
+			Bytes:       int64(len(data)),
+		}).Error
+		if err != nil {
+			log.Printf("Failed to record rendition %s: %v", key, err)
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"wallpaperio/server/internal/config"
+	"wallpaperio/server/internal/domain/models/dto"
+)
+
+func TestValidateDownload(t *testing.T) {
+	svc := NewDownloadService(nil, nil, nil, nil, []config.DownloadSize{{Width: 1170, Height: 2532}})
+	tests := []struct {
+		name       string
+		req        dto.DownloadRequest
+		wantFit    string
+		wantErr    error
+		wantCached bool
+	}{
+		{name: "original", req: dto.DownloadRequest{}},
+		{name: "configured size defaults to crop", req: dto.DownloadRequest{Width: 1170, Height: 2532}, wantFit: FitCrop, wantCached: true},
+		{name: "configured size rotated", req: dto.DownloadRequest{Width: 2532, Height: 1170, Fit: FitContain}, wantFit: FitContain, wantCached: true},
+		{name: "custom size", req: dto.DownloadRequest{Width: 1284, Height: 2778}, wantFit: FitCrop},
+		{name: "8K UHD", req: dto.DownloadRequest{Width: 7680, Height: 4320}, wantFit: FitCrop},
+		{name: "longest side", req: dto.DownloadRequest{Width: 8192, Height: 100}, wantFit: FitCrop},
+		{name: "width only", req: dto.DownloadRequest{Width: 1170}, wantErr: ErrInvalidDownloadSize},
+		{name: "height only", req: dto.DownloadRequest{Height: 2532}, wantErr: ErrInvalidDownloadSize},
+		{name: "negative", req: dto.DownloadRequest{Width: -1, Height: 100}, wantErr: ErrInvalidDownloadSize},
+		{name: "too wide", req: dto.DownloadRequest{Width: 8193, Height: 100}, wantErr: ErrInvalidDownloadSize},
+		{name: "too many pixels", req: dto.DownloadRequest{Width: 8192, Height: 8192}, wantErr: ErrInvalidDownloadSize},
+		{name: "unknown fit", req: dto.DownloadRequest{Width: 1170, Height: 2532, Fit: "stretch"}, wantErr: ErrInvalidFit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			err := svc.validateDownload(&req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("validateDownload() = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if req.Fit != tt.wantFit {
+				t.Errorf("fit = %q, want %q", req.Fit, tt.wantFit)
+			}
+			if req.Width != 0 {
+				if got := svc.cached(req.Width, req.Height); got != tt.wantCached {
+					t.Errorf("cached() = %v, want %v", got, tt.wantCached)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"wallpaperio/server/internal/config"
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/domain/models/dto"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DuplicateModeReject = "reject"
+	DuplicateModeFlag   = "flag"
+	DuplicateModeOff    = "off"
+)
+
+var ErrDuplicateScanInProgress = errors.New("duplicate scan is already running")
+
+// duplicateNeighbours is how many nearest neighbours are examined per
+// wallpaper when building the duplicate report
+const duplicateNeighbours = 10
+
+// DuplicateError is returned when an ingested image duplicates an existing
+// wallpaper and duplicates are rejected
+type DuplicateError struct {
+	ExistingID uint
+	Score      float32
+	Reason     string
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("duplicate of wallpaper %d (%s, score %.3f)", e.ExistingID, e.Reason, e.Score)
+}
+
+// DuplicateService detects wallpapers that repeat an existing image, either
+// byte for byte (content hash) or visually (feature vector similarity)
+type DuplicateService struct {
+	db            *gorm.DB
+	featureModels *FeatureModelService
+	mode          string
+	threshold     float32
+
+	running atomic.Bool
+	mu      sync.Mutex
+	report  dto.DuplicateReport
+}
+
+func NewDuplicateService(db *gorm.DB, featureModels *FeatureModelService, cfg *config.DuplicateConfig) *DuplicateService {
+	return &DuplicateService{
+		db:            db,
+		featureModels: featureModels,
+		mode:          cfg.Mode,
+		threshold:     cfg.Threshold,
+	}
+}
+
+// Mode returns the duplicate handling mode to use, preferring the requested
+// one when it is valid
+func (s *DuplicateService) Mode(requested string) string {
+	switch requested {
+	case DuplicateModeReject, DuplicateModeFlag, DuplicateModeOff:
+		return requested
+	}
+	return s.mode
+}
+
+// Find returns the existing wallpaper the image duplicates, or nil. The
+// content hash is checked first; otherwise the nearest vector stored with
+// featureModel counts as a duplicate when its similarity reaches the
+// configured threshold.
+func (s *DuplicateService) Find(contentHash string, features []float32, vectors VectorStore, featureModel string) (*DuplicateError, error) {
+	if contentHash != "" {
+		var existing models.Wallpaper
+		err := s.db.Select("id").Where("content_hash = ?", contentHash).Order("id").First(&existing).Error
+		if err == nil {
+			return &DuplicateError{ExistingID: existing.ID, Score: 1, Reason: "identical content"}, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("failed to look up content hash: %w", err)
+		}
+	}
+
+	matches, err := vectors.Search(features, SearchOptions{Limit: 1})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for duplicates: %w", err)
+	}
+	if len(matches) == 0 || matches[0].Score < s.threshold {
+		return nil, nil
+	}
+
+	var existing models.Wallpaper
+	err = s.db.Select("id").Where("feature_model = ? AND feature_id = ?", featureModel, matches[0].FeatureID).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Orphan vector; the reconciler cleans these up
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up duplicate: %w", err)
+	}
+	return &DuplicateError{ExistingID: existing.ID, Score: matches[0].Score, Reason: "visually similar"}, nil
+}
+
+// Similar returns the similarity of two feature vectors and whether it
+// reaches the threshold Find applies to stored vectors
+func (s *DuplicateService) Similar(a, b []float32) (float32, bool) {
+	score := cosineSimilarity(a, b)
+	return score, score >= s.threshold
+}
+
+// Report returns the progress of the latest duplicate scan and, once it
+// finished, its clusters
+func (s *DuplicateService) Report() dto.DuplicateReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report := s.report
+	report.Clusters = append([]dto.DuplicateCluster{}, s.report.Clusters...)
+	return report
+}
+
+// StartScan groups the catalogue into clusters of duplicates in the
+// background; Report shows the progress and the result
+func (s *DuplicateService) StartScan(threshold float32) (dto.DuplicateReport, error) {
+	if threshold <= 0 {
+		threshold = s.threshold
+	}
+	if !s.running.CompareAndSwap(false, true) {
+		return s.Report(), ErrDuplicateScanInProgress
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	s.report = dto.DuplicateReport{Running: true, Threshold: threshold, StartedAt: &now}
+	s.mu.Unlock()
+
+	go func() {
+		defer s.running.Store(false)
+		clusters, err := s.scan(threshold)
+
+		now := time.Now()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.report.Running = false
+		s.report.FinishedAt = &now
+		if err != nil {
+			log.Printf("Duplicate scan failed: %v", err)
+			s.report.Error = err.Error()
+			return
+		}
+		s.report.Clusters = clusters
+	}()
+
+	return s.Report(), nil
+}
+
+// scan groups the catalogue into clusters of duplicates. Wallpapers sharing
+// a content hash or whose vectors in the active model are at least threshold
+// similar end up in the same cluster. Only clusters with two or more
+// wallpapers are returned.
+func (s *DuplicateService) scan(threshold float32) ([]dto.DuplicateCluster, error) {
+	model, vectors := s.featureModels.Active()
+
+	var wallpapers []models.Wallpaper
+	err := s.db.Select("id", "feature_id", "feature_model", "content_hash", "image_thumb_url").
+		Order("id").
+		Find(&wallpapers).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list wallpapers: %w", err)
+	}
+
+	// Feature IDs are only unique within the model's collection
+	byFeature := make(map[int64]int, len(wallpapers))
+	byHash := make(map[string]int)
+	for i, w := range wallpapers {
+		if w.FeatureModel == model.Key() && w.FeatureID != 0 {
+			byFeature[w.FeatureID] = i
+		}
+	}
+
+	parent := make([]int, len(wallpapers))
+	best := make([]float32, len(wallpapers))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+	union := func(a, b int, score float32) {
+		ra, rb := find(a), find(b)
+		if ra != rb {
+			parent[rb] = ra
+		}
+		if score > best[a] {
+			best[a] = score
+		}
+		if score > best[b] {
+			best[b] = score
+		}
+	}
+
+	for i, w := range wallpapers {
+		if w.ContentHash == "" {
+			continue
+		}
+		if j, ok := byHash[w.ContentHash]; ok {
+			union(j, i, 1)
+		} else {
+			byHash[w.ContentHash] = i
+		}
+	}
+
+	// Vectors are read in batches rather than fetched one by one
+	err = vectors.ScanFeatures(func(featureID int64, features []float32) error {
+		i, ok := byFeature[featureID]
+		if !ok {
+			// Orphan vector; the reconciler cleans these up
+			return nil
+		}
+		matches, err := vectors.Search(features, SearchOptions{Limit: duplicateNeighbours, ExcludeID: featureID})
+		if err != nil {
+			return fmt.Errorf("failed to search for duplicates: %w", err)
+		}
+		for _, m := range matches {
+			if m.Score < threshold {
+				break
+			}
+			if j, ok := byFeature[m.FeatureID]; ok {
+				union(i, j, m.Score)
+			}
+		}
+
+		s.mu.Lock()
+		s.report.Scanned++
+		s.mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[int][]int)
+	for i := range wallpapers {
+		root := find(i)
+		groups[root] = append(groups[root], i)
+	}
+
+	clusters := []dto.DuplicateCluster{}
+	for _, members := range groups {
+		if len(members) < 2 {
+			continue
+		}
+		cluster := dto.DuplicateCluster{}
+		for _, i := range members {
+			w := wallpapers[i]
+			cluster.Wallpapers = append(cluster.Wallpapers, dto.DuplicateMember{
+				ID:            w.ID,
+				ImageThumbURL: w.ImageThumbURL,
+				ContentHash:   w.ContentHash,
+				BestScore:     best[i],
+			})
+		}
+		clusters = append(clusters, cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Wallpapers[0].ID < clusters[j].Wallpapers[0].ID
+	})
+
+	return clusters, nil
+}
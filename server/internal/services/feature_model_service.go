@@ -0,0 +1,514 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"wallpaperio/server/internal/config"
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/domain/models/dto"
+	schema "wallpaperio/server/internal/schema/milvus"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// reembedPageSize is the number of wallpapers embedded per vector store
+	// insert
+	reembedPageSize = 100
+	// reembedSwitchAttempts is how many backfill passes a re-embedding runs
+	// to catch up with new wallpapers before giving up on switching
+	reembedSwitchAttempts = 5
+	// activeRefreshInterval is how long searches go on using the active model
+	// before it is read from the database again
+	activeRefreshInterval = 10 * time.Second
+)
+
+var ErrReembedInProgress = errors.New("re-embedding already in progress")
+
+// FeatureModelService tracks feature model versions and their vector stores.
+// One version is active and serves ingestion and similarity search; switching
+// to another version re-embeds the catalogue into that version's collection
+// and then flips every wallpaper over in a single transaction. The active
+// model is re-read from the database, so switches made by other server
+// instances are picked up.
+type FeatureModelService struct {
+	db         *gorm.DB
+	featureSvc *FeatureService
+	newStore   VectorStoreFactory
+
+	// mu guards active. Ingestion holds it for reading so that a switch never
+	// happens between extracting a wallpaper's features and storing them.
+	mu     sync.RWMutex
+	active *models.FeatureModel
+	// refreshedAt is when active was last read, in Unix nanoseconds
+	refreshedAt atomic.Int64
+
+	storesMu sync.Mutex
+	stores   map[uint]VectorStore
+
+	reembedding atomic.Bool
+}
+
+func NewFeatureModelService(db *gorm.DB, featureSvc *FeatureService, newStore VectorStoreFactory, cfg *config.FeatureModelConfig) (*FeatureModelService, error) {
+	s := &FeatureModelService{
+		db:         db,
+		featureSvc: featureSvc,
+		newStore:   newStore,
+		stores:     make(map[uint]VectorStore),
+	}
+	if err := s.loadActive(cfg); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadActive opens the active model's store. On first start the configured
+// model is registered as active against the original collection.
+func (s *FeatureModelService) loadActive(cfg *config.FeatureModelConfig) error {
+	var model models.FeatureModel
+	err := s.db.Where("active = ?", true).First(&model).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		model = models.FeatureModel{
+			Name:       cfg.Name,
+			Version:    cfg.Version,
+			Dimension:  schema.Dimension,
+			Collection: schema.CollectionName,
+			Active:     true,
+			Status:     models.FeatureModelReady,
+		}
+		if err := s.db.Create(&model).Error; err != nil {
+			return fmt.Errorf("failed to register feature model: %w", err)
+		}
+	case err != nil:
+		return fmt.Errorf("failed to load active feature model: %w", err)
+	case model.Name != cfg.Name || model.Version != cfg.Version:
+		log.Printf("Active feature model is %s; configured %s:%s needs re-embedding to take effect", model.Key(), cfg.Name, cfg.Version)
+	}
+
+	if _, err := s.storeFor(&model); err != nil {
+		return err
+	}
+
+	// Wallpapers created before model versioning belong to the active model
+	if err := s.db.Model(&models.Wallpaper{}).
+		Where("feature_model = '' OR feature_model IS NULL").
+		Update("feature_model", model.Key()).Error; err != nil {
+		return fmt.Errorf("failed to record feature model on wallpapers: %w", err)
+	}
+
+	s.active = &model
+	s.refreshedAt.Store(time.Now().UnixNano())
+	return nil
+}
+
+// refresh re-reads the active model from the database unless it was read
+// less than maxAge ago. On failure the model last read stays active.
+func (s *FeatureModelService) refresh(maxAge time.Duration) {
+	if time.Since(time.Unix(0, s.refreshedAt.Load())) < maxAge {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(time.Unix(0, s.refreshedAt.Load())) < maxAge {
+		return
+	}
+	var model models.FeatureModel
+	if err := s.db.Where("active = ?", true).First(&model).Error; err != nil {
+		log.Printf("Failed to refresh active feature model: %v", err)
+		return
+	}
+	if _, err := s.storeFor(&model); err != nil {
+		log.Printf("Failed to refresh active feature model: %v", err)
+		return
+	}
+	if model.ID != s.active.ID {
+		log.Printf("Active feature model changed to %s", model.Key())
+	}
+	s.active = &model
+	s.refreshedAt.Store(time.Now().UnixNano())
+}
+
+// storeFor returns the vector store holding a model's collection, opening it
+// on first use
+func (s *FeatureModelService) storeFor(model *models.FeatureModel) (VectorStore, error) {
+	s.storesMu.Lock()
+	defer s.storesMu.Unlock()
+
+	if store, ok := s.stores[model.ID]; ok {
+		return store, nil
+	}
+	store, err := s.newStore(model.Collection, model.Dimension)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vector store for %s: %w", model.Key(), err)
+	}
+	s.stores[model.ID] = store
+	return store, nil
+}
+
+// Active returns the active model and its vector store
+func (s *FeatureModelService) Active() (models.FeatureModel, VectorStore) {
+	s.refresh(activeRefreshInterval)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	store, _ := s.storeFor(s.active)
+	return *s.active, store
+}
+
+// Store returns the active model's vector store
+func (s *FeatureModelService) Store() VectorStore {
+	_, store := s.Active()
+	return store
+}
+
+// Acquire returns the active model, as currently recorded in the database,
+// and its vector store and keeps the model from being switched until
+// release is called
+func (s *FeatureModelService) Acquire() (model models.FeatureModel, store VectorStore, release func()) {
+	s.refresh(0)
+	s.mu.RLock()
+	store, _ = s.storeFor(s.active)
+	return *s.active, store, s.mu.RUnlock
+}
+
+// List returns all known feature model versions, newest first
+func (s *FeatureModelService) List() ([]models.FeatureModel, error) {
+	var list []models.FeatureModel
+	err := s.db.Order("id DESC").Find(&list).Error
+	return list, err
+}
+
+// DeleteWallpaperFeatures deletes a wallpaper's vectors from the collections
+// of inactive models. The active model's vector is referenced by the
+// wallpaper itself and is deleted by the caller.
+func (s *FeatureModelService) DeleteWallpaperFeatures(wallpaperID uint) error {
+	active, _ := s.Active()
+
+	var rows []models.WallpaperFeature
+	if err := s.db.Where("wallpaper_id = ? AND feature_model_id <> ?", wallpaperID, active.ID).Find(&rows).Error; err != nil {
+		return fmt.Errorf("failed to find wallpaper features: %w", err)
+	}
+
+	for _, row := range rows {
+		var model models.FeatureModel
+		if err := s.db.First(&model, row.FeatureModelID).Error; err != nil {
+			return fmt.Errorf("failed to find feature model %d: %w", row.FeatureModelID, err)
+		}
+		store, err := s.storeFor(&model)
+		if err != nil {
+			return err
+		}
+		if err := store.DeleteFeatures(uint(row.FeatureID)); err != nil {
+			return err
+		}
+	}
+
+	return s.db.Where("wallpaper_id = ?", wallpaperID).Delete(&models.WallpaperFeature{}).Error
+}
+
+// StartReembed starts re-embedding the catalogue with the given model version
+// in the background and returns the model being backfilled
+func (s *FeatureModelService) StartReembed(req dto.ReembedRequest) (*models.FeatureModel, error) {
+	model, store, err := s.prepareReembed(req)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer s.reembedding.Store(false)
+		if err := s.runReembed(model, store); err != nil {
+			log.Printf("Re-embedding with %s failed: %v", model.Key(), err)
+		}
+	}()
+
+	return model, nil
+}
+
+// Reembed re-embeds the catalogue with the given model version and switches
+// to it, returning once the job has finished
+func (s *FeatureModelService) Reembed(req dto.ReembedRequest) (*models.FeatureModel, error) {
+	model, store, err := s.prepareReembed(req)
+	if err != nil {
+		return nil, err
+	}
+	defer s.reembedding.Store(false)
+
+	err = s.runReembed(model, store)
+	return model, err
+}
+
+// prepareReembed registers the model version and opens its collection. On
+// success the caller owns the re-embedding flag and must clear it.
+func (s *FeatureModelService) prepareReembed(req dto.ReembedRequest) (*models.FeatureModel, VectorStore, error) {
+	if req.Name == "" || req.Version == "" || req.Dimension <= 0 {
+		return nil, nil, fmt.Errorf("name, version and a positive dimension are required")
+	}
+	if !s.reembedding.CompareAndSwap(false, true) {
+		return nil, nil, ErrReembedInProgress
+	}
+
+	model, store, err := s.registerModel(req)
+	if err != nil {
+		s.reembedding.Store(false)
+		return nil, nil, err
+	}
+	return model, store, nil
+}
+
+func (s *FeatureModelService) registerModel(req dto.ReembedRequest) (*models.FeatureModel, VectorStore, error) {
+	var model models.FeatureModel
+	err := s.db.Where(models.FeatureModel{Name: req.Name, Version: req.Version}).
+		Attrs(models.FeatureModel{
+			Dimension:  req.Dimension,
+			Collection: schema.CollectionNameFor(req.Name, req.Version),
+			Status:     models.FeatureModelPending,
+		}).
+		FirstOrCreate(&model).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to register feature model: %w", err)
+	}
+	if model.Active {
+		return nil, nil, fmt.Errorf("feature model %s is already active", model.Key())
+	}
+	if model.Dimension != req.Dimension {
+		return nil, nil, fmt.Errorf("feature model %s was registered with dimension %d", model.Key(), model.Dimension)
+	}
+
+	store, err := s.storeFor(&model)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &model, store, nil
+}
+
+// runReembed backfills the model's collection and switches over to it. The
+// backfill passes run alongside normal traffic; once a pass leaves nothing
+// to embed, ingestion is paused only to confirm that and switch, so the
+// feature extractor is never called with ingestion paused.
+func (s *FeatureModelService) runReembed(model *models.FeatureModel, store VectorStore) error {
+	var total, done int64
+	if err := s.db.Model(&models.Wallpaper{}).Count(&total).Error; err != nil {
+		return s.failReembed(model, err)
+	}
+	if err := s.db.Model(&models.WallpaperFeature{}).Where("feature_model_id = ?", model.ID).Count(&done).Error; err != nil {
+		return s.failReembed(model, err)
+	}
+	if err := s.updateModel(model, map[string]interface{}{
+		"status":    models.FeatureModelBackfilling,
+		"total":     total,
+		"processed": done,
+		"failed":    0,
+		"error":     "",
+	}); err != nil {
+		return err
+	}
+
+	if _, err := s.backfill(model, store); err != nil {
+		return s.failReembed(model, err)
+	}
+
+	// Later passes pick up wallpapers added in the meantime and retry the
+	// ones that failed
+	for attempt := 1; ; attempt++ {
+		if err := s.updateModel(model, map[string]interface{}{"failed": 0}); err != nil {
+			return err
+		}
+		failed, err := s.backfill(model, store)
+		if err != nil {
+			return s.failReembed(model, err)
+		}
+		if failed > 0 {
+			return s.failReembed(model, fmt.Errorf("%d wallpapers could not be embedded", failed))
+		}
+
+		switched, err := s.switchIfComplete(model)
+		if err != nil {
+			return s.failReembed(model, err)
+		}
+		if switched {
+			log.Printf("Switched feature model to %s", model.Key())
+			return nil
+		}
+		if attempt == reembedSwitchAttempts {
+			return s.failReembed(model, errors.New("wallpapers kept being added faster than they were embedded"))
+		}
+	}
+}
+
+// switchIfComplete pauses ingestion and switches to the model if every
+// wallpaper has a vector in its collection. It reports whether it switched.
+func (s *FeatureModelService) switchIfComplete(model *models.FeatureModel) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var missing int64
+	err := s.db.Model(&models.Wallpaper{}).
+		Where("NOT EXISTS (SELECT 1 FROM wallpaper_features wf WHERE wf.wallpaper_id = wallpapers.id AND wf.feature_model_id = ?)", model.ID).
+		Count(&missing).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to count wallpapers to embed: %w", err)
+	}
+	if missing > 0 {
+		return false, nil
+	}
+
+	if err := s.switchTo(model); err != nil {
+		return false, err
+	}
+	model.Active = true
+	model.Status = models.FeatureModelReady
+	s.active = model
+	s.refreshedAt.Store(time.Now().UnixNano())
+	return true, nil
+}
+
+// backfill embeds every wallpaper that has no vector in the model's
+// collection yet and returns how many could not be embedded
+func (s *FeatureModelService) backfill(model *models.FeatureModel, store VectorStore) (int, error) {
+	failed := 0
+	lastID := uint(0)
+	for {
+		var page []models.Wallpaper
+		err := s.db.Select("id", "image_url", "category_id").
+			Where("id > ?", lastID).
+			Where("NOT EXISTS (SELECT 1 FROM wallpaper_features wf WHERE wf.wallpaper_id = wallpapers.id AND wf.feature_model_id = ?)", model.ID).
+			Order("id").
+			Limit(reembedPageSize).
+			Find(&page).Error
+		if err != nil {
+			return failed, fmt.Errorf("failed to list wallpapers: %w", err)
+		}
+		if len(page) == 0 {
+			return failed, nil
+		}
+		lastID = page[len(page)-1].ID
+
+		// Group the page's vectors by category partition
+		byPartition := make(map[string][]models.WallpaperFeature)
+		vectors := make(map[string][][]float32)
+		embedded, pageFailed := 0, 0
+		for _, w := range page {
+			features, err := s.featureSvc.ExtractFeatures(w.ImageURL, model.Name)
+			if err != nil {
+				log.Printf("Failed to embed wallpaper %d with %s: %v", w.ID, model.Key(), err)
+				pageFailed++
+				continue
+			}
+			partition := schema.PartitionNameFor(w.CategoryID)
+			byPartition[partition] = append(byPartition[partition], models.WallpaperFeature{
+				WallpaperID:    w.ID,
+				FeatureModelID: model.ID,
+				Partition:      partition,
+			})
+			vectors[partition] = append(vectors[partition], features)
+			embedded++
+		}
+
+		var rows []models.WallpaperFeature
+		for partition, group := range byPartition {
+			featureIDs, err := store.StoreFeaturesBatch(vectors[partition], partition)
+			if err != nil {
+				deleteWallpaperFeatureVectors(store, rows)
+				return failed, fmt.Errorf("failed to store features: %w", err)
+			}
+			for i := range group {
+				group[i].FeatureID = featureIDs[i]
+			}
+			rows = append(rows, group...)
+		}
+
+		if len(rows) > 0 {
+			if err := s.db.Create(&rows).Error; err != nil {
+				deleteWallpaperFeatureVectors(store, rows)
+				return failed, fmt.Errorf("failed to record wallpaper features: %w", err)
+			}
+		}
+
+		failed += pageFailed
+		if err := s.updateModel(model, map[string]interface{}{
+			"processed": gorm.Expr("processed + ?", embedded),
+			"failed":    gorm.Expr("failed + ?", pageFailed),
+		}); err != nil {
+			return failed, err
+		}
+	}
+}
+
+// deleteWallpaperFeatureVectors removes vectors whose rows were not recorded
+func deleteWallpaperFeatureVectors(store VectorStore, rows []models.WallpaperFeature) {
+	for _, row := range rows {
+		if err := store.DeleteFeatures(uint(row.FeatureID)); err != nil {
+			log.Printf("Failed to delete orphaned features %d: %v", row.FeatureID, err)
+		}
+	}
+}
+
+// switchTo points every wallpaper at its vector in the model's collection
+// and makes the model active, all in one transaction. The previous model's
+// vector references are kept so its collection stays consistent.
+func (s *FeatureModelService) switchTo(model *models.FeatureModel) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var previous models.FeatureModel
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("active = ?", true).First(&previous).Error; err != nil {
+			return fmt.Errorf("failed to find active feature model: %w", err)
+		}
+		if err := tx.Exec(`INSERT INTO wallpaper_features (wallpaper_id, feature_model_id, feature_id, partition, created_at)
+			SELECT id, ?, feature_id, COALESCE(feature_partition, ''), NOW() FROM wallpapers WHERE feature_id <> 0
+			ON CONFLICT (wallpaper_id, feature_model_id)
+			DO UPDATE SET feature_id = EXCLUDED.feature_id, partition = EXCLUDED.partition`, previous.ID).Error; err != nil {
+			return fmt.Errorf("failed to record previous features: %w", err)
+		}
+		if err := tx.Exec(`UPDATE wallpapers SET feature_id = wf.feature_id, feature_model = ?, feature_partition = wf.partition
+			FROM wallpaper_features wf
+			WHERE wf.wallpaper_id = wallpapers.id AND wf.feature_model_id = ?`, model.Key(), model.ID).Error; err != nil {
+			return fmt.Errorf("failed to switch wallpaper features: %w", err)
+		}
+		if err := tx.Model(&models.FeatureModel{}).Where("active = ?", true).Update("active", false).Error; err != nil {
+			return fmt.Errorf("failed to deactivate feature model: %w", err)
+		}
+		return tx.Model(model).Updates(map[string]interface{}{
+			"active": true,
+			"status": models.FeatureModelReady,
+			"error":  "",
+		}).Error
+	})
+}
+
+func (s *FeatureModelService) updateModel(model *models.FeatureModel, fields map[string]interface{}) error {
+	if err := s.db.Model(model).Updates(fields).Error; err != nil {
+		return fmt.Errorf("failed to update feature model: %w", err)
+	}
+	return nil
+}
+
+func (s *FeatureModelService) failReembed(model *models.FeatureModel, cause error) error {
+	if err := s.updateModel(model, map[string]interface{}{
+		"status": models.FeatureModelFailed,
+		"error":  cause.Error(),
+	}); err != nil {
+		log.Printf("Failed to record re-embedding failure: %v", err)
+	}
+	return cause
+}
+
+// Close closes every opened vector store
+func (s *FeatureModelService) Close() error {
+	s.storesMu.Lock()
+	defer s.storesMu.Unlock()
+
+	var errs []error
+	for _, store := range s.stores {
+		if err := store.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
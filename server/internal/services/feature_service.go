@@ -1,53 +1,115 @@
 package services
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"strings"
 )
 
+// ErrExtractionUnsupported is returned when the feature extractor has no
+// endpoint for a request, or the model cannot serve it
+var ErrExtractionUnsupported = errors.New("feature extractor does not support this request")
+
 type FeatureService struct {
 	generatorURL string
 }
 
-var result struct {
-	Features []float32 `json:"features"`
-}
-
 func NewFeatureService() *FeatureService {
 	return &FeatureService{
 		generatorURL: os.Getenv("GENERATOR_URL"),
 	}
 }
 
+type extractFeaturesRequest struct {
+	ImagePath string `json:"image_path,omitempty"`
+	// ImageData is sent base64-encoded
+	ImageData []byte `json:"image_data,omitempty"`
+	Model     string `json:"model,omitempty"`
+}
+
+type extractTextFeaturesRequest struct {
+	Text  string `json:"text"`
+	Model string `json:"model,omitempty"`
+}
+
+type extractFeaturesResponse struct {
+	Status       string    `json:"status"`
+	Features     []float32 `json:"features"`
+	ErrorMessage *string   `json:"error_message,omitempty"`
+}
+
 // ExtractFeatures calls the Python service to extract features from an image
-func (s *FeatureService) ExtractFeatures(imageURL string) ([]float32, error) {
+// using the named feature model
+func (s *FeatureService) ExtractFeatures(imageURL, model string) ([]float32, error) {
+	return s.extract("/api/images/extract-features", extractFeaturesRequest{ImagePath: imageURL, Model: model})
+}
+
+// ExtractFeaturesFromData is ExtractFeatures for an image sent along with the
+// request, which the extractor then does not fetch
+func (s *FeatureService) ExtractFeaturesFromData(data []byte, model string) ([]float32, error) {
+	return s.extract("/api/images/extract-features", extractFeaturesRequest{ImageData: data, Model: model})
+}
+
+// ExtractTextFeatures calls the Python service to embed a text query into the
+// same space as the named model's image features. Only text-image models
+// (CLIP-style) support this.
+func (s *FeatureService) ExtractTextFeatures(text, model string) ([]float32, error) {
+	return s.extract("/api/images/extract-text-features", extractTextFeaturesRequest{Text: text, Model: model})
+}
+
+func (s *FeatureService) extract(path string, req interface{}) ([]float32, error) {
 	if s.generatorURL == "" {
 		return nil, fmt.Errorf("GENERATOR_URL environment variable not set")
 	}
 
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
 	// Make request to feature extractor
-	resp, err := http.Post(
-		fmt.Sprintf("%s/api/images/extract-features", s.generatorURL),
-		"application/json",
-		strings.NewReader(fmt.Sprintf(`{"image_path": "%s"}`, imageURL)),
-	)
+	resp, err := http.Post(s.generatorURL+path, "application/json", bytes.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("failed to call feature extractor: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNotImplemented {
+		return nil, ErrExtractionUnsupported
+	}
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		return nil, fmt.Errorf("feature extractor returned error: %s", string(body))
 	}
 
+	var result extractFeaturesResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, fmt.Errorf("failed to decode feature extractor response: %w", err)
 	}
+	if result.Status == "error" {
+		msg := "unknown error"
+		if result.ErrorMessage != nil {
+			msg = *result.ErrorMessage
+		}
+		return nil, fmt.Errorf("feature extractor returned error: %s", msg)
+	}
+	if len(result.Features) == 0 || isZeroVector(result.Features) {
+		// The extractor answers with a zero vector when it cannot read the image
+		return nil, fmt.Errorf("feature extractor returned no features")
+	}
 
 	return result.Features, nil
 }
+
+func isZeroVector(v []float32) bool {
+	for _, x := range v {
+		if x != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/domain/models/dto"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// generationEventBuffer is how many events a slow follower may lag
+	// behind before older ones are dropped
+	generationEventBuffer = 8
+	// generationFollowRefresh is how often a followed job is re-read from the
+	// database, for changes recorded by another server instance
+	generationFollowRefresh = 10 * time.Second
+	// streamTicketTTL is how long a stream ticket can be used after it is
+	// issued
+	streamTicketTTL = 30 * time.Second
+)
+
+var ErrInvalidStreamTicket = errors.New("invalid or expired stream ticket")
+
+// generationEvents passes generation job changes to the followers of each
+// job within this server
+type generationEvents struct {
+	mu          sync.Mutex
+	subscribers map[uint]map[chan models.GenerationJob]struct{}
+}
+
+func newGenerationEvents() *generationEvents {
+	return &generationEvents{
+		subscribers: make(map[uint]map[chan models.GenerationJob]struct{}),
+	}
+}
+
+func (e *generationEvents) subscribe(jobID uint) (chan models.GenerationJob, func()) {
+	ch := make(chan models.GenerationJob, generationEventBuffer)
+	e.mu.Lock()
+	if e.subscribers[jobID] == nil {
+		e.subscribers[jobID] = make(map[chan models.GenerationJob]struct{})
+	}
+	e.subscribers[jobID][ch] = struct{}{}
+	e.mu.Unlock()
+
+	return ch, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		delete(e.subscribers[jobID], ch)
+		if len(e.subscribers[jobID]) == 0 {
+			delete(e.subscribers, jobID)
+		}
+	}
+}
+
+// publish sends the job's state to its followers without blocking. Each
+// update carries the whole state, so a follower that falls behind loses
+// its oldest update rather than the latest.
+func (e *generationEvents) publish(job *models.GenerationJob) {
+	update := *job
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch := range e.subscribers[job.ID] {
+		select {
+		case ch <- update:
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- update
+		}
+	}
+}
+
+func generationEvent(job *models.GenerationJob) dto.GenerationEvent {
+	event := dto.GenerationEvent{
+		Type:          string(job.Status),
+		JobID:         job.ID,
+		TaskID:        job.TaskID,
+		Status:        string(job.Status),
+		Progress:      job.Progress,
+		URLPath:       job.URLPath,
+		URLPathThumb:  job.URLPathThumb,
+		Error:         job.Error,
+		PublishStatus: string(job.PublishStatus),
+		PublishError:  job.PublishError,
+	}
+	switch {
+	case job.Status == models.GenerationJobPending:
+		event.Type = "queued"
+	case job.Status == models.GenerationJobRunning && job.Progress > 0:
+		event.Type = "progress"
+	}
+	if job.URLPathMedium != nil {
+		event.URLPathMedium = *job.URLPathMedium
+	}
+	if job.WallpaperID != nil {
+		event.WallpaperID = *job.WallpaperID
+	}
+	return event
+}
+
+// Follow streams the state of the user's job: first its current state, then
+// every change. The stream closes after the job settles or when ctx is done.
+func (s *GenerationService) Follow(ctx context.Context, userID, jobID uint) (<-chan dto.GenerationEvent, error) {
+	// Subscribe before reading the job so no change in between is missed
+	changes, unsubscribe := s.events.subscribe(jobID)
+
+	job, err := s.findJob(userID, jobID)
+	if err != nil {
+		unsubscribe()
+		return nil, err
+	}
+
+	out := make(chan dto.GenerationEvent)
+	go func() {
+		defer close(out)
+		defer unsubscribe()
+
+		refresh := time.NewTicker(generationFollowRefresh)
+		defer refresh.Stop()
+
+		event := generationEvent(job)
+		for {
+			select {
+			case out <- event:
+			case <-ctx.Done():
+				return
+			}
+			if job.Settled() {
+				return
+			}
+
+			// Wait for the next change
+			last := event
+			for event == last {
+				select {
+				case <-ctx.Done():
+					return
+				case update := <-changes:
+					job = &update
+				case <-refresh.C:
+					current, err := s.findJob(userID, jobID)
+					if err != nil {
+						return
+					}
+					job = current
+				}
+				event = generationEvent(job)
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (s *GenerationService) findJob(userID, jobID uint) (*models.GenerationJob, error) {
+	var job models.GenerationJob
+	if err := s.db.Where("id = ? AND user_id = ?", jobID, userID).First(&job).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrGenerationJobNotFound
+		}
+		return nil, fmt.Errorf("failed to find generation job: %w", err)
+	}
+	return &job, nil
+}
+
+// IssueStreamTicket returns a single-use ticket that lets a client follow
+// the user's job without sending its token. Expired tickets are cleared.
+func (s *GenerationService) IssueStreamTicket(userID, jobID uint) (*dto.StreamTicket, error) {
+	if _, err := s.findJob(userID, jobID); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate stream ticket: %w", err)
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now()
+
+	if err := s.db.Where("expires_at < ?", now).Delete(&models.StreamTicket{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to clear stream tickets: %w", err)
+	}
+	row := models.StreamTicket{
+		Hash:      streamTicketHash(ticket),
+		UserID:    userID,
+		JobID:     jobID,
+		ExpiresAt: now.Add(streamTicketTTL),
+	}
+	if err := s.db.Create(&row).Error; err != nil {
+		return nil, fmt.Errorf("failed to create stream ticket: %w", err)
+	}
+	return &dto.StreamTicket{Ticket: ticket, ExpiresAt: row.ExpiresAt}, nil
+}
+
+// RedeemStreamTicket uses up a ticket for the job and returns the user it
+// was issued to
+func (s *GenerationService) RedeemStreamTicket(ticket string, jobID uint) (uint, error) {
+	var rows []models.StreamTicket
+	err := s.db.Clauses(clause.Returning{}).
+		Where("hash = ? AND job_id = ? AND expires_at >= ?", streamTicketHash(ticket), jobID, time.Now()).
+		Delete(&rows).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to redeem stream ticket: %w", err)
+	}
+	if len(rows) == 0 {
+		return 0, ErrInvalidStreamTicket
+	}
+	return rows[0].UserID, nil
+}
+
+func streamTicketHash(ticket string) string {
+	sum := sha256.Sum256([]byte(ticket))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"wallpaperio/server/internal/config"
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/pkg/image_generator"
+)
+
+// generationPollBatch caps the jobs checked per tick
+const generationPollBatch = 50
+
+// GenerationPoller checks the generator for pending generation jobs in the
+// background so status reads are served from the database. Each job is
+// checked with exponential backoff and fails once it is pending longer than
+// the configured timeout. Completed jobs waiting to be published are
+// published, and publishes that never finished are settled.
+type GenerationPoller struct {
+	svc         *GenerationService
+	interval    time.Duration
+	maxInterval time.Duration
+	timeout     time.Duration
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+func NewGenerationPoller(svc *GenerationService, cfg *config.ImageGeneratorConfig) *GenerationPoller {
+	maxInterval := cfg.PollMaxInterval
+	if maxInterval < cfg.PollInterval {
+		maxInterval = cfg.PollInterval
+	}
+	return &GenerationPoller{
+		svc:         svc,
+		interval:    cfg.PollInterval,
+		maxInterval: maxInterval,
+		timeout:     cfg.Timeout,
+		stop:        make(chan struct{}),
+	}
+}
+
+// Start checks due jobs every poll interval until Stop is called
+func (p *GenerationPoller) Start() {
+	p.done.Add(1)
+	go func() {
+		defer p.done.Done()
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				if err := p.pollDue(); err != nil {
+					log.Printf("Generation poll failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop waits for the current check to finish and stops the poller
+func (p *GenerationPoller) Stop() {
+	close(p.stop)
+	p.done.Wait()
+}
+
+// pollDue checks the jobs whose next check is due, oldest first
+func (p *GenerationPoller) pollDue() error {
+	now := time.Now()
+	var jobs []models.GenerationJob
+	err := p.svc.db.Preload("Category").
+		Where("next_poll_at IS NULL OR next_poll_at <= ?", now).
+		Where("status IN ? OR (status = ? AND publish_status IN ?)",
+			[]models.GenerationJobStatus{models.GenerationJobPending, models.GenerationJobRunning},
+			models.GenerationJobCompleted, []models.PublishStatus{models.PublishPending, models.PublishInProgress}).
+		Order("next_poll_at NULLS FIRST").
+		Limit(generationPollBatch).
+		Find(&jobs).Error
+	if err != nil {
+		return fmt.Errorf("failed to list pending generation jobs: %w", err)
+	}
+
+	for i := range jobs {
+		if err := p.poll(&jobs[i], now); err != nil {
+			log.Printf("Failed to check generation job %d: %v", jobs[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// poll claims the job by scheduling its next check, then records the task's
+// status. A job another server instance claimed first is skipped. While the
+// task makes progress it is checked at the poll interval again.
+func (p *GenerationPoller) poll(job *models.GenerationJob, now time.Time) error {
+	result := p.svc.db.Model(&models.GenerationJob{}).
+		Where("id = ? AND next_poll_at IS NOT DISTINCT FROM ?", job.ID, job.NextPollAt).
+		Updates(map[string]interface{}{
+			"next_poll_at":  now.Add(p.backoff(job.PollAttempts + 1)),
+			"poll_attempts": job.PollAttempts + 1,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to schedule generation job: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	if job.Active() {
+		task, err := p.check(job, now)
+		if err != nil {
+			return err
+		}
+		changed, err := p.svc.recordStatus(job, task)
+		if err != nil {
+			return err
+		}
+		if changed && job.Active() {
+			err := p.svc.db.Model(&models.GenerationJob{}).
+				Where("id = ?", job.ID).
+				Updates(map[string]interface{}{
+					"next_poll_at":  now.Add(p.interval),
+					"poll_attempts": 0,
+				}).Error
+			if err != nil {
+				return fmt.Errorf("failed to schedule generation job: %w", err)
+			}
+		}
+	}
+	if job.Status == models.GenerationJobCompleted {
+		switch job.PublishStatus {
+		case models.PublishPending:
+			return p.svc.publish(job)
+		case models.PublishInProgress:
+			return p.svc.resumePublish(job)
+		}
+	}
+	return nil
+}
+
+// check asks the generator for the task's status. A task that has not
+// finished by the timeout is reported failed.
+func (p *GenerationPoller) check(job *models.GenerationJob, now time.Time) (*image_generator.TaskStatus, error) {
+	task, err := p.svc.client.GetTaskStatus(job.TaskID)
+	if err == nil {
+		if status, ok := jobStatus(task.Status); ok && status != models.GenerationJobPending && status != models.GenerationJobRunning {
+			return task, nil
+		}
+	}
+	if now.Sub(job.CreatedAt) > p.timeout {
+		message := fmt.Sprintf("generation timed out after %s", p.timeout)
+		return &image_generator.TaskStatus{Status: image_generator.TaskFailed, Error: &message}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task status: %w", err)
+	}
+	return task, nil
+}
+
+// backoff is the wait before the given check: the poll interval, doubled
+// per earlier check up to the maximum interval
+func (p *GenerationPoller) backoff(attempt int) time.Duration {
+	wait := p.interval
+	for i := 1; i < attempt && wait < p.maxInterval; i++ {
+		wait *= 2
+	}
+	if wait > p.maxInterval {
+		wait = p.maxInterval
+	}
+	return wait
+}
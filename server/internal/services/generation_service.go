@@ -0,0 +1,365 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/domain/models/dto"
+	"wallpaperio/server/pkg/image_generator"
+
+	"gorm.io/gorm"
+)
+
+// publishTimeout is how long publishing a job may take before the poller
+// takes the server that claimed it for dead and settles the job itself
+const publishTimeout = 10 * time.Minute
+
+var (
+	ErrGenerationJobNotFound = errors.New("generation job not found")
+	ErrNoTaskID              = errors.New("generator returned no task ID")
+)
+
+// GenerationService submits image generation requests to the generator and
+// keeps a job per request so users can follow their generations. Completed
+// generations can be published as wallpapers.
+type GenerationService struct {
+	db           *gorm.DB
+	client       *image_generator.Client
+	wallpaperSvc *WallpaperService
+	quotaSvc     *QuotaService
+	autoPublish  bool
+	events       *generationEvents
+}
+
+func NewGenerationService(db *gorm.DB, client *image_generator.Client, wallpaperSvc *WallpaperService, quotaSvc *QuotaService, autoPublish bool) *GenerationService {
+	return &GenerationService{
+		db:           db,
+		client:       client,
+		wallpaperSvc: wallpaperSvc,
+		quotaSvc:     quotaSvc,
+		autoPublish:  autoPublish,
+		events:       newGenerationEvents(),
+	}
+}
+
+// Submit starts a generation task for the user, counts it against their
+// role's quotas and records it as a pending job. It returns the job and the
+// user's quota usage. The result is published as a wallpaper if
+// auto-publishing is on, unless an admin's request asks otherwise.
+func (s *GenerationService) Submit(userID uint, role string, req dto.ImageCreate) (*models.GenerationJob, *dto.GenerationQuota, error) {
+	var category models.Category
+	if err := s.db.Where("name = ?", req.Category).First(&category).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrCategoryNotFound
+		}
+		return nil, nil, fmt.Errorf("failed to find category: %w", err)
+	}
+
+	reservedAt := time.Now()
+	quota, err := s.quotaSvc.Reserve(userID, role, reservedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The generation only counts if the generator accepts it and its job is
+	// recorded
+	release := func() {
+		if err := s.quotaSvc.Release(userID, reservedAt); err != nil {
+			log.Printf("Failed to release generation quota of user %d: %v", userID, err)
+		}
+	}
+
+	resp, err := s.client.GenerateImageAI(&image_generator.GenerateRequest{
+		Prompt:         req.Prompt,
+		NegativePrompt: req.NegativePrompt,
+		Width:          req.Width,
+		Height:         req.Height,
+		GeneratorType:  req.GeneratorType,
+	})
+	if err != nil {
+		release()
+		return nil, nil, fmt.Errorf("failed to start generation: %w", err)
+	}
+	if resp.TaskID == nil {
+		release()
+		return nil, nil, ErrNoTaskID
+	}
+
+	tags := req.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	nextPollAt := time.Now()
+	job := &models.GenerationJob{
+		UserID:         userID,
+		TaskID:         *resp.TaskID,
+		Prompt:         req.Prompt,
+		NegativePrompt: req.NegativePrompt,
+		Width:          req.Width,
+		Height:         req.Height,
+		GeneratorType:  req.GeneratorType,
+		CategoryID:     category.ID,
+		Category:       category,
+		Tags:           tags,
+		Status:         models.GenerationJobPending,
+		NextPollAt:     &nextPollAt,
+	}
+	publish := s.autoPublish
+	if models.UserRole(role).IsAdmin() && req.Publish != nil {
+		publish = *req.Publish
+	}
+	if publish {
+		job.PublishStatus = models.PublishPending
+	}
+	if err := s.db.Omit("Category").Create(job).Error; err != nil {
+		// Without its job the task is never polled or published, and the
+		// generator cannot cancel it, so the user is not charged for it
+		release()
+		return nil, nil, fmt.Errorf("failed to create generation job: %w", err)
+	}
+	return job, quota, nil
+}
+
+// Quota returns the user's generation quota usage
+func (s *GenerationService) Quota(userID uint, role string) (*dto.GenerationQuota, error) {
+	return s.quotaSvc.Usage(userID, role)
+}
+
+// Status returns the user's job for the task as last recorded by the
+// generation poller
+func (s *GenerationService) Status(userID uint, taskID string) (*models.GenerationJob, error) {
+	var job models.GenerationJob
+	err := s.db.Preload("Category").
+		Where("task_id = ? AND user_id = ?", taskID, userID).
+		First(&job).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrGenerationJobNotFound
+		}
+		return nil, fmt.Errorf("failed to find generation job: %w", err)
+	}
+	return &job, nil
+}
+
+// jobStatus maps a status reported by the generator onto the job's status
+func jobStatus(taskStatus string) (models.GenerationJobStatus, bool) {
+	switch taskStatus {
+	case image_generator.TaskPending:
+		return models.GenerationJobPending, true
+	case image_generator.TaskStarted:
+		return models.GenerationJobRunning, true
+	case image_generator.TaskSuccess:
+		return models.GenerationJobCompleted, true
+	case image_generator.TaskFailed:
+		return models.GenerationJobFailed, true
+	}
+	return "", false
+}
+
+// TaskStatus is the generator's name for the job's status, as the status
+// endpoint has always reported it
+func TaskStatus(status models.GenerationJobStatus) string {
+	switch status {
+	case models.GenerationJobRunning:
+		return image_generator.TaskStarted
+	case models.GenerationJobCompleted:
+		return image_generator.TaskSuccess
+	case models.GenerationJobFailed:
+		return image_generator.TaskFailed
+	}
+	return image_generator.TaskPending
+}
+
+// recordStatus copies the task's status onto the job: its progress while
+// it runs and its result once it finishes. It reports whether the job
+// changed. Statuses the generator does not document leave the job as is.
+func (s *GenerationService) recordStatus(job *models.GenerationJob, task *image_generator.TaskStatus) (bool, error) {
+	status, ok := jobStatus(task.Status)
+	if !ok {
+		log.Printf("Generator reported unknown status %q for task %s", task.Status, job.TaskID)
+		return false, nil
+	}
+	progress := job.Progress
+	if task.Progress != nil {
+		progress = max(0, min(100, *task.Progress))
+	}
+	if status == models.GenerationJobCompleted {
+		progress = 100
+	}
+	if status == job.Status && progress == job.Progress {
+		return false, nil
+	}
+
+	updates := map[string]interface{}{
+		"status":   status,
+		"progress": progress,
+	}
+	if status == models.GenerationJobCompleted || status == models.GenerationJobFailed {
+		now := time.Now()
+		job.CompletedAt = &now
+		if status == models.GenerationJobCompleted {
+			job.URLPath = task.UrlPath
+			job.URLPathThumb = task.UrlPathThumb
+			job.URLPathMedium = task.UrlPathMedium
+		} else {
+			job.Error = "generation failed"
+			if task.Error != nil && *task.Error != "" {
+				job.Error = *task.Error
+			}
+		}
+		updates["url_path"] = job.URLPath
+		updates["url_path_thumb"] = job.URLPathThumb
+		updates["url_path_medium"] = job.URLPathMedium
+		updates["error"] = job.Error
+		updates["completed_at"] = job.CompletedAt
+	}
+	job.Status = status
+	job.Progress = progress
+
+	// Only the first check to see the task finish records it
+	result := s.db.Model(&models.GenerationJob{}).
+		Where("id = ? AND status IN ?", job.ID, []models.GenerationJobStatus{models.GenerationJobPending, models.GenerationJobRunning}).
+		Updates(updates)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to update generation job: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		if err := s.db.Preload("Category").First(job, job.ID).Error; err != nil {
+			return false, fmt.Errorf("failed to reload generation job: %w", err)
+		}
+		return false, nil
+	}
+	s.events.publish(job)
+	return true, nil
+}
+
+// publish creates the wallpaper of a completed job. Only the caller that
+// claims the job publishes it, and the wallpaper's unique generation_job_id
+// keeps each job to at most one wallpaper; a failure is recorded on the job
+// rather than retried. The claim schedules the job's next poll after
+// publishTimeout, so a publish that never finishes is settled by
+// resumePublish.
+func (s *GenerationService) publish(job *models.GenerationJob) error {
+	retryAt := time.Now().Add(publishTimeout)
+	result := s.db.Model(&models.GenerationJob{}).
+		Where("id = ? AND publish_status = ?", job.ID, models.PublishPending).
+		Updates(map[string]interface{}{
+			"publish_status": models.PublishInProgress,
+			"next_poll_at":   retryAt,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to claim generation job: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		if err := s.db.Preload("Category").First(job, job.ID).Error; err != nil {
+			return fmt.Errorf("failed to reload generation job: %w", err)
+		}
+		return nil
+	}
+	job.PublishStatus = models.PublishInProgress
+	job.NextPollAt = &retryAt
+
+	jobID := job.ID
+	wallpaper, err := s.wallpaperSvc.CreateWallpaper(dto.CreateWallpaper{
+		ImageURL:        job.URLPath,
+		ImageThumbUrl:   job.URLPathThumb,
+		ImageMediumUrl:  job.URLPathMedium,
+		Category:        job.Category.Name,
+		Tags:            job.Tags,
+		Width:           job.Width,
+		Height:          job.Height,
+		GenerationJobID: &jobID,
+		Prompt:          job.Prompt,
+	})
+	if err != nil {
+		// An earlier publish that was taken for dead may have created it
+		if existing, findErr := s.generatedWallpaper(job.ID); findErr == nil && existing != nil {
+			wallpaper, err = existing, nil
+		}
+	}
+	return s.recordPublish(job, wallpaper, err)
+}
+
+// resumePublish settles a job left publishing past publishTimeout, e.g.
+// because the server publishing it stopped: if its wallpaper was created
+// the job is recorded as published, otherwise it is published again
+func (s *GenerationService) resumePublish(job *models.GenerationJob) error {
+	wallpaper, err := s.generatedWallpaper(job.ID)
+	if err != nil {
+		return err
+	}
+	if wallpaper != nil {
+		return s.recordPublish(job, wallpaper, nil)
+	}
+
+	result := s.db.Model(&models.GenerationJob{}).
+		Where("id = ? AND publish_status = ?", job.ID, models.PublishInProgress).
+		Update("publish_status", models.PublishPending)
+	if result.Error != nil {
+		return fmt.Errorf("failed to reset generation job: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+	job.PublishStatus = models.PublishPending
+	return s.publish(job)
+}
+
+// generatedWallpaper returns the wallpaper published from the job, or nil
+func (s *GenerationService) generatedWallpaper(jobID uint) (*models.Wallpaper, error) {
+	var wallpaper models.Wallpaper
+	err := s.db.Select("id").Where("generation_job_id = ?", jobID).First(&wallpaper).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find generated wallpaper: %w", err)
+	}
+	return &wallpaper, nil
+}
+
+// recordPublish records the outcome of publishing the job
+func (s *GenerationService) recordPublish(job *models.GenerationJob, wallpaper *models.Wallpaper, err error) error {
+	updates := map[string]interface{}{}
+	if err != nil {
+		log.Printf("Failed to publish generation job %d: %v", job.ID, err)
+		job.PublishStatus = models.PublishFailed
+		job.PublishError = err.Error()
+		updates["publish_error"] = job.PublishError
+	} else {
+		job.PublishStatus = models.PublishPublished
+		job.WallpaperID = &wallpaper.ID
+		job.PublishError = ""
+		updates["wallpaper_id"] = wallpaper.ID
+		updates["publish_error"] = ""
+	}
+	updates["publish_status"] = job.PublishStatus
+
+	if err := s.db.Model(&models.GenerationJob{}).Where("id = ?", job.ID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update generation job: %w", err)
+	}
+	s.events.publish(job)
+	return nil
+}
+
+// ListJobs returns the user's jobs, newest first, and their total count
+func (s *GenerationService) ListJobs(userID uint, limit, offset int) ([]models.GenerationJob, int64, error) {
+	var total int64
+	if err := s.db.Model(&models.GenerationJob{}).Where("user_id = ?", userID).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count generation jobs: %w", err)
+	}
+
+	jobs := []models.GenerationJob{}
+	err := s.db.Preload("Category").
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&jobs).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list generation jobs: %w", err)
+	}
+	return jobs, total, nil
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"wallpaperio/server/internal/services/storage"
+)
+
+// fetchTimeout bounds fetching one image over HTTP
+const fetchTimeout = 60 * time.Second
+
+var ErrImageTooLarge = errors.New("image has too many pixels")
+
+// imageInfo is what is known about an image file from its content
+type imageInfo struct {
+	contentHash string
+	width       int
+	height      int
+	bytes       int64
+	mimeType    string
+}
+
+// ImageFetcher opens wallpaper images by URL: from storage when the URL
+// points into it, otherwise over HTTP, resolving paths in the generator's
+// static directory against the images host
+type ImageFetcher struct {
+	storage       storage.Storage
+	imagesBaseURL string
+	client        *http.Client
+	// maxPixels caps the width times height of decoded images
+	maxPixels int64
+}
+
+func NewImageFetcher(storage storage.Storage, imagesBaseURL string, maxPixels int64) *ImageFetcher {
+	return &ImageFetcher{
+		storage:       storage,
+		imagesBaseURL: strings.TrimSuffix(imagesBaseURL, "/"),
+		client:        &http.Client{Timeout: fetchTimeout},
+		maxPixels:     maxPixels,
+	}
+}
+
+// Open returns the content of the image; the caller closes it
+func (f *ImageFetcher) Open(imageURL string) (io.ReadCloser, error) {
+	if key, ok := f.storage.KeyForURL(imageURL); ok {
+		obj, err := f.storage.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		return obj, nil
+	}
+
+	resp, err := f.client.Get(f.resolveURL(imageURL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to fetch image: unexpected status code %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+func (f *ImageFetcher) resolveURL(imageURL string) string {
+	if isAbsoluteURL(imageURL) {
+		return imageURL
+	}
+	return fmt.Sprintf("%s/%s", f.imagesBaseURL, strings.TrimPrefix(imageURL, "/"))
+}
+
+// Decode opens and decodes the image
+func (f *ImageFetcher) Decode(imageURL string) (image.Image, error) {
+	r, err := f.Open(imageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return f.decode(r)
+}
+
+// decode reads the image's dimensions from its header and decodes it only
+// if it is within the pixel limit, since a small file can expand to an
+// enormous bitmap
+func (f *ImageFetcher) decode(r io.Reader) (image.Image, error) {
+	var header bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if f.maxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > f.maxPixels {
+		return nil, fmt.Errorf("%w: %dx%d is over %d", ErrImageTooLarge, cfg.Width, cfg.Height, f.maxPixels)
+	}
+
+	img, _, err := image.Decode(io.MultiReader(&header, r))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	return img, nil
+}
+
+// Inspect hashes the image and reads its dimensions and type
+func (f *ImageFetcher) Inspect(imageURL string) (*imageInfo, error) {
+	r, err := f.Open(imageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return inspectImage(r)
+}
+
+// inspectImage hashes everything read from r and reads the image's
+// dimensions and type from its header
+func inspectImage(r io.Reader) (*imageInfo, error) {
+	hash := sha256.New()
+	counter := &countingWriter{}
+	buffered := bufio.NewReader(io.TeeReader(r, io.MultiWriter(hash, counter)))
+
+	info := &imageInfo{}
+	head, _ := buffered.Peek(512)
+	info.mimeType = http.DetectContentType(head)
+	if cfg, _, err := image.DecodeConfig(buffered); err == nil {
+		info.width, info.height = cfg.Width, cfg.Height
+	}
+
+	if _, err := io.Copy(io.Discard, buffered); err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	info.contentHash = hex.EncodeToString(hash.Sum(nil))
+	info.bytes = counter.n
+	return info, nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+func isAbsoluteURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/domain/models/dto"
+)
+
+// importedImages describes the images of a wallpaper copied into storage
+type importedImages struct {
+	// blobKeys are the keys of the stored images, which other wallpapers
+	// may share and which stay referenced until unpinned
+	blobKeys []string
+	// sourceURL is where the server reads the original from
+	sourceURL string
+	// info describes the original; nil if it was not imported
+	info *imageInfo
+}
+
+// importImages copies the wallpaper's images that are paths into the
+// generator's static directory into storage and points params at the
+// copies, so the server no longer depends on the generator's disk. The
+// original is stored by content hash and the thumb and medium image under
+// it. Absolute URLs are left alone. Without a generator images URL nothing
+// is imported.
+func (s *WallpaperService) importImages(params *dto.CreateWallpaper) (*importedImages, error) {
+	imported := &importedImages{sourceURL: params.ImageURL}
+	if s.fetcher.imagesBaseURL == "" {
+		return imported, nil
+	}
+
+	importOne := func(url *string, name string) error {
+		if *url == "" || isAbsoluteURL(*url) {
+			return nil
+		}
+		data, contentType, err := s.fetchGeneratorImage(*url)
+		if err != nil {
+			return err
+		}
+		var key string
+		if name == "" {
+			var info *imageInfo
+			key, info, err = s.blobs.Put(data, contentType)
+			if err != nil {
+				return err
+			}
+			imported.info = info
+			imported.sourceURL = s.storage.InternalURL(key)
+		} else {
+			// Without an imported original the image is keyed by its own hash
+			sourceHash := ""
+			if imported.info != nil {
+				sourceHash = imported.info.contentHash
+			}
+			if !ValidContentHash(sourceHash) {
+				sum := sha256.Sum256(data)
+				sourceHash = hex.EncodeToString(sum[:])
+			}
+			key, _, err = s.blobs.PutDerived(sourceHash, name, path.Ext(*url), contentType, func() ([]byte, error) {
+				return data, nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		imported.blobKeys = append(imported.blobKeys, key)
+		*url = s.storage.URL(key)
+		return nil
+	}
+
+	if err := importOne(&params.ImageURL, ""); err != nil {
+		s.discardImports(imported)
+		return nil, err
+	}
+	if err := importOne(&params.ImageThumbUrl, "thumb_imported"); err != nil {
+		s.discardImports(imported)
+		return nil, err
+	}
+	if params.ImageMediumUrl != nil {
+		// Copy so the caller's string is not rewritten through the pointer
+		medium := *params.ImageMediumUrl
+		if err := importOne(&medium, "medium_imported"); err != nil {
+			s.discardImports(imported)
+			return nil, err
+		}
+		params.ImageMediumUrl = &medium
+	}
+	return imported, nil
+}
+
+func (s *WallpaperService) fetchGeneratorImage(imagePath string) ([]byte, string, error) {
+	r, err := s.fetcher.Open(imagePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch %s: %w", imagePath, err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s: %w", imagePath, err)
+	}
+	return data, http.DetectContentType(data), nil
+}
+
+// discardImports removes the images imported for a wallpaper that was not
+// created, unless other wallpapers use them
+func (s *WallpaperService) discardImports(imported *importedImages) {
+	s.unpinImports(imported)
+}
+
+// unpinImports drops the references held on the imported images since they
+// were stored, once their wallpaper has been created or has failed to be
+func (s *WallpaperService) unpinImports(imported *importedImages) {
+	for _, key := range imported.blobKeys {
+		s.blobs.unpin(key)
+	}
+}
+
+// deleteStoredImages removes the wallpaper's images, variants and renditions
+// that live in storage. Images hosted elsewhere are left alone, and so are
+// blobs, which are reference counted.
+func (s *WallpaperService) deleteStoredImages(wallpaper *models.Wallpaper) {
+	urls := []string{wallpaper.ImageURL, wallpaper.ImageThumbURL}
+	if wallpaper.ImageMediumURL != nil {
+		urls = append(urls, *wallpaper.ImageMediumURL)
+	}
+	for _, v := range wallpaper.Variants {
+		urls = append(urls, v.URL)
+	}
+
+	var keys []string
+	seen := make(map[string]bool)
+	for _, url := range urls {
+		key, ok := s.storage.KeyForURL(url)
+		if !ok || seen[key] {
+			continue
+		}
+		if _, blob := blobHash(key); blob {
+			continue
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	for _, r := range wallpaper.Renditions {
+		keys = append(keys, r.Key)
+	}
+	s.deleteObjects(keys)
+}
+
+// deleteObjects removes objects from storage, logging failures since the
+// objects are only left orphaned
+func (s *WallpaperService) deleteObjects(keys []string) {
+	for _, key := range keys {
+		if err := s.storage.Delete(key); err != nil {
+			log.Printf("Failed to delete %s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/domain/models/dto"
+
+	"gorm.io/gorm"
+)
+
+const (
+	OrientationPortrait  = "portrait"
+	OrientationLandscape = "landscape"
+	OrientationSquare    = "square"
+
+	// squareTolerance is how far from 1:1 an image may be and still count
+	// as square
+	squareTolerance = 0.05
+	// aspectRatioTolerance is how far, relative to the ratio, an image's
+	// aspect ratio may be from a requested one
+	aspectRatioTolerance = 0.03
+)
+
+// MetadataService fills in the image metadata of wallpapers created before
+// it was recorded at ingest
+type MetadataService struct {
+	db       *gorm.DB
+	fetcher  *ImageFetcher
+	backfill *backfillJob
+}
+
+func NewMetadataService(db *gorm.DB, fetcher *ImageFetcher) *MetadataService {
+	s := &MetadataService{
+		db:      db,
+		fetcher: fetcher,
+	}
+	s.backfill = newBackfillJob("Metadata", db, func(db *gorm.DB) *gorm.DB {
+		return db.Where("(width = 0 OR height = 0 OR bytes = 0)")
+	}, s.backfillOne)
+	return s
+}
+
+func (s *MetadataService) Status() dto.BackfillStatus {
+	return s.backfill.Status()
+}
+
+// StartBackfill reads the metadata of every wallpaper missing it in the
+// background
+func (s *MetadataService) StartBackfill() (dto.BackfillStatus, error) {
+	return s.backfill.Start()
+}
+
+// Backfill reads the metadata of every wallpaper missing it and waits for
+// the result
+func (s *MetadataService) Backfill() (dto.BackfillStatus, error) {
+	return s.backfill.Run()
+}
+
+func (s *MetadataService) backfillOne(wallpaper *models.Wallpaper) (bool, error) {
+	info, err := s.fetcher.Inspect(wallpaper.ImageURL)
+	if err != nil {
+		return false, err
+	}
+	if info.width == 0 || info.height == 0 {
+		return false, fmt.Errorf("%w: unknown image format %s", ErrInvalidImage, info.mimeType)
+	}
+
+	applyImageMetadata(wallpaper, info.width, info.height, info.bytes, info.mimeType)
+	updates := map[string]interface{}{
+		"width":        wallpaper.Width,
+		"height":       wallpaper.Height,
+		"aspect_ratio": wallpaper.AspectRatio,
+		"orientation":  wallpaper.Orientation,
+		"bytes":        wallpaper.Bytes,
+		"mime_type":    wallpaper.MimeType,
+	}
+	if wallpaper.ContentHash == "" {
+		updates["content_hash"] = info.contentHash
+	}
+	if err := s.db.Model(&models.Wallpaper{}).Where("id = ?", wallpaper.ID).Updates(updates).Error; err != nil {
+		return false, fmt.Errorf("failed to update wallpaper: %w", err)
+	}
+	return true, nil
+}
+
+// applyImageMetadata sets the wallpaper's dimensions, size and type
+func applyImageMetadata(wallpaper *models.Wallpaper, width, height int, bytes int64, mimeType string) {
+	wallpaper.Width = width
+	wallpaper.Height = height
+	wallpaper.Bytes = bytes
+	wallpaper.MimeType = mimeType
+	wallpaper.AspectRatio = 0
+	wallpaper.Orientation = ""
+	if width > 0 && height > 0 {
+		ratio := float64(width) / float64(height)
+		wallpaper.AspectRatio = math.Round(ratio*10000) / 10000
+		wallpaper.Orientation = orientationOf(ratio)
+	}
+}
+
+func orientationOf(ratio float64) string {
+	switch {
+	case math.Abs(ratio-1) <= squareTolerance:
+		return OrientationSquare
+	case ratio > 1:
+		return OrientationLandscape
+	default:
+		return OrientationPortrait
+	}
+}
+
+// ValidOrientation reports whether o is an orientation wallpapers can be
+// filtered by
+func ValidOrientation(o string) bool {
+	return o == OrientationPortrait || o == OrientationLandscape || o == OrientationSquare
+}
+
+// ParseAspectRatio parses an aspect ratio such as "16:9" or "9:19.5" into
+// width divided by height
+func ParseAspectRatio(value string) (float64, error) {
+	w, h, ok := strings.Cut(value, ":")
+	if !ok {
+		return 0, fmt.Errorf("aspect ratio %q is not width:height", value)
+	}
+	width, err := strconv.ParseFloat(w, 64)
+	if err != nil || width <= 0 {
+		return 0, fmt.Errorf("aspect ratio %q has an invalid width", value)
+	}
+	height, err := strconv.ParseFloat(h, 64)
+	if err != nil || height <= 0 {
+		return 0, fmt.Errorf("aspect ratio %q has an invalid height", value)
+	}
+	return width / height, nil
+}
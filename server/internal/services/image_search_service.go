@@ -0,0 +1,196 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+
+	"wallpaperio/server/internal/domain/models/dto"
+)
+
+const (
+	// MaxSearchImageSize is the largest image accepted for reverse image
+	// search, uploaded or fetched
+	MaxSearchImageSize = 10 << 20
+	// searchFetchTimeout bounds fetching an image URL, redirects included
+	searchFetchTimeout = 15 * time.Second
+	// searchFetchRedirects is how many redirects an image URL may follow
+	searchFetchRedirects = 3
+)
+
+var (
+	ErrInvalidImageURL   = errors.New("image URL must be an absolute http or https URL")
+	ErrImageURLForbidden = errors.New("image URL must point to a public host")
+	ErrImageURLFetch     = errors.New("image URL could not be fetched")
+	ErrSearchImageSize   = fmt.Errorf("image must be at most %d MB", MaxSearchImageSize>>20)
+)
+
+// ImageSearchService finds catalogue wallpapers that look like a given image.
+// The image's features are extracted but never stored.
+type ImageSearchService struct {
+	featureSvc    *FeatureService
+	featureModels *FeatureModelService
+	wallpaperSvc  *WallpaperService
+	client        *http.Client
+}
+
+func NewImageSearchService(featureSvc *FeatureService, featureModels *FeatureModelService, wallpaperSvc *WallpaperService) *ImageSearchService {
+	return &ImageSearchService{
+		featureSvc:    featureSvc,
+		featureModels: featureModels,
+		wallpaperSvc:  wallpaperSvc,
+		client:        newPublicHTTPClient(),
+	}
+}
+
+// SearchByURL returns the wallpapers most similar to the image at imageURL.
+// The server fetches the image itself, from public addresses only, and
+// hands the feature extractor its bytes.
+func (s *ImageSearchService) SearchByURL(imageURL string, limit int, threshold float32) ([]dto.ScoredWallpaper, error) {
+	u, err := url.Parse(imageURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidImageURL
+	}
+	data, err := s.fetch(u.String())
+	if err != nil {
+		return nil, err
+	}
+	return s.SearchByUpload(data, limit, threshold)
+}
+
+// SearchByUpload returns the wallpapers most similar to an uploaded image.
+// The image is only kept in memory.
+func (s *ImageSearchService) SearchByUpload(data []byte, limit int, threshold float32) ([]dto.ScoredWallpaper, error) {
+	if _, ok := uploadImageTypes[http.DetectContentType(data)]; !ok {
+		return nil, ErrUnsupportedImage
+	}
+	model, vectors := s.featureModels.Active()
+	limit = min(limit, MaxSearchWindow)
+
+	features, err := s.featureSvc.ExtractFeaturesFromData(data, model.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract features: %w", err)
+	}
+
+	matches, err := vectors.Search(features, SearchOptions{Limit: limit, MinScore: &threshold})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search similar wallpapers: %w", err)
+	}
+
+	return s.wallpaperSvc.wallpapersForMatches(matches, threshold, model.Key())
+}
+
+// fetch downloads an image of at most MaxSearchImageSize
+func (s *ImageSearchService) fetch(imageURL string) ([]byte, error) {
+	resp, err := s.client.Get(imageURL)
+	if err != nil {
+		if errors.Is(err, ErrImageURLForbidden) {
+			return nil, ErrImageURLForbidden
+		}
+		return nil, fmt.Errorf("%w: %v", ErrImageURLFetch, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d", ErrImageURLFetch, resp.StatusCode)
+	}
+	if resp.ContentLength > MaxSearchImageSize {
+		return nil, ErrSearchImageSize
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxSearchImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrImageURLFetch, err)
+	}
+	if len(data) > MaxSearchImageSize {
+		return nil, ErrSearchImageSize
+	}
+	return data, nil
+}
+
+// newPublicHTTPClient returns a client that only connects to public
+// addresses. The check runs on the address actually dialled, so it covers
+// redirects and host names that resolve to private addresses.
+func newPublicHTTPClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublicIP(ip) {
+				return ErrImageURLForbidden
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   searchFetchTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= searchFetchRedirects {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+}
+
+// nonPublicPrefixes are special-purpose ranges that IsGlobalUnicast and
+// IsPrivate let through
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64, embeds any IPv4 address
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local-use NAT64
+	netip.MustParsePrefix("100::/64"),        // discard
+	netip.MustParsePrefix("2001::/32"),       // Teredo, embeds an IPv4 address
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("2002::/16"),       // 6to4, embeds an IPv4 address
+}
+
+// isPublicIP reports whether ip is a globally routable unicast address
+func isPublicIP(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func randomName() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate file name: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+)
+
+// normalize scales v to unit length in place so that dot products are cosine
+// similarities
+func normalize(v []float32) {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		return
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range v {
+		v[i] *= scale
+	}
+}
+
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// kmeans clusters unit vectors by cosine similarity (spherical k-means),
+// seeded with k-means++. It returns each vector's cluster, its cosine distance
+// to that cluster's centroid and the number of iterations run.
+func kmeans(vectors [][]float32, k, maxIterations int, rng *rand.Rand) ([]int, []float32, int) {
+	n := len(vectors)
+	centroids := seedCentroids(vectors, k, rng)
+	assign := make([]int, n)
+	dist := make([]float32, n)
+	for i := range assign {
+		assign[i] = -1
+	}
+
+	iterations := 0
+	for {
+		iterations++
+		if !assignClusters(vectors, centroids, assign, dist) || iterations >= maxIterations {
+			break
+		}
+		updateCentroids(vectors, centroids, assign, dist)
+	}
+
+	return assign, dist, iterations
+}
+
+// seedCentroids picks k starting centroids with k-means++: each next centroid
+// is drawn with probability proportional to its squared distance from the
+// closest centroid picked so far
+func seedCentroids(vectors [][]float32, k int, rng *rand.Rand) [][]float32 {
+	n := len(vectors)
+	centroids := make([][]float32, 0, k)
+	centroids = append(centroids, append([]float32(nil), vectors[rng.Intn(n)]...))
+
+	closest := make([]float64, n)
+	for i, v := range vectors {
+		closest[i] = cosineDistance(v, centroids[0])
+	}
+
+	for len(centroids) < k {
+		var total float64
+		for _, d := range closest {
+			total += d * d
+		}
+
+		next := rng.Intn(n)
+		if total > 0 {
+			target := rng.Float64() * total
+			for i, d := range closest {
+				target -= d * d
+				if target <= 0 {
+					next = i
+					break
+				}
+			}
+		}
+
+		centroid := append([]float32(nil), vectors[next]...)
+		centroids = append(centroids, centroid)
+		for i, v := range vectors {
+			if d := cosineDistance(v, centroid); d < closest[i] {
+				closest[i] = d
+			}
+		}
+	}
+
+	return centroids
+}
+
+func cosineDistance(a, b []float32) float64 {
+	return math.Max(0, 1-float64(dot(a, b)))
+}
+
+// assignClusters moves every vector to its most similar centroid, splitting
+// the work across CPUs. It reports whether any assignment changed.
+func assignClusters(vectors, centroids [][]float32, assign []int, dist []float32) bool {
+	workers := runtime.NumCPU()
+	chunk := (len(vectors) + workers - 1) / workers
+
+	var wg sync.WaitGroup
+	changed := make([]bool, workers)
+	for w := 0; w < workers; w++ {
+		start := w * chunk
+		end := start + chunk
+		if end > len(vectors) {
+			end = len(vectors)
+		}
+		if start >= end {
+			break
+		}
+
+		wg.Add(1)
+		go func(w, start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				best, bestScore := 0, float32(-2)
+				for c, centroid := range centroids {
+					if score := dot(vectors[i], centroid); score > bestScore {
+						best, bestScore = c, score
+					}
+				}
+				if assign[i] != best {
+					assign[i] = best
+					changed[w] = true
+				}
+				dist[i] = 1 - bestScore
+			}
+		}(w, start, end)
+	}
+	wg.Wait()
+
+	for _, c := range changed {
+		if c {
+			return true
+		}
+	}
+	return false
+}
+
+// updateCentroids recomputes each centroid as the normalised mean of its
+// members. An empty cluster takes over the vector furthest from its centroid.
+func updateCentroids(vectors, centroids [][]float32, assign []int, dist []float32) {
+	counts := make([]int, len(centroids))
+	for _, centroid := range centroids {
+		for j := range centroid {
+			centroid[j] = 0
+		}
+	}
+	for i, v := range vectors {
+		c := assign[i]
+		counts[c]++
+		for j, x := range v {
+			centroids[c][j] += x
+		}
+	}
+
+	for c, centroid := range centroids {
+		if counts[c] > 0 {
+			normalize(centroid)
+			continue
+		}
+
+		furthest := 0
+		for i := range dist {
+			if dist[i] > dist[furthest] {
+				furthest = i
+			}
+		}
+		copy(centroid, vectors[furthest])
+		dist[furthest] = 0
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestCosineDistance(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float32
+		want float64
+	}{
+		{"identical", []float32{1, 0}, []float32{1, 0}, 0},
+		{"orthogonal", []float32{1, 0}, []float32{0, 1}, 1},
+		{"opposite", []float32{1, 0}, []float32{-1, 0}, 2},
+		{"45 degrees", []float32{1, 0}, []float32{float32(math.Sqrt2 / 2), float32(math.Sqrt2 / 2)}, 1 - math.Sqrt2/2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cosineDistance(tt.a, tt.b); math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("cosineDistance() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		v    []float32
+		want []float32
+	}{
+		{"scaled", []float32{3, 4}, []float32{0.6, 0.8}},
+		{"unit", []float32{0, 1}, []float32{0, 1}},
+		{"zero stays zero", []float32{0, 0}, []float32{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalize(tt.v)
+			for i := range tt.v {
+				if math.Abs(float64(tt.v[i]-tt.want[i])) > 1e-6 {
+					t.Fatalf("normalize() = %v, want %v", tt.v, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// jitteredGroups returns size unit vectors around each of the centres,
+// labelled with the index of their centre
+func jitteredGroups(centres [][]float32, size int, rng *rand.Rand) ([][]float32, []int) {
+	var vectors [][]float32
+	var labels []int
+	for c, centre := range centres {
+		for i := 0; i < size; i++ {
+			v := make([]float32, len(centre))
+			for j := range v {
+				v[j] = centre[j] + float32(rng.NormFloat64()*0.05)
+			}
+			normalize(v)
+			vectors = append(vectors, v)
+			labels = append(labels, c)
+		}
+	}
+	return vectors, labels
+}
+
+func TestKmeans(t *testing.T) {
+	tests := []struct {
+		name    string
+		centres [][]float32
+		size    int
+	}{
+		{"two groups", [][]float32{{1, 0, 0}, {0, 1, 0}}, 20},
+		{"three groups", [][]float32{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}, 15},
+		{"four groups in 4d", [][]float32{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1}}, 10},
+		{"one vector per group", [][]float32{{1, 0}, {0, 1}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			vectors, labels := jitteredGroups(tt.centres, tt.size, rng)
+			assign, dist, iterations := kmeans(vectors, len(tt.centres), 50, rng)
+
+			if iterations < 1 || iterations > 50 {
+				t.Errorf("iterations = %d, want 1..50", iterations)
+			}
+			// Every group must map to its own cluster
+			clusterOf := make(map[int]int)
+			groupOf := make(map[int]int)
+			for i, c := range assign {
+				if c < 0 || c >= len(tt.centres) {
+					t.Fatalf("vector %d assigned to cluster %d", i, c)
+				}
+				if want, ok := clusterOf[labels[i]]; ok && want != c {
+					t.Fatalf("group %d split across clusters %d and %d", labels[i], want, c)
+				}
+				if want, ok := groupOf[c]; ok && want != labels[i] {
+					t.Fatalf("cluster %d mixes groups %d and %d", c, want, labels[i])
+				}
+				clusterOf[labels[i]] = c
+				groupOf[c] = labels[i]
+			}
+			for i, d := range dist {
+				if d < -1e-6 || d > 0.1 {
+					t.Errorf("vector %d is %v from its centroid, want within 0.1", i, d)
+				}
+			}
+		})
+	}
+}
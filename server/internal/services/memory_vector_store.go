@@ -0,0 +1,307 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// MemoryVectorStore is an in-process VectorStore that answers similarity
+// queries by brute-force cosine search. When a path is given the vectors are
+// persisted to that file after every change and loaded back on startup.
+type MemoryVectorStore struct {
+	mu        sync.RWMutex
+	path      string
+	dimension int
+	nextID    int64
+	vectors   map[int64][]float32
+	// partitions holds the partition of every vector outside the default one
+	partitions map[int64]string
+}
+
+type memoryVectorSnapshot struct {
+	NextID     int64               `json:"next_id"`
+	Vectors    map[int64][]float32 `json:"vectors"`
+	Partitions map[int64]string    `json:"partitions,omitempty"`
+}
+
+func NewMemoryVectorStore(path string, dimension int) (*MemoryVectorStore, error) {
+	s := &MemoryVectorStore{
+		path:       path,
+		dimension:  dimension,
+		nextID:     1,
+		vectors:    make(map[int64][]float32),
+		partitions: make(map[int64]string),
+	}
+	if err := s.load(); err != nil {
+		return nil, fmt.Errorf("failed to load vector store: %w", err)
+	}
+	return s, nil
+}
+
+// StoreFeatures stores wallpaper features in a partition and returns the
+// feature ID
+func (s *MemoryVectorStore) StoreFeatures(features []float32, partition string) (int64, error) {
+	if len(features) != s.dimension {
+		return 0, fmt.Errorf("features dimension mismatch: got %d, expected %d", len(features), s.dimension)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.nextID
+	s.nextID++
+	s.vectors[id] = append([]float32(nil), features...)
+	s.setPartition(id, partition)
+
+	if err := s.save(); err != nil {
+		delete(s.vectors, id)
+		delete(s.partitions, id)
+		return 0, fmt.Errorf("failed to insert features: %w", err)
+	}
+	return id, nil
+}
+
+// StoreFeaturesBatch stores several feature vectors in a partition and returns
+// their feature IDs in input order
+func (s *MemoryVectorStore) StoreFeaturesBatch(features [][]float32, partition string) ([]int64, error) {
+	for i, f := range features {
+		if len(f) != s.dimension {
+			return nil, fmt.Errorf("features %d dimension mismatch: got %d, expected %d", i, len(f), s.dimension)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int64, len(features))
+	for i, f := range features {
+		ids[i] = s.nextID
+		s.nextID++
+		s.vectors[ids[i]] = append([]float32(nil), f...)
+		s.setPartition(ids[i], partition)
+	}
+
+	if err := s.save(); err != nil {
+		for _, id := range ids {
+			delete(s.vectors, id)
+			delete(s.partitions, id)
+		}
+		return nil, fmt.Errorf("failed to insert features: %w", err)
+	}
+	return ids, nil
+}
+
+// Search returns the stored vectors most similar to features with their
+// cosine similarity, best first
+func (s *MemoryVectorStore) Search(features []float32, opts SearchOptions) ([]VectorMatch, error) {
+	var allowed map[int64]bool
+	if opts.FeatureIDs != nil {
+		allowed = make(map[int64]bool, len(opts.FeatureIDs))
+		for _, id := range opts.FeatureIDs {
+			allowed[id] = true
+		}
+	}
+	var inPartitions map[string]bool
+	if len(opts.Partitions) > 0 {
+		inPartitions = make(map[string]bool, len(opts.Partitions))
+		for _, p := range opts.Partitions {
+			inPartitions[p] = true
+		}
+	}
+
+	s.mu.RLock()
+	matches := make([]VectorMatch, 0, len(s.vectors))
+	for id, vector := range s.vectors {
+		if opts.ExcludeID > 0 && id == opts.ExcludeID {
+			continue
+		}
+		if allowed != nil && !allowed[id] {
+			continue
+		}
+		if inPartitions != nil && !inPartitions[s.partitions[id]] {
+			continue
+		}
+		score := cosineSimilarity(features, vector)
+		if opts.MinScore != nil && score <= *opts.MinScore {
+			continue
+		}
+		matches = append(matches, VectorMatch{FeatureID: id, Score: score})
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].FeatureID < matches[j].FeatureID
+	})
+
+	if opts.Offset >= len(matches) {
+		return nil, nil
+	}
+	matches = matches[opts.Offset:]
+	if opts.Limit > 0 && len(matches) > opts.Limit {
+		matches = matches[:opts.Limit]
+	}
+	return matches, nil
+}
+
+// DeleteFeatures deletes features for a wallpaper
+func (s *MemoryVectorStore) DeleteFeatures(featureID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vector, ok := s.vectors[int64(featureID)]
+	if !ok {
+		return nil
+	}
+	partition := s.partitions[int64(featureID)]
+	delete(s.vectors, int64(featureID))
+	delete(s.partitions, int64(featureID))
+
+	if err := s.save(); err != nil {
+		s.vectors[int64(featureID)] = vector
+		s.setPartition(int64(featureID), partition)
+		return fmt.Errorf("failed to delete features: %w", err)
+	}
+	return nil
+}
+
+// GetFeaturesOneWallpaper retrieves features for a wallpaper using its feature ID
+func (s *MemoryVectorStore) GetFeaturesOneWallpaper(featureID uint) ([]float32, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	vector, ok := s.vectors[int64(featureID)]
+	if !ok {
+		return nil, ErrFeatureNotFound
+	}
+	return append([]float32(nil), vector...), nil
+}
+
+// ListFeatureIDs returns the IDs of all stored vectors in ascending order
+func (s *MemoryVectorStore) ListFeatureIDs() ([]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]int64, 0, len(s.vectors))
+	for id := range s.vectors {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// ScanFeatures calls fn for every stored vector in ascending ID order. fn
+// receives a copy, so it may keep the slice.
+func (s *MemoryVectorStore) ScanFeatures(fn func(featureID int64, features []float32) error) error {
+	ids, err := s.ListFeatureIDs()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		features, err := s.GetFeaturesOneWallpaper(uint(id))
+		if errors.Is(err, ErrFeatureNotFound) {
+			// Deleted since the IDs were listed
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(id, features); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes the store to disk if it is file-backed
+func (s *MemoryVectorStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+// setPartition records the partition of a vector; callers must hold the write
+// lock
+func (s *MemoryVectorStore) setPartition(id int64, partition string) {
+	if partition != "" {
+		s.partitions[id] = partition
+	}
+}
+
+// load reads a previously saved snapshot, if any
+func (s *MemoryVectorStore) load() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snapshot memoryVectorSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+	if snapshot.Vectors != nil {
+		s.vectors = snapshot.Vectors
+	}
+	if snapshot.Partitions != nil {
+		s.partitions = snapshot.Partitions
+	}
+	if snapshot.NextID > s.nextID {
+		s.nextID = snapshot.NextID
+	}
+	return nil
+}
+
+// save writes a snapshot atomically; callers must hold the write lock
+func (s *MemoryVectorStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(memoryVectorSnapshot{NextID: s.nextID, Vectors: s.vectors, Partitions: s.partitions})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// cosineSimilarity returns the cosine similarity of two vectors, or 0 if
+// either is empty or their lengths differ
+func cosineSimilarity(a, b []float32) float32 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(normA) * math.Sqrt(normB)))
+}
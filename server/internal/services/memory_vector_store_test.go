@@ -0,0 +1,149 @@
+package services
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func float32Ptr(v float32) *float32 {
+	return &v
+}
+
+// newTestVectorStore stores vectors 1-4 at decreasing similarity to
+// testQuery, 1 and 2 in partition "a" and the rest in the default one
+func newTestVectorStore(t *testing.T, path string) *MemoryVectorStore {
+	t.Helper()
+	store, err := NewMemoryVectorStore(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.StoreFeaturesBatch([][]float32{{1, 0}, {0.8, 0.6}}, "a"); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range [][]float32{{0, 1}, {-1, 0}} {
+		if _, err := store.StoreFeatures(v, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+var testQuery = []float32{2, 0}
+
+func TestMemoryVectorStoreSearch(t *testing.T) {
+	store := newTestVectorStore(t, "")
+	tests := []struct {
+		name       string
+		opts       SearchOptions
+		wantIDs    []int64
+		wantScores []float32
+	}{
+		{name: "all, best first", wantIDs: []int64{1, 2, 3, 4}, wantScores: []float32{1, 0.8, 0, -1}},
+		{name: "limit", opts: SearchOptions{Limit: 2}, wantIDs: []int64{1, 2}},
+		{name: "offset", opts: SearchOptions{Limit: 2, Offset: 1}, wantIDs: []int64{2, 3}},
+		{name: "offset past the end", opts: SearchOptions{Offset: 4}},
+		{name: "exclude", opts: SearchOptions{ExcludeID: 1}, wantIDs: []int64{2, 3, 4}},
+		{name: "feature IDs", opts: SearchOptions{FeatureIDs: []int64{4, 2}}, wantIDs: []int64{2, 4}},
+		{name: "empty feature IDs match nothing", opts: SearchOptions{FeatureIDs: []int64{}}},
+		{name: "partition", opts: SearchOptions{Partitions: []string{"a"}}, wantIDs: []int64{1, 2}},
+		{name: "default partition", opts: SearchOptions{Partitions: []string{""}}, wantIDs: []int64{3, 4}},
+		{name: "min score", opts: SearchOptions{MinScore: float32Ptr(0.5)}, wantIDs: []int64{1, 2}},
+		{name: "min score is exclusive", opts: SearchOptions{MinScore: float32Ptr(0.8)}, wantIDs: []int64{1}},
+		{name: "min score below all", opts: SearchOptions{MinScore: float32Ptr(-2)}, wantIDs: []int64{1, 2, 3, 4}},
+		{name: "min score applies before offset", opts: SearchOptions{MinScore: float32Ptr(-0.5), Offset: 1, Limit: 5}, wantIDs: []int64{2, 3}},
+		{name: "min score with partition", opts: SearchOptions{MinScore: float32Ptr(-0.5), Partitions: []string{""}}, wantIDs: []int64{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := store.Search(testQuery, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int64
+			for _, m := range matches {
+				ids = append(ids, m.FeatureID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Fatalf("Search() = %v, want %v", ids, tt.wantIDs)
+			}
+			for i, want := range tt.wantScores {
+				if got := matches[i].Score; got < want-1e-6 || got > want+1e-6 {
+					t.Errorf("score of %d = %v, want %v", matches[i].FeatureID, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryVectorStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vectors.json")
+	store := newTestVectorStore(t, path)
+	if err := store.DeleteFeatures(3); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewMemoryVectorStore(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		opts    SearchOptions
+		wantIDs []int64
+	}{
+		{"deleted vector stays deleted", SearchOptions{}, []int64{1, 2, 4}},
+		{"partitions survive", SearchOptions{Partitions: []string{"a"}}, []int64{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := reloaded.Search(testQuery, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int64
+			for _, m := range matches {
+				ids = append(ids, m.FeatureID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("Search() = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+
+	// IDs are not reused after a restart
+	id, err := reloaded.StoreFeatures([]float32{1, 1}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 5 {
+		t.Errorf("StoreFeatures() = %d, want 5", id)
+	}
+}
+
+func TestMemoryVectorStoreDimension(t *testing.T) {
+	store := newTestVectorStore(t, "")
+	tests := []struct {
+		name     string
+		features [][]float32
+	}{
+		{"too short", [][]float32{{1}}},
+		{"too long", [][]float32{{1, 0, 0}}},
+		{"one of a batch", [][]float32{{1, 0}, {1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if len(tt.features) == 1 {
+				if _, err := store.StoreFeatures(tt.features[0], ""); err == nil {
+					t.Error("StoreFeatures() accepted a vector of the wrong dimension")
+				}
+			}
+			if _, err := store.StoreFeaturesBatch(tt.features, ""); err == nil {
+				t.Error("StoreFeaturesBatch() accepted a vector of the wrong dimension")
+			}
+		})
+	}
+	if ids, _ := store.ListFeatureIDs(); len(ids) != 4 {
+		t.Errorf("ListFeatureIDs() = %v, want the 4 vectors stored before", ids)
+	}
+}
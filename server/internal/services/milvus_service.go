@@ -4,7 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	schema "wallpaperio/server/internal/schema/milvus"
@@ -16,12 +20,25 @@ import (
 
 var ErrFeatureNotFound = errors.New("feature not found")
 
+// maxFilterIDs is the most feature IDs a search filters on in its
+// expression. Larger sets are matched against the best MaxSearchWindow
+// results instead, keeping expressions small.
+const maxFilterIDs = 1000
+
 type MilvusService struct {
-	client client.Client
-	config *database.MilvusConfig
+	client     client.Client
+	config     *database.MilvusConfig
+	collection string
+	dimension  int
+
+	partitionsMu sync.Mutex
+	// partitions caches partitions known to exist
+	partitions map[string]bool
 }
 
-func NewMilvusService() (*MilvusService, error) {
+// NewMilvusService connects to Milvus and uses the given collection, creating
+// it with the given vector dimension if it does not exist
+func NewMilvusService(collection string, dimension int) (*MilvusService, error) {
 	cfg := database.NewMilvusConfig()
 	// Connect to Milvus
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -34,13 +51,13 @@ func NewMilvusService() (*MilvusService, error) {
 	log.Println("Connected to Milvus successfully")
 
 	// Create collection if it doesn't exist
-	hasCollection, err := milvusClient.HasCollection(context.Background(), schema.CollectionName)
+	hasCollection, err := milvusClient.HasCollection(context.Background(), collection)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check collection: %w", err)
 	}
 	if !hasCollection {
 		// Create collection
-		err = milvusClient.CreateCollection(context.Background(), schema.GetWallpaperSchema(), 2) // 2 shards
+		err = milvusClient.CreateCollection(context.Background(), schema.GetWallpaperSchema(collection, dimension), 2) // 2 shards
 		if err != nil {
 			return nil, fmt.Errorf("failed to create collection: %w", err)
 		}
@@ -50,33 +67,39 @@ func NewMilvusService() (*MilvusService, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to create index: %w", err)
 		}
-		err = milvusClient.CreateIndex(context.Background(), schema.CollectionName, "features", index, false)
+		err = milvusClient.CreateIndex(context.Background(), collection, "features", index, false)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create index: %w", err)
 		}
 	}
 
 	return &MilvusService{
-		client: milvusClient,
-		config: cfg,
+		client:     milvusClient,
+		config:     cfg,
+		collection: collection,
+		dimension:  dimension,
+		partitions: make(map[string]bool),
 	}, nil
 }
 
-// StoreFeatures stores wallpaper features in Milvus and returns the feature ID
-func (s *MilvusService) StoreFeatures(features []float32) (int64, error) {
+// StoreFeatures stores wallpaper features in a partition, creating it if
+// needed, and returns the feature ID
+func (s *MilvusService) StoreFeatures(features []float32, partition string) (int64, error) {
 	// Ensure features match the dimension
-	if len(features) != schema.Dimension {
-		return 0, fmt.Errorf("features dimension mismatch: got %d, expected %d", len(features), schema.Dimension)
+	if len(features) != s.dimension {
+		return 0, fmt.Errorf("features dimension mismatch: got %d, expected %d", len(features), s.dimension)
+	}
+	if err := s.ensurePartition(partition); err != nil {
+		return 0, err
 	}
 
 	data := []entity.Column{
-		entity.NewColumnFloatVector("features", schema.Dimension, [][]float32{features}),
+		entity.NewColumnFloatVector("features", s.dimension, [][]float32{features}),
 	}
 
 	// Insert and get the primary keys
-	primaryKeys, err := s.client.Insert(context.Background(), schema.CollectionName, "", data...)
+	primaryKeys, err := s.client.Insert(context.Background(), s.collection, partition, data...)
 	if err != nil {
-		fmt.Printf("Insert error: %v\n", err)
 		return 0, fmt.Errorf("failed to insert features: %w", err)
 	}
 
@@ -94,57 +117,157 @@ func (s *MilvusService) StoreFeatures(features []float32) (int64, error) {
 	return featureID, nil
 }
 
-// FindSimilar finds similar wallpapers based on features
-func (s *MilvusService) FindSimilar(features []float32, limit int, excludeID uint64) ([]uint64, error) {
-	searchParams, err := entity.NewIndexIvfFlatSearchParam(1024)
+// StoreFeaturesBatch stores several feature vectors in a partition with a
+// single insert and returns their feature IDs in input order
+func (s *MilvusService) StoreFeaturesBatch(features [][]float32, partition string) ([]int64, error) {
+	if len(features) == 0 {
+		return nil, nil
+	}
+	for i, f := range features {
+		if len(f) != s.dimension {
+			return nil, fmt.Errorf("features %d dimension mismatch: got %d, expected %d", i, len(f), s.dimension)
+		}
+	}
+
+	if err := s.ensurePartition(partition); err != nil {
+		return nil, err
+	}
+
+	data := []entity.Column{
+		entity.NewColumnFloatVector("features", s.dimension, features),
+	}
+
+	primaryKeys, err := s.client.Insert(context.Background(), s.collection, partition, data...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create search parameters: %w", err)
+		return nil, fmt.Errorf("failed to insert features: %w", err)
 	}
 
-	expr := ""
-	if excludeID > 0 {
-		expr = fmt.Sprintf("id != %d", excludeID)
+	ids, ok := primaryKeys.(*entity.ColumnInt64)
+	if !ok {
+		return nil, fmt.Errorf("unexpected primary key type")
 	}
+	if ids.Len() != len(features) {
+		return nil, fmt.Errorf("expected %d primary keys from insert, got %d", len(features), ids.Len())
+	}
+
+	return ids.Data(), nil
+}
 
-	similarityThreshold := float32(0.5)
+// Search returns the stored vectors most similar to features with their
+// cosine similarity, best first
+func (s *MilvusService) Search(features []float32, opts SearchOptions) ([]VectorMatch, error) {
+	var filters []string
+	if opts.ExcludeID > 0 {
+		filters = append(filters, fmt.Sprintf("id != %d", opts.ExcludeID))
+	}
+	var allowed map[int64]bool
+	if len(opts.FeatureIDs) > maxFilterIDs {
+		allowed = make(map[int64]bool, len(opts.FeatureIDs))
+		for _, id := range opts.FeatureIDs {
+			allowed[id] = true
+		}
+	} else if opts.FeatureIDs != nil {
+		if len(opts.FeatureIDs) == 0 {
+			return nil, nil
+		}
+		ids := make([]string, len(opts.FeatureIDs))
+		for i, id := range opts.FeatureIDs {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		filters = append(filters, fmt.Sprintf("id in [%s]", strings.Join(ids, ",")))
+	}
+
+	var partitions []string
+	if len(opts.Partitions) > 0 {
+		var err error
+		partitions, err = s.existingPartitions(opts.Partitions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search features: %w", err)
+		}
+		if len(partitions) == 0 {
+			return nil, nil
+		}
+	}
+
+	expr := strings.Join(filters, " && ")
+	if allowed == nil {
+		matches, err := s.search(features, opts.Limit, opts.Offset, opts.MinScore, partitions, expr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search features: %w", err)
+		}
+		return matches, nil
+	}
+
+	matches, err := s.search(features, MaxSearchWindow, 0, opts.MinScore, partitions, expr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search features: %w", err)
+	}
+	var filtered []VectorMatch
+	for _, m := range matches {
+		if allowed[m.FeatureID] {
+			filtered = append(filtered, m)
+		}
+	}
+	if opts.Offset >= len(filtered) {
+		return nil, nil
+	}
+	filtered = filtered[opts.Offset:]
+	if opts.Limit > 0 && len(filtered) > opts.Limit {
+		filtered = filtered[:opts.Limit]
+	}
+	return filtered, nil
+}
+
+// search runs a cosine similarity search over the given partitions (all when
+// empty) restricted by a boolean expression. With minScore set it is a range
+// search returning only vectors scoring above it.
+func (s *MilvusService) search(features []float32, limit, offset int, minScore *float32, partitions []string, expr string) ([]VectorMatch, error) {
+	searchParams, err := entity.NewIndexIvfFlatSearchParam(1024)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create search parameters: %w", err)
+	}
+	if minScore != nil {
+		searchParams.AddRadius(float64(*minScore))
+	}
 
 	results, err := s.client.Search(
 		context.Background(),
-		schema.CollectionName, // Collection name
-		[]string{},            // Partition list (empty for all partitions)
-		expr,                  // Boolean expression to exclude current ID
-		[]string{"id"},        // Output fields
+		s.collection,   // Collection name
+		partitions,     // Partition list (empty for all partitions)
+		expr,           // Boolean expression to filter results
+		[]string{"id"}, // Output fields
 		[]entity.Vector{entity.FloatVector(features)}, // Query vectors
-		"features",           // Vector field name
-		entity.COSINE,        // Use cosine similarity
-		limit,                // TopK
-		searchParams,         // Search parameters
-		client.WithOffset(0), // Set offset to 0
+		"features",    // Vector field name
+		entity.COSINE, // Use cosine similarity
+		limit,         // TopK
+		searchParams,  // Search parameters
+		client.WithOffset(int64(offset)),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to search similar wallpapers: %w", err)
+		return nil, err
 	}
 
-	var wallpaperIDs []uint64
+	var matches []VectorMatch
 	if len(results) > 0 {
 		result := results[0]
-		resultIDs := result.IDs.(*entity.ColumnInt64).Data()
-		scores := result.Scores
+		resultIDs, ok := result.IDs.(*entity.ColumnInt64)
+		if !ok {
+			return nil, fmt.Errorf("unexpected primary key type")
+		}
 
-		for i, id := range resultIDs {
-			if scores[i] > similarityThreshold {
-				wallpaperIDs = append(wallpaperIDs, uint64(id))
-			}
+		for i, id := range resultIDs.Data() {
+			matches = append(matches, VectorMatch{FeatureID: id, Score: result.Scores[i]})
 		}
 	}
 
-	return wallpaperIDs, nil
+	return matches, nil
 }
 
 // DeleteFeatures deletes features for a wallpaper
-func (s *MilvusService) DeleteFeatures(wallpaperID uint) error {
-	expr := fmt.Sprintf("id == %d", wallpaperID)
-	err := s.client.Delete(context.Background(), schema.CollectionName, "_default", expr)
+func (s *MilvusService) DeleteFeatures(featureID uint) error {
+	expr := fmt.Sprintf("id == %d", featureID)
+	// An empty partition name deletes from every partition
+	err := s.client.Delete(context.Background(), s.collection, "", expr)
 	if err != nil {
 		return fmt.Errorf("failed to delete features: %w", err)
 	}
@@ -188,7 +311,7 @@ func (s *MilvusService) getFeatures(ctx context.Context, featureID uint) ([]floa
 	expr := fmt.Sprintf("id == %d", featureID)
 	outputFields := []string{"features"}
 
-	results, err := s.client.Query(ctx, schema.CollectionName, []string{}, expr, outputFields)
+	results, err := s.client.Query(ctx, s.collection, []string{}, expr, outputFields)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
@@ -210,6 +333,136 @@ func (s *MilvusService) getFeatures(ctx context.Context, featureID uint) ([]floa
 	return data[0], nil
 }
 
+// ListFeatureIDs returns the IDs of all vectors in the collection
+func (s *MilvusService) ListFeatureIDs() ([]int64, error) {
+	var ids []int64
+	err := s.iterate(10000, []string{"id"}, func(results client.ResultSet) error {
+		idCol, ok := results.GetColumn("id").(*entity.ColumnInt64)
+		if !ok {
+			return fmt.Errorf("invalid result columns")
+		}
+		ids = append(ids, idCol.Data()...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list features: %w", err)
+	}
+	return ids, nil
+}
+
+// iterate queries the whole collection in batches with the SDK's query
+// iterator, which pages by primary key in order
+func (s *MilvusService) iterate(batchSize int, outputFields []string, fn func(client.ResultSet) error) error {
+	ctx := context.Background()
+	it, err := s.client.QueryIterator(ctx, client.NewQueryIteratorOption(s.collection).
+		WithOutputFields(outputFields...).
+		WithBatchSize(batchSize))
+	if err != nil {
+		return err
+	}
+	for {
+		results, err := it.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(results); err != nil {
+			return err
+		}
+	}
+}
+
+// ensurePartition creates a partition on first use. The empty name is the
+// default partition, which always exists.
+func (s *MilvusService) ensurePartition(partition string) error {
+	if partition == "" {
+		return nil
+	}
+
+	s.partitionsMu.Lock()
+	defer s.partitionsMu.Unlock()
+	if s.partitions[partition] {
+		return nil
+	}
+
+	ctx := context.Background()
+	has, err := s.client.HasPartition(ctx, s.collection, partition)
+	if err != nil {
+		return fmt.Errorf("failed to check partition %s: %w", partition, err)
+	}
+	if !has {
+		if err := s.client.CreatePartition(ctx, s.collection, partition); err != nil {
+			return fmt.Errorf("failed to create partition %s: %w", partition, err)
+		}
+		log.Printf("Created Milvus partition %s in %s", partition, s.collection)
+	}
+
+	s.partitions[partition] = true
+	return nil
+}
+
+// existingPartitions returns the partitions that exist, since searching a
+// missing partition is an error in Milvus
+func (s *MilvusService) existingPartitions(partitions []string) ([]string, error) {
+	s.partitionsMu.Lock()
+	defer s.partitionsMu.Unlock()
+
+	var existing []string
+	for _, partition := range partitions {
+		if partition == "" {
+			existing = append(existing, "_default")
+			continue
+		}
+		if !s.partitions[partition] {
+			has, err := s.client.HasPartition(context.Background(), s.collection, partition)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check partition %s: %w", partition, err)
+			}
+			if !has {
+				continue
+			}
+			s.partitions[partition] = true
+		}
+		existing = append(existing, partition)
+	}
+	return existing, nil
+}
+
+// ScanFeatures calls fn for every vector in the collection. Errors from fn
+// are returned as they are.
+func (s *MilvusService) ScanFeatures(fn func(featureID int64, features []float32) error) error {
+	var fnErr error
+	// Vectors are large, so batches are much smaller than in ListFeatureIDs
+	err := s.iterate(1000, []string{"id", "features"}, func(results client.ResultSet) error {
+		idCol, ok := results.GetColumn("id").(*entity.ColumnInt64)
+		if !ok {
+			return fmt.Errorf("invalid result columns")
+		}
+		featuresCol, ok := results.GetColumn("features").(*entity.ColumnFloatVector)
+		if !ok || featuresCol.Len() != idCol.Len() {
+			return fmt.Errorf("invalid result columns")
+		}
+
+		ids := idCol.Data()
+		vectors := featuresCol.Data()
+		for i := range ids {
+			if fnErr = fn(ids[i], vectors[i]); fnErr != nil {
+				return fnErr
+			}
+		}
+		return nil
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to scan features: %w", err)
+	}
+	return nil
+}
+
 // Close closes the Milvus connection
 func (s *MilvusService) Close() error {
 	return s.client.Close()
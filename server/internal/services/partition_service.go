@@ -0,0 +1,118 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/domain/models/dto"
+	schema "wallpaperio/server/internal/schema/milvus"
+
+	"gorm.io/gorm"
+)
+
+const partitionMigrationPageSize = 100
+
+// PartitionService moves feature vectors into the partition of their
+// wallpaper's category
+type PartitionService struct {
+	db            *gorm.DB
+	featureModels *FeatureModelService
+}
+
+func NewPartitionService(db *gorm.DB, featureModels *FeatureModelService) *PartitionService {
+	return &PartitionService{
+		db:            db,
+		featureModels: featureModels,
+	}
+}
+
+// Migrate moves every vector of the active feature model that is not in its
+// category's partition, including vectors stored before partitioning and
+// those of wallpapers that changed category. Vectors cannot change partition
+// in place, so each is re-inserted under a new feature ID and the old one is
+// deleted once the wallpaper points at the new one.
+func (s *PartitionService) Migrate() (*dto.PartitionMigrationReport, error) {
+	report := &dto.PartitionMigrationReport{}
+	lastID := uint(0)
+	for {
+		page, err := s.migratePage(lastID, report)
+		if err != nil {
+			return report, err
+		}
+		if len(page) == 0 {
+			return report, nil
+		}
+		lastID = page[len(page)-1].ID
+	}
+}
+
+// migratePage moves the vectors of the next page of misplaced wallpapers.
+// The feature model is only held for one page at a time so a re-embedding
+// can switch over between pages.
+func (s *PartitionService) migratePage(afterID uint, report *dto.PartitionMigrationReport) ([]models.Wallpaper, error) {
+	model, store, release := s.featureModels.Acquire()
+	defer release()
+
+	var page []models.Wallpaper
+	err := s.db.Select("id", "category_id", "feature_id").
+		Where("id > ?", afterID).
+		Where("feature_model = ? AND feature_id <> 0", model.Key()).
+		Where("COALESCE(feature_partition, '') <> CONCAT('category_', category_id)").
+		Order("id").
+		Limit(partitionMigrationPageSize).
+		Find(&page).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list wallpapers: %w", err)
+	}
+
+	for _, w := range page {
+		report.Scanned++
+		if err := s.move(w, store); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("wallpaper %d: %v", w.ID, err))
+			continue
+		}
+		report.Moved++
+	}
+	return page, nil
+}
+
+func (s *PartitionService) move(w models.Wallpaper, store VectorStore) error {
+	features, err := store.GetFeaturesOneWallpaper(uint(w.FeatureID))
+	if errors.Is(err, ErrFeatureNotFound) {
+		return fmt.Errorf("features %d are missing, run reconcile -repair", w.FeatureID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get features: %w", err)
+	}
+
+	partition := schema.PartitionNameFor(w.CategoryID)
+	featureID, err := store.StoreFeatures(features, partition)
+	if err != nil {
+		return fmt.Errorf("failed to store features: %w", err)
+	}
+
+	// Only switch over if the wallpaper still points at the old vector
+	result := s.db.Model(&models.Wallpaper{}).
+		Where("id = ? AND feature_id = ?", w.ID, w.FeatureID).
+		Updates(map[string]interface{}{
+			"feature_id":        featureID,
+			"feature_partition": partition,
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		if err := store.DeleteFeatures(uint(featureID)); err != nil {
+			log.Printf("Failed to delete orphaned features %d: %v", featureID, err)
+		}
+		if result.Error != nil {
+			return fmt.Errorf("failed to update wallpaper: %w", result.Error)
+		}
+		return fmt.Errorf("wallpaper changed during migration")
+	}
+
+	if err := store.DeleteFeatures(uint(w.FeatureID)); err != nil {
+		// The old vector is now an orphan; the reconciler removes it
+		log.Printf("Failed to delete migrated features %d: %v", w.FeatureID, err)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"wallpaperio/server/internal/config"
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/domain/models/dto"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// QuotaExceededError is returned when a user has used up a generation quota
+type QuotaExceededError struct {
+	Period string
+	Quota  *dto.GenerationQuota
+	// RetryAfter is how long until the exhausted period resets
+	RetryAfter time.Duration
+}
+
+func (e *QuotaExceededError) Error() string {
+	if e.Period == models.QuotaPeriodDay {
+		return "daily generation quota exceeded"
+	}
+	return "monthly generation quota exceeded"
+}
+
+// QuotaService counts the images each user generates per UTC day and month
+// against the quota of their role
+type QuotaService struct {
+	db     *gorm.DB
+	quotas map[string]config.GenerationQuota
+}
+
+func NewQuotaService(db *gorm.DB, quotas map[string]config.GenerationQuota) *QuotaService {
+	return &QuotaService{
+		db:     db,
+		quotas: quotas,
+	}
+}
+
+// limits returns the role's quota. Admins are unlimited and roles without a
+// quota of their own get the user quota.
+func (s *QuotaService) limits(role string) config.GenerationQuota {
+	if models.UserRole(role).IsAdmin() {
+		return config.GenerationQuota{}
+	}
+	if quota, ok := s.quotas[role]; ok {
+		return quota
+	}
+	return s.quotas[models.RoleUser.String()]
+}
+
+// periodStarts returns the start of the UTC day and month containing t
+func periodStarts(t time.Time) (day, month time.Time) {
+	t = t.UTC()
+	day = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	month = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return day, month
+}
+
+// Usage returns the user's usage in the current day and month
+func (s *QuotaService) Usage(userID uint, role string) (*dto.GenerationQuota, error) {
+	return s.usageAt(userID, role, time.Now())
+}
+
+// usageAt returns the user's usage in the day and month containing now
+func (s *QuotaService) usageAt(userID uint, role string, now time.Time) (*dto.GenerationQuota, error) {
+	day, month := periodStarts(now)
+
+	var usages []models.GenerationUsage
+	err := s.db.Where("user_id = ?", userID).
+		Where("(period = ? AND period_start = ?) OR (period = ? AND period_start = ?)",
+			models.QuotaPeriodDay, day, models.QuotaPeriodMonth, month).
+		Find(&usages).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch generation usage: %w", err)
+	}
+
+	var used [2]int
+	for _, u := range usages {
+		if u.Period == models.QuotaPeriodDay {
+			used[0] = u.Count
+		} else {
+			used[1] = u.Count
+		}
+	}
+	return s.quota(role, now, used[0], used[1]), nil
+}
+
+func (s *QuotaService) quota(role string, now time.Time, daily, monthly int) *dto.GenerationQuota {
+	limits := s.limits(role)
+	day, month := periodStarts(now)
+	return &dto.GenerationQuota{
+		Role:    role,
+		Daily:   quotaPeriod(limits.Daily, daily, day.AddDate(0, 0, 1)),
+		Monthly: quotaPeriod(limits.Monthly, monthly, month.AddDate(0, 1, 0)),
+	}
+}
+
+func quotaPeriod(limit, used int, resetsAt time.Time) dto.QuotaPeriod {
+	period := dto.QuotaPeriod{Used: used, ResetsAt: resetsAt}
+	if limit > 0 {
+		remaining := max(0, limit-used)
+		period.Limit = &limit
+		period.Remaining = &remaining
+	}
+	return period
+}
+
+// Reserve counts a generation made at now against the user's quotas and
+// returns the usage including it. If a quota is used up nothing is counted
+// and a *QuotaExceededError is returned.
+func (s *QuotaService) Reserve(userID uint, role string, now time.Time) (*dto.GenerationQuota, error) {
+	day, month := periodStarts(now)
+	limits := s.limits(role)
+
+	var daily, monthly int
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if daily, err = s.increment(tx, userID, models.QuotaPeriodDay, day, limits.Daily); err != nil {
+			return err
+		}
+		monthly, err = s.increment(tx, userID, models.QuotaPeriodMonth, month, limits.Monthly)
+		return err
+	})
+	var exceeded *QuotaExceededError
+	if errors.As(err, &exceeded) {
+		usage, usageErr := s.usageAt(userID, role, now)
+		if usageErr != nil {
+			return nil, usageErr
+		}
+		exceeded.Quota = usage
+		exceeded.Period, exceeded.RetryAfter = retryAfter(exceeded.Period, usage, now)
+		return nil, exceeded
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.quota(role, now, daily, monthly), nil
+}
+
+// retryAfter returns the period the user waits for after exceeding the
+// given one, and how long from now until it resets. The daily quota is
+// checked first, but if the monthly one is used up too the user has to
+// wait for the month to reset.
+func retryAfter(period string, usage *dto.GenerationQuota, now time.Time) (string, time.Duration) {
+	if period == models.QuotaPeriodDay && !exhausted(usage.Monthly) {
+		return models.QuotaPeriodDay, usage.Daily.ResetsAt.Sub(now)
+	}
+	return models.QuotaPeriodMonth, usage.Monthly.ResetsAt.Sub(now)
+}
+
+// exhausted reports whether a limited period has no generations left
+func exhausted(period dto.QuotaPeriod) bool {
+	return period.Remaining != nil && *period.Remaining == 0
+}
+
+// increment adds one to the user's counter for the period unless it has
+// reached the limit, and returns the new count
+func (s *QuotaService) increment(tx *gorm.DB, userID uint, period string, start time.Time, limit int) (int, error) {
+	onConflict := clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "period"}, {Name: "period_start"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"count":      gorm.Expr("generation_usages.count + 1"),
+			"updated_at": time.Now(),
+		}),
+	}
+	if limit > 0 {
+		onConflict.Where = clause.Where{Exprs: []clause.Expression{gorm.Expr("generation_usages.count < ?", limit)}}
+	}
+
+	usage := models.GenerationUsage{UserID: userID, Period: period, PeriodStart: start, Count: 1}
+	result := tx.Clauses(onConflict, clause.Returning{Columns: []clause.Column{{Name: "count"}}}).Create(&usage)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to count generation: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return 0, &QuotaExceededError{Period: period}
+	}
+	return usage.Count, nil
+}
+
+// Release takes back a generation reserved at the given time that did not
+// start
+func (s *QuotaService) Release(userID uint, reservedAt time.Time) error {
+	day, month := periodStarts(reservedAt)
+	err := s.db.Model(&models.GenerationUsage{}).
+		Where("user_id = ? AND count > 0", userID).
+		Where("(period = ? AND period_start = ?) OR (period = ? AND period_start = ?)",
+			models.QuotaPeriodDay, day, models.QuotaPeriodMonth, month).
+		UpdateColumn("count", gorm.Expr("count - 1")).Error
+	if err != nil {
+		return fmt.Errorf("failed to release generation: %w", err)
+	}
+	return nil
+}
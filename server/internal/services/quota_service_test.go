@@ -0,0 +1,122 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"wallpaperio/server/internal/config"
+	"wallpaperio/server/internal/domain/models"
+)
+
+func TestQuotaRetryAfter(t *testing.T) {
+	svc := NewQuotaService(nil, map[string]config.GenerationQuota{
+		"user":    {Daily: 5, Monthly: 100},
+		"premium": {Daily: 50, Monthly: 0},
+	})
+	tests := []struct {
+		name           string
+		role           string
+		now            time.Time
+		exceeded       string
+		daily, monthly int
+		wantPeriod     string
+		wantRetryAfter time.Duration
+	}{
+		{
+			name:     "daily quota resets at midnight UTC",
+			role:     "user",
+			now:      time.Date(2026, 3, 10, 18, 30, 0, 0, time.UTC),
+			exceeded: models.QuotaPeriodDay, daily: 5, monthly: 40,
+			wantPeriod: models.QuotaPeriodDay, wantRetryAfter: 5*time.Hour + 30*time.Minute,
+		},
+		{
+			name:     "daily quota in another time zone",
+			role:     "user",
+			now:      time.Date(2026, 3, 10, 23, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60)),
+			exceeded: models.QuotaPeriodDay, daily: 5, monthly: 40,
+			wantPeriod: models.QuotaPeriodDay, wantRetryAfter: 3 * time.Hour,
+		},
+		{
+			name:     "monthly quota resets on the first",
+			role:     "user",
+			now:      time.Date(2026, 3, 30, 12, 0, 0, 0, time.UTC),
+			exceeded: models.QuotaPeriodMonth, daily: 2, monthly: 100,
+			wantPeriod: models.QuotaPeriodMonth, wantRetryAfter: 36 * time.Hour,
+		},
+		{
+			name:     "both used up waits for the month",
+			role:     "user",
+			now:      time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC),
+			exceeded: models.QuotaPeriodDay, daily: 5, monthly: 100,
+			wantPeriod: models.QuotaPeriodMonth, wantRetryAfter: 21*24*time.Hour + 12*time.Hour,
+		},
+		{
+			name:     "last day of the month",
+			role:     "user",
+			now:      time.Date(2026, 2, 28, 23, 0, 0, 0, time.UTC),
+			exceeded: models.QuotaPeriodDay, daily: 5, monthly: 100,
+			wantPeriod: models.QuotaPeriodMonth, wantRetryAfter: time.Hour,
+		},
+		{
+			name:     "unlimited monthly quota",
+			role:     "premium",
+			now:      time.Date(2026, 3, 10, 20, 0, 0, 0, time.UTC),
+			exceeded: models.QuotaPeriodDay, daily: 50, monthly: 900,
+			wantPeriod: models.QuotaPeriodDay, wantRetryAfter: 4 * time.Hour,
+		},
+		{
+			name:     "role without a quota gets the user quota",
+			role:     "guest",
+			now:      time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC),
+			exceeded: models.QuotaPeriodDay, daily: 5, monthly: 100,
+			wantPeriod: models.QuotaPeriodMonth, wantRetryAfter: 21*24*time.Hour + 12*time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage := svc.quota(tt.role, tt.now, tt.daily, tt.monthly)
+			period, after := retryAfter(tt.exceeded, usage, tt.now)
+			if period != tt.wantPeriod || after != tt.wantRetryAfter {
+				t.Errorf("retryAfter() = %s, %v; want %s, %v", period, after, tt.wantPeriod, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestQuotaRemaining(t *testing.T) {
+	svc := NewQuotaService(nil, map[string]config.GenerationQuota{
+		"user": {Daily: 5, Monthly: 0},
+	})
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		role          string
+		daily         int
+		wantRemaining *int
+		wantExhausted bool
+	}{
+		{name: "some left", role: "user", daily: 3, wantRemaining: intPtr(2)},
+		{name: "used up", role: "user", daily: 5, wantRemaining: intPtr(0), wantExhausted: true},
+		{name: "over the limit", role: "user", daily: 7, wantRemaining: intPtr(0), wantExhausted: true},
+		{name: "admins are unlimited", role: "admin", daily: 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage := svc.quota(tt.role, now, tt.daily, 0)
+			remaining := usage.Daily.Remaining
+			if (remaining == nil) != (tt.wantRemaining == nil) || (remaining != nil && *remaining != *tt.wantRemaining) {
+				t.Errorf("remaining = %v, want %v", remaining, tt.wantRemaining)
+			}
+			if got := exhausted(usage.Daily); got != tt.wantExhausted {
+				t.Errorf("exhausted() = %v, want %v", got, tt.wantExhausted)
+			}
+			if exhausted(usage.Monthly) {
+				t.Error("an unlimited monthly quota is exhausted")
+			}
+		})
+	}
+}
+
+func intPtr(v int) *int {
+	return &v
+}
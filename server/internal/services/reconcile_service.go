@@ -0,0 +1,182 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/domain/models/dto"
+	schema "wallpaperio/server/internal/schema/milvus"
+
+	"gorm.io/gorm"
+)
+
+// ReconcileService finds and repairs inconsistencies between wallpaper rows
+// in Postgres and feature vectors in the vector store
+type ReconcileService struct {
+	db            *gorm.DB
+	featureSvc    *FeatureService
+	featureModels *FeatureModelService
+}
+
+func NewReconcileService(db *gorm.DB, featureSvc *FeatureService, featureModels *FeatureModelService) *ReconcileService {
+	return &ReconcileService{
+		db:            db,
+		featureSvc:    featureSvc,
+		featureModels: featureModels,
+	}
+}
+
+// Reconcile scans Postgres and the active model's vector store and reports vectors no wallpaper references
+// (orphans) and wallpapers whose feature ID has no vector (dangling). With
+// repair set, orphan vectors are deleted and dangling wallpapers get their
+// features re-extracted and stored.
+func (s *ReconcileService) Reconcile(repair bool) (*dto.ReconcileReport, error) {
+	// Scan Postgres first. Ingestion stores a wallpaper's vector before
+	// committing its row, so every scanned row's vector is in the store by
+	// the time the store is scanned and a wallpaper created meanwhile is not
+	// reported as dangling. Its vector may show up as an orphan instead,
+	// which repair re-checks before deleting.
+	var wallpapers []models.Wallpaper
+	if err := s.db.Select("id", "feature_id", "image_url").Order("id").Find(&wallpapers).Error; err != nil {
+		return nil, fmt.Errorf("failed to list wallpapers: %w", err)
+	}
+
+	featureIDs, err := s.featureModels.Store().ListFeatureIDs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list features: %w", err)
+	}
+
+	stored := make(map[int64]bool, len(featureIDs))
+	for _, id := range featureIDs {
+		stored[id] = true
+	}
+	referenced := make(map[int64]bool, len(wallpapers))
+	for _, w := range wallpapers {
+		referenced[w.FeatureID] = true
+	}
+
+	report := &dto.ReconcileReport{
+		WallpapersScanned:  len(wallpapers),
+		FeaturesScanned:    len(featureIDs),
+		OrphanFeatureIDs:   []int64{},
+		DanglingWallpapers: []dto.DanglingWallpaper{},
+	}
+	for _, id := range featureIDs {
+		if !referenced[id] {
+			report.OrphanFeatureIDs = append(report.OrphanFeatureIDs, id)
+		}
+	}
+	for _, w := range wallpapers {
+		if w.FeatureID == 0 || !stored[w.FeatureID] {
+			report.DanglingWallpapers = append(report.DanglingWallpapers, dto.DanglingWallpaper{
+				ID:        w.ID,
+				FeatureID: w.FeatureID,
+				ImageURL:  w.ImageURL,
+			})
+		}
+	}
+
+	if repair {
+		s.repair(report)
+	}
+	return report, nil
+}
+
+func (s *ReconcileService) repair(report *dto.ReconcileReport) {
+	report.Repaired = true
+
+	// Re-check orphans against Postgres right before deleting, in case a
+	// wallpaper referencing them was committed after the scan
+	if len(report.OrphanFeatureIDs) > 0 {
+		var stillReferenced []int64
+		if err := s.db.Model(&models.Wallpaper{}).Where("feature_id IN ?", report.OrphanFeatureIDs).
+			Pluck("feature_id", &stillReferenced).Error; err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("failed to re-check orphans: %v", err))
+			return
+		}
+		skip := make(map[int64]bool, len(stillReferenced))
+		for _, id := range stillReferenced {
+			skip[id] = true
+		}
+
+		for _, id := range report.OrphanFeatureIDs {
+			if skip[id] {
+				continue
+			}
+			if err := s.featureModels.Store().DeleteFeatures(uint(id)); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("feature %d: %v", id, err))
+				continue
+			}
+			report.DeletedFeatures++
+		}
+	}
+
+	for _, w := range report.DanglingWallpapers {
+		reembedded, err := s.reembed(w)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("wallpaper %d: %v", w.ID, err))
+			continue
+		}
+		if reembedded {
+			report.ReembeddedIDs = append(report.ReembeddedIDs, w.ID)
+		}
+	}
+}
+
+// reembed extracts and stores features for a dangling wallpaper and points
+// it at them. It first re-checks that the wallpaper still exists and still
+// lacks its vector, and only updates it if its feature ID is unchanged, so a
+// wallpaper deleted or re-embedded since the scan is left alone. It reports
+// whether the wallpaper was re-embedded.
+func (s *ReconcileService) reembed(w dto.DanglingWallpaper) (bool, error) {
+	model, vectors, release := s.featureModels.Acquire()
+	defer release()
+
+	var wallpaper models.Wallpaper
+	err := s.db.Select("id", "category_id", "feature_id").First(&wallpaper, w.ID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch wallpaper: %w", err)
+	}
+	if wallpaper.FeatureID != w.FeatureID {
+		return false, nil
+	}
+	if wallpaper.FeatureID != 0 {
+		_, err := vectors.GetFeaturesOneWallpaper(uint(wallpaper.FeatureID))
+		if err == nil {
+			return false, nil
+		}
+		if !errors.Is(err, ErrFeatureNotFound) {
+			return false, fmt.Errorf("failed to check features: %w", err)
+		}
+	}
+
+	features, err := s.featureSvc.ExtractFeatures(w.ImageURL, model.Name)
+	if err != nil {
+		return false, fmt.Errorf("failed to extract features: %w", err)
+	}
+	partition := schema.PartitionNameFor(wallpaper.CategoryID)
+
+	featureID, err := vectors.StoreFeatures(features, partition)
+	if err != nil {
+		return false, fmt.Errorf("failed to store features: %w", err)
+	}
+
+	result := s.db.Model(&models.Wallpaper{}).
+		Where("id = ? AND feature_id = ?", w.ID, w.FeatureID).
+		Updates(map[string]interface{}{
+			"feature_id":        featureID,
+			"feature_model":     model.Key(),
+			"feature_partition": partition,
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		vectors.DeleteFeatures(uint(featureID))
+	}
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to update wallpaper: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// saliencySampleSize is the longest side of the copy saliency is computed on
+const saliencySampleSize = 96
+
+// saliencyCentre estimates where the subject of the image is, as fractions
+// of its width and height. Each pixel's saliency is its colour contrast to
+// the image's mean colour plus its local edge strength; the centre is the
+// saliency-weighted mean position, pulled slightly towards the middle.
+func saliencyCentre(img image.Image) (float64, float64) {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return 0.5, 0.5
+	}
+	if w >= h && w > saliencySampleSize {
+		w, h = saliencySampleSize, max(1, h*saliencySampleSize/w)
+	} else if h > w && h > saliencySampleSize {
+		w, h = max(1, w*saliencySampleSize/h), saliencySampleSize
+	}
+	small := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, bounds, draw.Src, nil)
+
+	lab := make([]Lab, w*h)
+	var mean Lab
+	for i := range lab {
+		p := small.Pix[i*4 : i*4+3]
+		lab[i] = rgbToLab(p[0], p[1], p[2])
+		mean.L += lab[i].L
+		mean.A += lab[i].A
+		mean.B += lab[i].B
+	}
+	n := float64(len(lab))
+	mean = Lab{mean.L / n, mean.A / n, mean.B / n}
+
+	var sumX, sumY, total float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := lab[y*w+x]
+			s := c.DeltaE(mean)
+			if x > 0 && x < w-1 && y > 0 && y < h-1 {
+				gx := lab[y*w+x+1].L - lab[y*w+x-1].L
+				gy := lab[(y+1)*w+x].L - lab[(y-1)*w+x].L
+				s += math.Sqrt(gx*gx + gy*gy)
+			}
+			s *= s
+			sumX += s * (float64(x) + 0.5)
+			sumY += s * (float64(y) + 0.5)
+			total += s
+		}
+	}
+	if total == 0 {
+		return 0.5, 0.5
+	}
+
+	// Blend with the middle so a single salient corner does not push the
+	// crop to the edge
+	cx := 0.8*(sumX/total/float64(w)) + 0.1
+	cy := 0.8*(sumY/total/float64(h)) + 0.1
+	return cx, cy
+}
+
+// cropToFill scales the image to cover width x height and crops the excess,
+// keeping the window as close to the image's saliency centre as possible
+func cropToFill(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	srcW, srcH := float64(bounds.Dx()), float64(bounds.Dy())
+	scale := math.Max(float64(width)/srcW, float64(height)/srcH)
+
+	// The part of the source that ends up in the output
+	cropW := math.Min(srcW, float64(width)/scale)
+	cropH := math.Min(srcH, float64(height)/scale)
+	cx, cy := saliencyCentre(img)
+	x0 := clamp(cx*srcW-cropW/2, 0, srcW-cropW)
+	y0 := clamp(cy*srcH-cropH/2, 0, srcH-cropH)
+
+	src := image.Rect(
+		bounds.Min.X+int(math.Round(x0)),
+		bounds.Min.Y+int(math.Round(y0)),
+		bounds.Min.X+int(math.Round(x0+cropW)),
+		bounds.Min.Y+int(math.Round(y0+cropH)),
+	)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst
+}
+
+// fitWithin scales the image to the largest size that fits in width x
+// height, keeping its aspect ratio
+func fitWithin(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	scale := math.Min(float64(width)/float64(bounds.Dx()), float64(height)/float64(bounds.Dy()))
+	w := max(1, int(math.Round(float64(bounds.Dx())*scale)))
+	h := max(1, int(math.Round(float64(bounds.Dy())*scale)))
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
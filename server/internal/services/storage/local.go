@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalRoute is the URL path under which the server serves local storage,
+// and any storage when links are signed
+const LocalRoute = "/media"
+
+// Local stores objects as files below a directory that the server serves
+// at LocalRoute
+type Local struct {
+	dir         string
+	publicURL   string
+	internalURL string
+}
+
+// NewLocal stores objects in dir. publicURL and internalURL are the base
+// URLs of this server for clients and for backend services.
+func NewLocal(dir, publicURL, internalURL string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &Local{
+		dir:         dir,
+		publicURL:   strings.TrimSuffix(publicURL, "/") + LocalRoute,
+		internalURL: strings.TrimSuffix(internalURL, "/") + LocalRoute,
+	}, nil
+}
+
+// Dir returns the directory holding the files
+func (s *Local) Dir() string {
+	return s.dir
+}
+
+// Put writes the object to a temporary file and renames it into place so
+// readers never see a partial file
+func (s *Local) Put(key string, r io.Reader, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
+
+func (s *Local) Get(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return f, nil
+}
+
+func (s *Local) Exists(key string) (bool, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return true, nil
+}
+
+// Stat derives the ETag from the file's size and modification time, as
+// nginx does, since files are only ever replaced whole
+func (s *Local) Stat(key string) (*ObjectInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return &ObjectInfo{
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		ETag:    fmt.Sprintf("%x-%x", fi.ModTime().UnixNano(), fi.Size()),
+	}, nil
+}
+
+func (s *Local) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+func (s *Local) URL(key string) string {
+	return s.publicURL + "/" + key
+}
+
+func (s *Local) InternalURL(key string) string {
+	return s.internalURL + "/" + key
+}
+
+func (s *Local) KeyForURL(url string) (string, bool) {
+	return keyForURL(s.publicURL, url)
+}
+
+// path maps a key to a file below the storage directory, rejecting keys
+// that would escape it
+func (s *Local) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" || key != strings.TrimPrefix(filepath.ToSlash(clean), "/") {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(clean)), nil
+}
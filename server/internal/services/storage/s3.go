@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"wallpaperio/server/internal/config"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3Timeout bounds metadata calls; transfers are not limited
+const s3Timeout = 30 * time.Second
+
+// S3 stores objects in a bucket of an S3-compatible service such as MinIO
+type S3 struct {
+	client      *minio.Client
+	bucket      string
+	publicURL   string
+	internalURL string
+}
+
+// NewS3 connects to the bucket, creating it if it does not exist. A bucket
+// created here is made publicly readable when configured, since wallpapers
+// are linked to directly.
+func NewS3(cfg *config.S3Config) (*S3, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bucket: %w", err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, fmt.Errorf("failed to create bucket: %w", err)
+		}
+		if cfg.PublicRead {
+			if err := client.SetBucketPolicy(ctx, cfg.Bucket, publicReadPolicy(cfg.Bucket)); err != nil {
+				return nil, fmt.Errorf("failed to set bucket policy: %w", err)
+			}
+		}
+	}
+
+	scheme := "http"
+	if cfg.UseSSL {
+		scheme = "https"
+	}
+	internalURL := fmt.Sprintf("%s://%s/%s", scheme, cfg.Endpoint, cfg.Bucket)
+	publicURL := internalURL
+	if cfg.PublicURL != "" {
+		publicURL = strings.TrimSuffix(cfg.PublicURL, "/")
+	}
+
+	return &S3{
+		client:      client,
+		bucket:      cfg.Bucket,
+		publicURL:   publicURL,
+		internalURL: internalURL,
+	}, nil
+}
+
+func publicReadPolicy(bucket string) string {
+	return fmt.Sprintf(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::%s/*"]}]}`, bucket)
+}
+
+func (s *S3) Put(key string, r io.Reader, contentType string) error {
+	// A known size avoids buffering the upload in memory
+	size := int64(-1)
+	if sized, ok := r.(interface{ Len() int }); ok {
+		size = int64(sized.Len())
+	}
+
+	_, err := s.client.PutObject(context.Background(), s.bucket, key, r, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload object: %w", err)
+	}
+	return nil
+}
+
+func (s *S3) Get(key string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(context.Background(), s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+	// GetObject is lazy; Stat surfaces a missing key
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if isNoSuchKey(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+	return obj, nil
+}
+
+func (s *S3) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	if err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	return nil
+}
+
+func (s *S3) Exists(key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	_, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if isNoSuchKey(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to stat object: %w", err)
+	}
+	return true, nil
+}
+
+func (s *S3) Stat(key string) (*ObjectInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if isNoSuchKey(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+	return &ObjectInfo{
+		Size:    info.Size,
+		ModTime: info.LastModified,
+		ETag:    info.ETag,
+	}, nil
+}
+
+func (s *S3) URL(key string) string {
+	return s.publicURL + "/" + key
+}
+
+func (s *S3) InternalURL(key string) string {
+	return s.internalURL + "/" + key
+}
+
+func (s *S3) KeyForURL(url string) (string, bool) {
+	return keyForURL(s.publicURL, url)
+}
+
+func isNoSuchKey(err error) bool {
+	return err != nil && minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"wallpaperio/server/internal/config"
+)
+
+const (
+	TypeLocal = "local"
+	TypeS3    = "s3"
+)
+
+// ErrNotFound is returned by Get for a key with no object
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object. ETag changes whenever the content
+// does.
+type ObjectInfo struct {
+	Size    int64
+	ModTime time.Time
+	ETag    string
+}
+
+// Storage stores image files under slash-separated keys such as
+// "wallpapers/abc.jpg"
+type Storage interface {
+	// Put stores the content read from r under key, replacing any existing
+	// object
+	Put(key string, r io.Reader, contentType string) error
+	// Get opens the object under key; the caller closes it
+	Get(key string) (io.ReadCloser, error)
+	// Delete removes the object under key; a missing object is not an error
+	Delete(key string) error
+	// Exists reports whether an object is stored under key
+	Exists(key string) (bool, error)
+	// Stat describes the object under key, or returns ErrNotFound
+	Stat(key string) (*ObjectInfo, error)
+	// URL returns the public URL of the object under key
+	URL(key string) string
+	// InternalURL returns a URL for the object that other backend services,
+	// such as the generator, can fetch
+	InternalURL(key string) string
+	// KeyForURL returns the key of an object from its public URL, or false if
+	// the URL does not point into this storage
+	KeyForURL(url string) (string, bool)
+}
+
+var (
+	_ Storage = (*Local)(nil)
+	_ Storage = (*S3)(nil)
+)
+
+// New returns the storage backend selected by the configuration.
+// publicURL and internalURL are this server's base URLs, used by the local
+// backend which serves files itself.
+func New(cfg *config.StorageConfig, publicURL, internalURL string) (Storage, error) {
+	switch cfg.Type {
+	case TypeLocal, "":
+		return NewLocal(cfg.LocalDir, publicURL, internalURL)
+	case TypeS3:
+		return NewS3(&cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage type: %s", cfg.Type)
+	}
+}
+
+// keyForURL strips base from url, returning the remaining key
+func keyForURL(base, url string) (string, bool) {
+	if !strings.HasPrefix(url, base+"/") {
+		return "", false
+	}
+	key := strings.TrimPrefix(url, base+"/")
+	return key, key != ""
+}
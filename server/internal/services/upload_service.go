@@ -0,0 +1,80 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	_ "image/png" // register the PNG decoder
+	"log"
+	"net/http"
+
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/domain/models/dto"
+	"wallpaperio/server/internal/services/storage"
+
+	_ "golang.org/x/image/webp" // register the WebP decoder
+)
+
+var (
+	ErrUnsupportedImage = errors.New("image must be JPEG, PNG or WebP")
+	ErrInvalidImage     = errors.New("image could not be decoded")
+)
+
+// uploadImageTypes maps accepted upload content types to file extensions
+var uploadImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// UploadService ingests image files sent directly to the server: it stores
+// the original, creates the wallpaper and renders its variants and palette
+type UploadService struct {
+	wallpaperSvc *WallpaperService
+	storage      storage.Storage
+}
+
+func NewUploadService(wallpaperSvc *WallpaperService, storage storage.Storage) *UploadService {
+	return &UploadService{
+		wallpaperSvc: wallpaperSvc,
+		storage:      storage,
+	}
+}
+
+// UploadWallpaper creates a wallpaper from an uploaded image. Stored files
+// are removed again if the wallpaper cannot be created.
+func (s *UploadService) UploadWallpaper(data []byte, params dto.UploadWallpaper) (*models.Wallpaper, error) {
+	contentType := http.DetectContentType(data)
+	if _, ok := uploadImageTypes[contentType]; !ok {
+		return nil, ErrUnsupportedImage
+	}
+	img, err := s.wallpaperSvc.fetcher.decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	// Identical uploads share one stored original
+	originalKey, info, err := s.wallpaperSvc.blobs.Put(data, contentType)
+	if err != nil {
+		return nil, err
+	}
+	defer s.wallpaperSvc.blobs.unpin(originalKey)
+	wallpaper, err := s.wallpaperSvc.createWallpaper(dto.CreateWallpaper{
+		ImageURL:    s.storage.URL(originalKey),
+		Category:    params.Category,
+		Tags:        params.Tags,
+		OnDuplicate: params.OnDuplicate,
+	}, s.storage.InternalURL(originalKey), info)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without its thumb the wallpaper cannot be listed, so undo it
+	if err := s.wallpaperSvc.processImage(wallpaper, img); err != nil {
+		if err := s.wallpaperSvc.DeleteWallpaper(wallpaper.ID); err != nil {
+			log.Printf("Failed to delete wallpaper %d: %v", wallpaper.ID, err)
+		}
+		return nil, err
+	}
+
+	return wallpaper, nil
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"wallpaperio/server/internal/config"
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/domain/models/dto"
+	"wallpaperio/server/internal/services/storage"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrLinkExpired      = errors.New("link has expired")
+)
+
+// URLSigner hands out expiring, HMAC-signed links to stored images and
+// verifies them. Thumbnails and category images are not gated and stay
+// public. When disabled, URLs are passed through unchanged.
+type URLSigner struct {
+	storage  storage.Storage
+	enabled  bool
+	secret   []byte
+	ttl      time.Duration
+	mediaURL string
+}
+
+// NewURLSigner signs links to this server's media route under publicURL
+func NewURLSigner(store storage.Storage, cfg *config.SignedURLConfig, publicURL string) (*URLSigner, error) {
+	if cfg.Enabled && cfg.Secret == "" {
+		return nil, errors.New("URL_SIGNING_SECRET is required when SIGNED_URLS is enabled")
+	}
+	return &URLSigner{
+		storage:  store,
+		enabled:  cfg.Enabled,
+		secret:   []byte(cfg.Secret),
+		ttl:      cfg.TTL,
+		mediaURL: strings.TrimSuffix(publicURL, "/") + storage.LocalRoute,
+	}, nil
+}
+
+func (s *URLSigner) Enabled() bool {
+	return s.enabled
+}
+
+// Gated reports whether the object under key is only served with a valid
+// signature: everything but thumbnails and category images
+func (s *URLSigner) Gated(key string) bool {
+	if !s.enabled {
+		return false
+	}
+	name := path.Base(key)
+	switch {
+	case strings.HasPrefix(key, "categories/"):
+		return false
+	case strings.HasPrefix(key, "variants/"):
+		return !strings.HasPrefix(name, "thumb_")
+	case strings.HasPrefix(key, "wallpapers/"):
+		return !strings.HasSuffix(strings.TrimSuffix(name, path.Ext(name)), "_thumb")
+	}
+	return true
+}
+
+// URL returns the link to hand clients for an image. Stored images are
+// served through this server, so the bucket of S3 storage can stay
+// private, and gated ones get a signature. Images outside storage are
+// returned as they are.
+func (s *URLSigner) URL(url string) string {
+	if !s.enabled {
+		return url
+	}
+	key, ok := s.storage.KeyForURL(url)
+	if !ok {
+		return url
+	}
+	link := s.mediaURL + "/" + key
+	if !s.Gated(key) {
+		return link
+	}
+	expires := s.Version()
+	return link + "?expires=" + expires + "&signature=" + s.signature(key, expires)
+}
+
+// Version is the expiry of links handed out now, which changes whenever
+// the links do; empty when links are not signed. It is rounded up to a
+// quarter of the TTL so repeated responses hand out the same links and
+// clients can cache both the responses and the images.
+func (s *URLSigner) Version() string {
+	if !s.enabled {
+		return ""
+	}
+	step := s.ttl / 4
+	if step < time.Minute {
+		step = time.Minute
+	}
+	return strconv.FormatInt(time.Now().Add(s.ttl).Truncate(step).Add(step).Unix(), 10)
+}
+
+// Verify checks a link's signature and expiry
+func (s *URLSigner) Verify(key, expires, signature string) error {
+	if !hmac.Equal([]byte(signature), []byte(s.signature(key, expires))) {
+		return ErrInvalidSignature
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if time.Now().Unix() > unix {
+		return ErrLinkExpired
+	}
+	return nil
+}
+
+func (s *URLSigner) signature(key, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignWallpaper replaces the wallpaper's image URLs with the links handed
+// to clients. The variants are copied since the slice may be shared with
+// background processing.
+func (s *URLSigner) SignWallpaper(wallpaper *models.Wallpaper) {
+	if !s.enabled {
+		return
+	}
+	wallpaper.ImageURL = s.URL(wallpaper.ImageURL)
+	wallpaper.ImageThumbURL = s.URL(wallpaper.ImageThumbURL)
+	if wallpaper.ImageMediumURL != nil {
+		medium := s.URL(*wallpaper.ImageMediumURL)
+		wallpaper.ImageMediumURL = &medium
+	}
+	if len(wallpaper.Variants) > 0 {
+		variants := make([]models.WallpaperVariant, len(wallpaper.Variants))
+		for i, v := range wallpaper.Variants {
+			v.URL = s.URL(v.URL)
+			variants[i] = v
+		}
+		wallpaper.Variants = variants
+	}
+	wallpaper.Category.ImageURL = s.URL(wallpaper.Category.ImageURL)
+}
+
+func (s *URLSigner) SignWallpapers(wallpapers []models.Wallpaper) {
+	for i := range wallpapers {
+		s.SignWallpaper(&wallpapers[i])
+	}
+}
+
+func (s *URLSigner) SignScored(wallpapers []dto.ScoredWallpaper) {
+	for i := range wallpapers {
+		s.SignWallpaper(&wallpapers[i].Wallpaper)
+	}
+}
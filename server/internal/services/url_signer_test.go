@@ -0,0 +1,186 @@
+package services
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"wallpaperio/server/internal/config"
+	"wallpaperio/server/internal/services/storage"
+)
+
+const testPublicURL = "http://localhost:8080"
+
+func newTestSigner(t *testing.T, enabled bool) *URLSigner {
+	t.Helper()
+	store, err := storage.NewLocal(t.TempDir(), testPublicURL, "http://server:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewURLSigner(store, &config.SignedURLConfig{Enabled: enabled, Secret: "secret", TTL: time.Hour}, testPublicURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestNewURLSignerRequiresSecret(t *testing.T) {
+	_, err := NewURLSigner(nil, &config.SignedURLConfig{Enabled: true, TTL: time.Hour}, testPublicURL)
+	if err == nil {
+		t.Fatal("NewURLSigner() without a secret succeeded")
+	}
+}
+
+func TestURLSignerGated(t *testing.T) {
+	signer := newTestSigner(t, true)
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"blobs/ab/abcd.jpg", true},
+		{"categories/abc.jpg", false},
+		{"variants/ab/abcd/thumb_400q85.jpg", false},
+		{"variants/ab/abcd/thumb_imported.jpg", false},
+		{"variants/ab/abcd/medium_1280q85.jpg", true},
+		{"variants/12/thumb_400q85.jpg", false},
+		{"wallpapers/abc_thumb.jpg", false},
+		{"wallpapers/abc_medium.jpg", true},
+		{"wallpapers/abc.jpg", true},
+		{"downloads/1/1080x1920_crop.jpg", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := signer.Gated(tt.key); got != tt.want {
+				t.Errorf("Gated(%q) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+
+	if disabled := newTestSigner(t, false); disabled.Gated("blobs/ab/abcd.jpg") {
+		t.Error("Gated() is true with signing disabled")
+	}
+}
+
+func TestURLSignerURL(t *testing.T) {
+	signer := newTestSigner(t, true)
+	media := testPublicURL + storage.LocalRoute
+	tests := []struct {
+		name       string
+		url        string
+		wantPrefix string
+		wantSigned bool
+	}{
+		{"gated image", media + "/blobs/ab/abcd.jpg", media + "/blobs/ab/abcd.jpg?expires=", true},
+		{"thumb", media + "/variants/ab/abcd/thumb_400q85.jpg", media + "/variants/ab/abcd/thumb_400q85.jpg", false},
+		{"outside storage", "https://example.com/a.jpg", "https://example.com/a.jpg", false},
+		{"empty", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := signer.URL(tt.url)
+			if !strings.HasPrefix(got, tt.wantPrefix) {
+				t.Fatalf("URL() = %q, want prefix %q", got, tt.wantPrefix)
+			}
+			if signed := strings.Contains(got, "signature="); signed != tt.wantSigned {
+				t.Fatalf("URL() = %q, signed %v, want %v", got, signed, tt.wantSigned)
+			}
+			if !tt.wantSigned {
+				if got != tt.url {
+					t.Errorf("URL() = %q, want it unchanged", got)
+				}
+				return
+			}
+
+			// The link must verify for its own key
+			link, err := url.Parse(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key := strings.TrimPrefix(link.Path, storage.LocalRoute+"/")
+			query := link.Query()
+			if err := signer.Verify(key, query.Get("expires"), query.Get("signature")); err != nil {
+				t.Errorf("Verify() of a fresh link failed: %v", err)
+			}
+		})
+	}
+
+	if disabled := newTestSigner(t, false); disabled.URL(media+"/blobs/ab/abcd.jpg") != media+"/blobs/ab/abcd.jpg" {
+		t.Error("URL() changed the link with signing disabled")
+	}
+}
+
+func TestURLSignerVerify(t *testing.T) {
+	signer := newTestSigner(t, true)
+	const key = "blobs/ab/abcd.jpg"
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		key       string
+		expires   string
+		signature string
+		want      error
+	}{
+		{"valid", key, future, signer.signature(key, future), nil},
+		{"expired", key, past, signer.signature(key, past), ErrLinkExpired},
+		{"other key", "blobs/ab/other.jpg", future, signer.signature(key, future), ErrInvalidSignature},
+		{"extended expiry", key, past, signer.signature(key, future), ErrInvalidSignature},
+		{"missing signature", key, future, "", ErrInvalidSignature},
+		{"not a timestamp", key, "soon", signer.signature(key, "soon"), ErrInvalidSignature},
+		{"other secret", key, future, newOtherSecretSigner(t).signature(key, future), ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := signer.Verify(tt.key, tt.expires, tt.signature); !errors.Is(err, tt.want) {
+				t.Errorf("Verify() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func newOtherSecretSigner(t *testing.T) *URLSigner {
+	t.Helper()
+	signer, err := NewURLSigner(nil, &config.SignedURLConfig{Enabled: true, Secret: "other", TTL: time.Hour}, testPublicURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestURLSignerVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+	}{
+		{"hour", time.Hour},
+		{"short ttl rounds by the minute", 30 * time.Second},
+		{"day", 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer := &URLSigner{enabled: true, secret: []byte("secret"), ttl: tt.ttl}
+			now := time.Now()
+			version := signer.Version()
+			expires, err := strconv.ParseInt(version, 10, 64)
+			if err != nil {
+				t.Fatalf("Version() = %q, not a timestamp", version)
+			}
+			// Links live at least the TTL and at most a rounding step more
+			step := max(tt.ttl/4, time.Minute)
+			if earliest := now.Add(tt.ttl).Unix(); expires < earliest {
+				t.Errorf("Version() expires at %d, before %d", expires, earliest)
+			}
+			if latest := time.Now().Add(tt.ttl + step).Unix(); expires > latest {
+				t.Errorf("Version() expires at %d, after %d", expires, latest)
+			}
+		})
+	}
+
+	if version := (&URLSigner{}).Version(); version != "" {
+		t.Errorf("Version() with signing disabled = %q, want empty", version)
+	}
+}
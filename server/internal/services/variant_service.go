@@ -0,0 +1,408 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"strings"
+
+	"wallpaperio/server/internal/config"
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/domain/models/dto"
+	"wallpaperio/server/internal/services/storage"
+
+	"github.com/gen2brain/avif"
+	"github.com/gen2brain/webp"
+	"golang.org/x/image/draw"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// variantFormat encodes variants in one image format
+type variantFormat struct {
+	ext         string
+	contentType string
+	encode      func(w io.Writer, img image.Image, quality int) error
+}
+
+var variantFormats = map[string]variantFormat{
+	"jpeg": {".jpg", "image/jpeg", func(w io.Writer, img image.Image, quality int) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}},
+	// WebP and AVIF use libwebp and libavif compiled to WebAssembly, so
+	// they need no cgo; a system libwebp or libavif is used if present
+	"webp": {".webp", "image/webp", func(w io.Writer, img image.Image, quality int) error {
+		return webp.Encode(w, img, webp.Options{Quality: quality, Method: 4})
+	}},
+	"avif": {".avif", "image/avif", func(w io.Writer, img image.Image, quality int) error {
+		return avif.Encode(w, img, avif.Options{Quality: quality, QualityAlpha: quality, Speed: 8})
+	}},
+}
+
+// VariantService renders the configured resized copies of wallpaper images
+// into storage and records them as WallpaperVariants. The "thumb" and
+// "medium" variants also become the wallpaper's thumb and medium URLs.
+// Variants are stored under the original's content hash and their
+// settings, so wallpapers with the same original share them.
+type VariantService struct {
+	db       *gorm.DB
+	storage  storage.Storage
+	blobs    *BlobStore
+	fetcher  *ImageFetcher
+	specs    []config.VariantSpec
+	backfill *backfillJob
+}
+
+func NewVariantService(db *gorm.DB, storage storage.Storage, fetcher *ImageFetcher, cfg *config.VariantConfig) *VariantService {
+	var specs []config.VariantSpec
+	for _, spec := range cfg.Specs {
+		if _, ok := variantFormats[spec.Format]; !ok {
+			log.Printf("Skipping variant %s: format %q is not supported", spec.Name, spec.Format)
+			continue
+		}
+		specs = append(specs, spec)
+	}
+	s := &VariantService{
+		db:      db,
+		storage: storage,
+		blobs:   NewBlobStore(db, storage),
+		fetcher: fetcher,
+		specs:   specs,
+	}
+	s.backfill = newBackfillJob("Variant", db, func(db *gorm.DB) *gorm.DB {
+		return db.Preload("Variants")
+	}, s.backfillOne)
+	return s
+}
+
+// RenderAll renders every configured variant of the wallpaper from img
+func (s *VariantService) RenderAll(wallpaper *models.Wallpaper, img image.Image) error {
+	return s.Render(wallpaper, img, s.specs)
+}
+
+// Render stores the given variants of the wallpaper rendered from img,
+// replacing earlier renderings, and updates wallpaper in place
+func (s *VariantService) Render(wallpaper *models.Wallpaper, img image.Image, specs []config.VariantSpec) error {
+	var existing []models.WallpaperVariant
+	if err := s.db.Where("wallpaper_id = ?", wallpaper.ID).Find(&existing).Error; err != nil {
+		return fmt.Errorf("failed to fetch variants: %w", err)
+	}
+	previous := make(map[string]string, len(existing))
+	for _, v := range existing {
+		previous[v.Name+"/"+v.Format] = v.URL
+	}
+
+	for _, spec := range specs {
+		variant, key, err := s.renderVariant(wallpaper, img, spec)
+		if err != nil {
+			return err
+		}
+		err = s.saveVariant(variant, previous[spec.Name+"/"+spec.Format])
+		s.unstore(key, err != nil)
+		if err != nil {
+			return err
+		}
+		setVariant(wallpaper, *variant)
+	}
+
+	return s.updateVariantURLs(wallpaper)
+}
+
+// saveVariant records the variant, moving the reference to its file from
+// the rendering it replaces, if any, and removing that rendering if nothing
+// else uses it
+func (s *VariantService) saveVariant(variant *models.WallpaperVariant, oldURL string) error {
+	var unused string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "wallpaper_id"}, {Name: "name"}, {Name: "format"}},
+			DoUpdates: clause.AssignmentColumns([]string{"max_width", "quality", "width", "height", "bytes", "url", "updated_at"}),
+		}).Create(variant).Error
+		if err != nil {
+			return fmt.Errorf("failed to save variant: %w", err)
+		}
+		if oldURL == variant.URL {
+			return nil
+		}
+		if err := s.blobs.retain(tx, variant.URL); err != nil {
+			return err
+		}
+		if oldURL != "" {
+			unused, _, err = s.blobs.drop(tx, oldURL)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if oldURL != "" && oldURL != variant.URL {
+		s.deleteReplaced(oldURL, unused, false)
+	}
+	return nil
+}
+
+// renderVariant stores the variant of the wallpaper's image and returns it
+// with its storage key, which must be passed to unstore once the variant
+// is saved or has failed to be. A variant already stored for the same
+// original is reused without rendering it again.
+func (s *VariantService) renderVariant(wallpaper *models.Wallpaper, img image.Image, spec config.VariantSpec) (*models.WallpaperVariant, string, error) {
+	format := variantFormats[spec.Format]
+	render := func() ([]byte, error) {
+		var buf bytes.Buffer
+		if err := format.encode(&buf, scaleToWidth(img, spec.Width), spec.Quality); err != nil {
+			return nil, fmt.Errorf("failed to encode %s variant: %w", spec.Name, err)
+		}
+		return buf.Bytes(), nil
+	}
+
+	// The settings are part of the key so a re-rendered variant never
+	// reuses the URL of an older rendering
+	name := fmt.Sprintf("%s_%dq%d", spec.Name, spec.Width, spec.Quality)
+	var key string
+	var size int64
+	if ValidContentHash(wallpaper.ContentHash) {
+		var err error
+		key, size, err = s.blobs.PutDerived(wallpaper.ContentHash, name, format.ext, format.contentType, render)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to store %s variant: %w", spec.Name, err)
+		}
+	} else {
+		// Without the original's hash the variant is the wallpaper's own
+		data, err := render()
+		if err != nil {
+			return nil, "", err
+		}
+		key = fmt.Sprintf("variants/%d/%s%s", wallpaper.ID, name, format.ext)
+		size = int64(len(data))
+		if err := s.storage.Put(key, bytes.NewReader(data), format.contentType); err != nil {
+			return nil, "", fmt.Errorf("failed to store %s variant: %w", spec.Name, err)
+		}
+	}
+
+	width, height := scaledSize(img.Bounds(), spec.Width)
+	return &models.WallpaperVariant{
+		WallpaperID: wallpaper.ID,
+		Name:        spec.Name,
+		Format:      spec.Format,
+		MaxWidth:    spec.Width,
+		Quality:     spec.Quality,
+		Width:       width,
+		Height:      height,
+		Bytes:       size,
+		URL:         s.storage.URL(key),
+	}, key, nil
+}
+
+// unstore releases a stored variant file once its row is saved, or removes
+// it if saving failed and nothing else uses it
+func (s *VariantService) unstore(key string, failed bool) {
+	if _, ok := blobHash(key); ok {
+		s.blobs.unpin(key)
+	} else if failed {
+		s.deleteKeys([]string{key})
+	}
+}
+
+// setVariant replaces the wallpaper's variant with the same name and format
+func setVariant(wallpaper *models.Wallpaper, variant models.WallpaperVariant) {
+	for i, v := range wallpaper.Variants {
+		if v.Name == variant.Name && v.Format == variant.Format {
+			wallpaper.Variants[i] = variant
+			return
+		}
+	}
+	wallpaper.Variants = append(wallpaper.Variants, variant)
+}
+
+// updateVariantURLs points the wallpaper's thumb and medium URLs at its
+// variants of that name, preferring JPEG which every client can display
+func (s *VariantService) updateVariantURLs(wallpaper *models.Wallpaper) error {
+	updates := make(map[string]interface{})
+	var replaced []string
+	if url := preferredVariantURL(wallpaper.Variants, "thumb"); url != "" && url != wallpaper.ImageThumbURL {
+		replaced = append(replaced, wallpaper.ImageThumbURL)
+		wallpaper.ImageThumbURL = url
+		updates["image_thumb_url"] = url
+	}
+	if url := preferredVariantURL(wallpaper.Variants, "medium"); url != "" && (wallpaper.ImageMediumURL == nil || url != *wallpaper.ImageMediumURL) {
+		if wallpaper.ImageMediumURL != nil {
+			replaced = append(replaced, *wallpaper.ImageMediumURL)
+		}
+		wallpaper.ImageMediumURL = &url
+		updates["image_medium_url"] = url
+	}
+	if len(updates) == 0 {
+		// The variants changed even if these URLs did not
+		if err := touchWallpapers(s.db, []uint{wallpaper.ID}); err != nil {
+			return fmt.Errorf("failed to update wallpaper: %w", err)
+		}
+		return nil
+	}
+
+	unused := make(map[string]string, len(replaced))
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Wallpaper{}).Where("id = ?", wallpaper.ID).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update wallpaper: %w", err)
+		}
+		for _, url := range updates {
+			if err := s.blobs.retain(tx, url.(string)); err != nil {
+				return err
+			}
+		}
+		for _, url := range replaced {
+			key, _, err := s.blobs.drop(tx, url)
+			if err != nil {
+				return err
+			}
+			unused[url] = key
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, url := range replaced {
+		s.deleteReplaced(url, unused[url], true)
+	}
+	return nil
+}
+
+func preferredVariantURL(variants []models.WallpaperVariant, name string) string {
+	url := ""
+	for _, v := range variants {
+		if v.Name != name {
+			continue
+		}
+		if v.Format == "jpeg" {
+			return v.URL
+		}
+		if url == "" {
+			url = v.URL
+		}
+	}
+	return url
+}
+
+// deleteReplaced removes an image that a variant replaced. Blobs are
+// removed if BlobStore.drop returned unusedKey for them. Other images in
+// storage are deleted, except that a variant replaced as the thumb or
+// medium image stays with its own row.
+func (s *VariantService) deleteReplaced(url, unusedKey string, asImage bool) {
+	key, ok := s.storage.KeyForURL(url)
+	if !ok {
+		return
+	}
+	if _, blob := blobHash(key); blob {
+		if unusedKey != "" {
+			s.blobs.remove(unusedKey)
+		}
+		return
+	}
+	if asImage && strings.HasPrefix(key, derivedKeyPrefix) {
+		return
+	}
+	s.deleteKeys([]string{key})
+}
+
+func (s *VariantService) deleteKeys(keys []string) {
+	for _, key := range keys {
+		if err := s.storage.Delete(key); err != nil {
+			log.Printf("Failed to delete %s: %v", key, err)
+		}
+	}
+}
+
+// Report returns the configured variants and the latest backfill's progress
+func (s *VariantService) Report() *dto.VariantReport {
+	report := &dto.VariantReport{Backfill: s.Status()}
+	for _, spec := range s.specs {
+		report.Specs = append(report.Specs, dto.VariantSpec{
+			Name:    spec.Name,
+			Width:   spec.Width,
+			Format:  spec.Format,
+			Quality: spec.Quality,
+		})
+	}
+	return report
+}
+
+func (s *VariantService) Status() dto.BackfillStatus {
+	return s.backfill.Status()
+}
+
+// StartBackfill renders missing and outdated variants of every wallpaper in
+// the background
+func (s *VariantService) StartBackfill() (dto.BackfillStatus, error) {
+	return s.backfill.Start()
+}
+
+// Backfill renders missing and outdated variants and waits for the result
+func (s *VariantService) Backfill() (dto.BackfillStatus, error) {
+	return s.backfill.Run()
+}
+
+func (s *VariantService) backfillOne(wallpaper *models.Wallpaper) (bool, error) {
+	missing := s.outdatedSpecs(wallpaper.Variants)
+	if len(missing) == 0 {
+		return false, nil
+	}
+
+	img, err := s.fetcher.Decode(wallpaper.ImageURL)
+	if err != nil {
+		return false, err
+	}
+	if err := s.Render(wallpaper, img, missing); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// outdatedSpecs returns the configured variants that are missing or were
+// rendered with other settings
+func (s *VariantService) outdatedSpecs(variants []models.WallpaperVariant) []config.VariantSpec {
+	var outdated []config.VariantSpec
+	for _, spec := range s.specs {
+		current := false
+		for _, v := range variants {
+			if v.Name == spec.Name && v.Format == spec.Format && v.MaxWidth == spec.Width && v.Quality == spec.Quality {
+				current = true
+				break
+			}
+		}
+		if !current {
+			outdated = append(outdated, spec)
+		}
+	}
+	return outdated
+}
+
+// scaleToWidth scales the image down to the given width, keeping its aspect
+// ratio. Smaller images are returned as they are.
+func scaleToWidth(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= width {
+		return img
+	}
+	width, height := scaledSize(bounds, width)
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+	return scaled
+}
+
+// scaledSize returns the size scaleToWidth scales an image with the given
+// bounds to
+func scaledSize(bounds image.Rectangle, width int) (int, int) {
+	if bounds.Dx() <= width {
+		return bounds.Dx(), bounds.Dy()
+	}
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+	return width, height
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"wallpaperio/server/internal/config"
+	schema "wallpaperio/server/internal/schema/milvus"
+)
+
+const (
+	VectorStoreMilvus = "milvus"
+	VectorStoreMemory = "memory"
+)
+
+// DefaultSimilarityThreshold is the default minimum cosine similarity for a
+// wallpaper to count as similar
+const DefaultSimilarityThreshold = float32(0.5)
+
+// MaxSearchWindow is the largest limit plus offset Milvus accepts for a
+// search (its topK limit)
+const MaxSearchWindow = 16384
+
+// VectorMatch is a stored vector returned by a similarity search
+type VectorMatch struct {
+	FeatureID int64
+	Score     float32
+}
+
+// SearchOptions narrows a similarity search
+type SearchOptions struct {
+	Limit  int
+	Offset int
+	// ExcludeID leaves one vector out, typically the query's own
+	ExcludeID int64
+	// MinScore, when set, leaves out vectors scoring at or below it before
+	// Limit and Offset apply
+	MinScore *float32
+	// FeatureIDs restricts the search to these vectors when non-nil. Prefer
+	// Partitions: a store may only look for large sets among the
+	// MaxSearchWindow best matches.
+	FeatureIDs []int64
+	// Partitions restricts the search to these partitions when non-empty
+	Partitions []string
+}
+
+// VectorStore stores wallpaper feature vectors and answers similarity queries
+type VectorStore interface {
+	// StoreFeatures stores a feature vector in a partition ("" for the
+	// default one) and returns its feature ID
+	StoreFeatures(features []float32, partition string) (int64, error)
+	// StoreFeaturesBatch stores several vectors in one partition at once and
+	// returns their feature IDs in the same order
+	StoreFeaturesBatch(features [][]float32, partition string) ([]int64, error)
+	// Search returns the vectors most similar to features with their cosine
+	// similarity, best first
+	Search(features []float32, opts SearchOptions) ([]VectorMatch, error)
+	// DeleteFeatures deletes the vector stored under featureID
+	DeleteFeatures(featureID uint) error
+	// GetFeaturesOneWallpaper returns the vector stored under featureID
+	GetFeaturesOneWallpaper(featureID uint) ([]float32, error)
+	// ListFeatureIDs returns the IDs of all stored vectors
+	ListFeatureIDs() ([]int64, error)
+	// ScanFeatures calls fn for every stored vector in ascending ID order,
+	// stopping at the first error fn returns
+	ScanFeatures(fn func(featureID int64, features []float32) error) error
+	// Close releases any resources held by the store
+	Close() error
+}
+
+var (
+	_ VectorStore = (*MilvusService)(nil)
+	_ VectorStore = (*MemoryVectorStore)(nil)
+)
+
+// VectorStoreFactory opens the vector store for one collection of vectors
+// with the given dimension
+type VectorStoreFactory func(collection string, dimension int) (VectorStore, error)
+
+// NewVectorStoreFactory returns a factory for the vector store type selected
+// by the configuration
+func NewVectorStoreFactory(cfg *config.VectorStoreConfig) (VectorStoreFactory, error) {
+	switch cfg.Type {
+	case VectorStoreMilvus, "":
+		return func(collection string, dimension int) (VectorStore, error) {
+			return NewMilvusService(collection, dimension)
+		}, nil
+	case VectorStoreMemory:
+		return func(collection string, dimension int) (VectorStore, error) {
+			return NewMemoryVectorStore(memoryStorePath(cfg.Path, collection), dimension)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown vector store type: %s", cfg.Type)
+	}
+}
+
+// memoryStorePath returns the file used to persist a collection. The default
+// collection uses the configured path as is; other collections get the
+// collection name appended to it.
+func memoryStorePath(base, collection string) string {
+	if base == "" || collection == schema.CollectionName {
+		return base
+	}
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "_" + collection + ext
+}
@@ -0,0 +1,312 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/domain/models/dto"
+	schema "wallpaperio/server/internal/schema/milvus"
+)
+
+const (
+	// MaxBatchSize is the largest number of wallpapers accepted in one batch
+	MaxBatchSize = 100
+	// batchExtractConcurrency bounds parallel calls to the feature extractor
+	batchExtractConcurrency = 4
+)
+
+// batchItem tracks one wallpaper through the batch pipeline
+type batchItem struct {
+	params       dto.CreateWallpaper
+	featureModel string
+	partition    string
+	category     models.Category
+	tags         []models.Tag
+	features     []float32
+	featureID    int64
+	info         *imageInfo
+	imported     *importedImages
+	duplicateOf  *uint
+	// duplicateOfItem is an earlier item of the same batch with identical
+	// or visually similar content, resolved to its wallpaper ID once that
+	// row exists
+	duplicateOfItem *batchItem
+	wallpaperID     uint
+	err             error
+}
+
+// CreateWallpapersBatch creates several wallpapers at once. Features are
+// extracted in parallel, stored with a single vector store insert and the
+// rows are written in one transaction. A failure of one item does not abort
+// the others; the result for every input is returned in input order.
+func (s *WallpaperService) CreateWallpapersBatch(params []dto.CreateWallpaper) ([]dto.BatchCreateResult, error) {
+	items := make([]*batchItem, len(params))
+	for i, p := range params {
+		items[i] = &batchItem{params: p}
+	}
+
+	// Resolve categories and tags up front so failures skip extraction
+	for _, item := range items {
+		if item.params.ImageURL == "" {
+			item.err = fmt.Errorf("image_url is required")
+			continue
+		}
+		if err := s.db.Where("name = ?", item.params.Category).FirstOrCreate(&item.category, models.Category{Name: item.params.Category}).Error; err != nil {
+			item.err = fmt.Errorf("failed to process category: %w", err)
+			continue
+		}
+		tags, err := s.tagSvc.GetOrCreateTags(item.params.Tags)
+		if err != nil {
+			item.err = fmt.Errorf("failed to process tags: %w", err)
+			continue
+		}
+		item.tags = tags
+	}
+
+	// Keep the feature model fixed until all features are stored
+	model, vectors, release := s.featureModels.Acquire()
+	defer release()
+
+	s.extractFeaturesParallel(items, model.Name)
+	s.checkBatchDuplicates(items, vectors, model.Key())
+
+	// Store the extracted vectors with one insert per category partition
+	var pending []*batchItem
+	byPartition := make(map[string][]*batchItem)
+	var partitions []string
+	for _, item := range items {
+		if item.err != nil {
+			continue
+		}
+		pending = append(pending, item)
+		item.partition = schema.PartitionNameFor(item.category.ID)
+		if _, ok := byPartition[item.partition]; !ok {
+			partitions = append(partitions, item.partition)
+		}
+		byPartition[item.partition] = append(byPartition[item.partition], item)
+	}
+	for _, partition := range partitions {
+		group := byPartition[partition]
+		features := make([][]float32, len(group))
+		for i, item := range group {
+			features[i] = item.features
+		}
+		featureIDs, err := vectors.StoreFeaturesBatch(features, partition)
+		if err != nil {
+			deleteBatchFeatures(vectors, pending)
+			s.deleteBatchImages(items)
+			return nil, fmt.Errorf("failed to store features: %w", err)
+		}
+		for i, item := range group {
+			item.featureID = featureIDs[i]
+			item.featureModel = model.Key()
+		}
+	}
+
+	results := make([]dto.BatchCreateResult, len(items))
+	if len(pending) > 0 {
+		if err := s.createBatchRows(items, results); err != nil {
+			deleteBatchFeatures(vectors, pending)
+			s.deleteBatchImages(items)
+			return nil, err
+		}
+	}
+
+	var orphaned []*batchItem
+	for i, item := range items {
+		results[i].Index = i
+		results[i].ImageURL = item.params.ImageURL
+		if item.err != nil {
+			results[i].Error = item.err.Error()
+			orphaned = append(orphaned, item)
+			continue
+		}
+		results[i].Success = true
+		if item.imported != nil {
+			s.unpinImports(item.imported)
+		}
+		s.processImageAsync(*results[i].Wallpaper)
+	}
+	deleteBatchFeatures(vectors, orphaned)
+	s.deleteBatchImages(orphaned)
+
+	return results, nil
+}
+
+// extractFeaturesParallel fills in features for every item without an error,
+// calling the feature extractor with bounded concurrency
+func (s *WallpaperService) extractFeaturesParallel(items []*batchItem, model string) {
+	sem := make(chan struct{}, batchExtractConcurrency)
+	var wg sync.WaitGroup
+	for _, item := range items {
+		if item.err != nil {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(item *batchItem) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			imported, err := s.importImages(&item.params)
+			if err != nil {
+				item.err = fmt.Errorf("failed to import images: %w", err)
+				return
+			}
+			item.imported = imported
+			features, err := s.featureSvc.ExtractFeatures(imported.sourceURL, model)
+			if err != nil {
+				item.err = fmt.Errorf("failed to extract features: %w", err)
+				return
+			}
+			item.features = features
+			item.info = imported.info
+			if item.info == nil {
+				item.info = s.inspect(imported.sourceURL)
+			}
+		}(item)
+	}
+	wg.Wait()
+}
+
+// checkBatchDuplicates applies duplicate handling to every extracted item,
+// against the catalogue and against earlier items of the batch, which
+// are not stored yet: by content hash and by feature similarity with the
+// same threshold as for stored wallpapers
+func (s *WallpaperService) checkBatchDuplicates(items []*batchItem, vectors VectorStore, featureModel string) {
+	seen := make(map[string]*batchItem)
+	// originals are the earlier items kept that duplicate no other item
+	var originals []*batchItem
+	for _, item := range items {
+		if item.err != nil {
+			continue
+		}
+		mode := s.duplicateSvc.Mode(item.params.OnDuplicate)
+
+		if first, ok := seen[item.info.contentHash]; ok {
+			switch mode {
+			case DuplicateModeReject:
+				item.err = fmt.Errorf("duplicate of batch item %s (identical content)", first.params.ImageURL)
+				continue
+			case DuplicateModeFlag:
+				item.duplicateOfItem = first
+				continue
+			}
+		}
+
+		duplicateOf, err := s.checkDuplicate(item.params.OnDuplicate, item.info.contentHash, item.features, vectors, featureModel)
+		if err != nil {
+			item.err = err
+			continue
+		}
+		item.duplicateOf = duplicateOf
+
+		if duplicateOf == nil && mode != DuplicateModeOff {
+			if first, score := s.similarBatchItem(item, originals); first != nil {
+				if mode == DuplicateModeReject {
+					item.err = fmt.Errorf("duplicate of batch item %s (visually similar, score %.3f)", first.params.ImageURL, score)
+					continue
+				}
+				item.duplicateOfItem = first
+			}
+		}
+
+		if item.info.contentHash != "" {
+			if _, ok := seen[item.info.contentHash]; !ok {
+				seen[item.info.contentHash] = item
+			}
+		}
+		if item.duplicateOfItem == nil {
+			originals = append(originals, item)
+		}
+	}
+}
+
+// similarBatchItem returns the candidate most similar to the item if the
+// similarity reaches the duplicate threshold, and the similarity
+func (s *WallpaperService) similarBatchItem(item *batchItem, candidates []*batchItem) (*batchItem, float32) {
+	var best *batchItem
+	var bestScore float32
+	for _, candidate := range candidates {
+		score, similar := s.duplicateSvc.Similar(item.features, candidate.features)
+		if similar && (best == nil || score > bestScore) {
+			best, bestScore = candidate, score
+		}
+	}
+	return best, bestScore
+}
+
+// createBatchRows inserts the wallpaper rows for all pending items in one
+// transaction, using a savepoint per item so a bad row only fails itself
+func (s *WallpaperService) createBatchRows(items []*batchItem, results []dto.BatchCreateResult) error {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("failed to start transaction: %w", tx.Error)
+	}
+
+	for i, item := range items {
+		if item.err != nil {
+			continue
+		}
+
+		wallpaper := &models.Wallpaper{
+			ImageURL:         item.params.ImageURL,
+			ImageThumbURL:    item.params.ImageThumbUrl,
+			ImageMediumURL:   item.params.ImageMediumUrl,
+			CategoryID:       item.category.ID,
+			Tags:             item.tags,
+			FeatureID:        item.featureID,
+			FeatureModel:     item.featureModel,
+			FeaturePartition: item.partition,
+			ContentHash:      item.info.contentHash,
+			DuplicateOfID:    item.duplicateOf,
+		}
+		applyInfo(wallpaper, item.info, item.params)
+		if first := item.duplicateOfItem; first != nil && first.wallpaperID != 0 {
+			wallpaper.DuplicateOfID = &first.wallpaperID
+		}
+
+		savepoint := fmt.Sprintf("batch_item_%d", i)
+		tx.SavePoint(savepoint)
+		if err := tx.Create(wallpaper).Error; err != nil {
+			tx.RollbackTo(savepoint)
+			item.err = fmt.Errorf("failed to create wallpaper record: %w", err)
+			continue
+		}
+		if err := s.blobs.acquire(tx, wallpaper); err != nil {
+			tx.RollbackTo(savepoint)
+			item.err = err
+			continue
+		}
+		item.wallpaperID = wallpaper.ID
+		results[i].Wallpaper = wallpaper
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// deleteBatchFeatures removes vectors whose wallpaper rows were not created
+func deleteBatchFeatures(vectors VectorStore, items []*batchItem) {
+	for _, item := range items {
+		if item.featureID == 0 {
+			continue
+		}
+		if err := vectors.DeleteFeatures(uint(item.featureID)); err != nil {
+			log.Printf("Failed to delete orphaned features %d: %v", item.featureID, err)
+		}
+	}
+}
+
+// deleteBatchImages removes the images imported for items whose wallpaper
+// rows were not created
+func (s *WallpaperService) deleteBatchImages(items []*batchItem) {
+	for _, item := range items {
+		if item.imported != nil {
+			s.discardImports(item.imported)
+		}
+	}
+}
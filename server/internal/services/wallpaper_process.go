@@ -0,0 +1,42 @@
+package services
+
+import (
+	"fmt"
+	"image"
+	"log"
+
+	"wallpaperio/server/internal/domain/models"
+)
+
+// processConcurrency bounds new wallpapers processed in the background
+const processConcurrency = 2
+
+// processImage renders the variants and extracts the palette of a new
+// wallpaper from its decoded image. Only a failure to render the variants is
+// returned; a missing palette is left for the backfill.
+func (s *WallpaperService) processImage(wallpaper *models.Wallpaper, img image.Image) error {
+	if err := s.variantSvc.RenderAll(wallpaper, img); err != nil {
+		return fmt.Errorf("failed to render variants: %w", err)
+	}
+	if err := s.colorSvc.SavePalette(wallpaper, img); err != nil {
+		log.Printf("Failed to extract palette of wallpaper %d: %v", wallpaper.ID, err)
+	}
+	return nil
+}
+
+// processImageAsync processes a new wallpaper in the background. Failures
+// are logged and left for the backfills.
+func (s *WallpaperService) processImageAsync(wallpaper models.Wallpaper) {
+	go func() {
+		s.processSlots <- struct{}{}
+		defer func() { <-s.processSlots }()
+
+		img, err := s.fetcher.Decode(wallpaper.ImageURL)
+		if err == nil {
+			err = s.processImage(&wallpaper, img)
+		}
+		if err != nil {
+			log.Printf("Failed to process wallpaper %d: %v", wallpaper.ID, err)
+		}
+	}()
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"wallpaperio/server/internal/domain/models"
+	"wallpaperio/server/internal/domain/models/dto"
+)
+
+const (
+	// recommendationFavorites is how many recent favorites make up the taste
+	// vector
+	recommendationFavorites = 50
+	// recommendationHalfLife is the age at which a favorite counts half as
+	// much towards the taste vector as a new one
+	recommendationHalfLife = 30 * 24 * time.Hour
+	// recommendationExploreEvery places a popular wallpaper outside the
+	// user's taste at every n-th position of the feed
+	recommendationExploreEvery = 5
+	// recommendationExplorePool is how many popular wallpapers per explore
+	// slot are sampled from
+	recommendationExplorePool = 4
+)
+
+// favoriteFeature is a favorited wallpaper's vector reference
+type favoriteFeature struct {
+	WallpaperID uint
+	FeatureID   int64
+	CreatedAt   time.Time
+}
+
+// GetRecommendedWallpapers returns a feed for the user built from the
+// wallpapers nearest to their taste vector, the recency-weighted mean of
+// their favorites' vectors. Favorites are left out, no category may take
+// over the feed, and popular wallpapers are mixed in to keep it varied.
+// Users without favorites get the most popular wallpapers.
+func (s *WallpaperService) GetRecommendedWallpapers(userID uint, limit int) ([]dto.ScoredWallpaper, error) {
+	model, vectors := s.featureModels.Active()
+	limit = min(limit, MaxSearchWindow)
+
+	var favorites []favoriteFeature
+	err := s.db.Table("wallpaper_favorites").
+		Select("wallpaper_favorites.wallpaper_id, wallpapers.feature_id, wallpaper_favorites.created_at").
+		Joins("JOIN wallpapers ON wallpapers.id = wallpaper_favorites.wallpaper_id").
+		Where("wallpaper_favorites.user_id = ? AND wallpapers.feature_model = ?", userID, model.Key()).
+		Order("wallpaper_favorites.created_at DESC").
+		Limit(recommendationFavorites).
+		Scan(&favorites).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch favorites: %w", err)
+	}
+
+	taste := tasteVector(favorites, vectors, time.Now())
+	if taste == nil {
+		return s.popularWallpapers(nil, limit)
+	}
+
+	var favoriteIDs []uint
+	if err := s.db.Model(&models.WallpaperFavorite{}).Where("user_id = ?", userID).Pluck("wallpaper_id", &favoriteIDs).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch favorites: %w", err)
+	}
+	exclude := make(map[uint]bool, len(favoriteIDs))
+	for _, id := range favoriteIDs {
+		exclude[id] = true
+	}
+
+	// Over-fetch so there is room to drop favorites and cap categories, within
+	// Milvus' topK limit; favorites are left out below
+	window := min(limit*3+len(favoriteIDs), MaxSearchWindow)
+	matches, err := vectors.Search(taste, SearchOptions{Limit: window})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search recommendations: %w", err)
+	}
+	candidates, err := s.wallpapersForMatches(matches, -1, model.Key())
+	if err != nil {
+		return nil, err
+	}
+
+	var unseen []dto.ScoredWallpaper
+	for _, w := range candidates {
+		if !exclude[w.ID] && w.DuplicateOfID == nil {
+			unseen = append(unseen, w)
+		}
+	}
+
+	personal := diversify(unseen, limit-limit/recommendationExploreEvery)
+	shown := favoriteIDs
+	for _, w := range personal {
+		shown = append(shown, w.ID)
+	}
+	explore, err := s.explorationWallpapers(shown, limit-len(personal))
+	if err != nil {
+		return nil, err
+	}
+
+	return interleave(personal, explore, recommendationExploreEvery), nil
+}
+
+// tasteVector returns the normalised, recency-weighted sum of the favorites'
+// vectors, or nil if none of them could be loaded
+func tasteVector(favorites []favoriteFeature, vectors VectorStore, now time.Time) []float32 {
+	var taste []float32
+	for _, f := range favorites {
+		features, err := vectors.GetFeaturesOneWallpaper(uint(f.FeatureID))
+		if err != nil {
+			continue
+		}
+		normalize(features)
+
+		age := now.Sub(f.CreatedAt)
+		weight := float32(math.Pow(0.5, float64(age)/float64(recommendationHalfLife)))
+		if taste == nil {
+			taste = make([]float32, len(features))
+		}
+		for i, x := range features {
+			taste[i] += weight * x
+		}
+	}
+	if taste != nil {
+		normalize(taste)
+	}
+	return taste
+}
+
+// diversify picks up to n wallpapers in score order while no category takes
+// more than a third of them, topping up with the skipped ones if needed
+func diversify(candidates []dto.ScoredWallpaper, n int) []dto.ScoredWallpaper {
+	perCategory := n / 3
+	if perCategory < 2 {
+		perCategory = 2
+	}
+
+	picked := make([]dto.ScoredWallpaper, 0, n)
+	var skipped []dto.ScoredWallpaper
+	counts := make(map[uint]int)
+	for _, w := range candidates {
+		if len(picked) == n {
+			break
+		}
+		if counts[w.CategoryID] >= perCategory {
+			skipped = append(skipped, w)
+			continue
+		}
+		counts[w.CategoryID]++
+		picked = append(picked, w)
+	}
+	for _, w := range skipped {
+		if len(picked) == n {
+			break
+		}
+		picked = append(picked, w)
+	}
+	return picked
+}
+
+// explorationWallpapers returns n wallpapers sampled from the most popular
+// ones not yet shown
+func (s *WallpaperService) explorationWallpapers(shown []uint, n int) ([]dto.ScoredWallpaper, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	pool, err := s.popularWallpapers(shown, n*recommendationExplorePool)
+	if err != nil {
+		return nil, err
+	}
+	rand.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+	if len(pool) > n {
+		pool = pool[:n]
+	}
+	return pool, nil
+}
+
+// popularWallpapers returns the most favorited, then most downloaded
+// wallpapers, leaving out the given ones and flagged duplicates
+func (s *WallpaperService) popularWallpapers(exclude []uint, limit int) ([]dto.ScoredWallpaper, error) {
+	query := s.db.Preload("Tags").Preload("Category").
+		Where("duplicate_of_id IS NULL").
+		Order("(SELECT COUNT(*) FROM wallpaper_favorites WHERE wallpaper_favorites.wallpaper_id = wallpapers.id) DESC").
+		Order("downloads DESC").
+		Order("id DESC").
+		Limit(limit)
+	if len(exclude) > 0 {
+		query = query.Where("id NOT IN ?", exclude)
+	}
+
+	var wallpapers []models.Wallpaper
+	if err := query.Find(&wallpapers).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch popular wallpapers: %w", err)
+	}
+
+	results := make([]dto.ScoredWallpaper, len(wallpapers))
+	for i, w := range wallpapers {
+		results[i] = dto.ScoredWallpaper{Wallpaper: w}
+	}
+	return results, nil
+}
+
+// interleave places an item of extra at every n-th position of main,
+// appending whatever is left of either list
+func interleave(main, extra []dto.ScoredWallpaper, n int) []dto.ScoredWallpaper {
+	result := make([]dto.ScoredWallpaper, 0, len(main)+len(extra))
+	for len(main) > 0 || len(extra) > 0 {
+		if len(extra) > 0 && (len(main) == 0 || (len(result)+1)%n == 0) {
+			result = append(result, extra[0])
+			extra = extra[1:]
+			continue
+		}
+		result = append(result, main[0])
+		main = main[1:]
+	}
+	return result
+}
@@ -1,46 +1,83 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"time"
 
 	"wallpaperio/server/internal/domain/models"
 	"wallpaperio/server/internal/domain/models/dto"
+	schema "wallpaperio/server/internal/schema/milvus"
+	"wallpaperio/server/internal/services/storage"
 
 	"gorm.io/gorm"
 )
 
+var ErrSemanticSearchUnsupported = errors.New("active feature model does not support text search")
+
 type WallpaperService struct {
-	db         *gorm.DB
-	tagSvc     *TagService
-	featureSvc *FeatureService
-	milvusSvc  *MilvusService
+	db            *gorm.DB
+	tagSvc        *TagService
+	featureSvc    *FeatureService
+	featureModels *FeatureModelService
+	duplicateSvc  *DuplicateService
+	variantSvc    *VariantService
+	colorSvc      *ColorService
+	storage       storage.Storage
+	fetcher       *ImageFetcher
+	blobs         *BlobStore
+	processSlots  chan struct{}
 }
 
 func (s *WallpaperService) GetWallpaperByID(id uint) (*models.Wallpaper, error) {
 	var wallpaper models.Wallpaper
-	err := s.db.Preload("Tags").Preload("Category").First(&wallpaper, id).Error
+	err := s.db.Preload("Tags").Preload("Category").Preload("Variants").
+		Preload("Colors", func(db *gorm.DB) *gorm.DB { return db.Order("rank") }).
+		First(&wallpaper, id).Error
 	if err != nil {
 		return nil, err
 	}
 	return &wallpaper, nil
 }
 
-func NewWallpaperService(db *gorm.DB, tagSvc *TagService, featureSvc *FeatureService) (*WallpaperService, error) {
-	milvusSvc, err := NewMilvusService()
-	if err != nil {
-		println("Failed to create Milvus service:", err.Error())
-		return nil, fmt.Errorf("failed to create Milvus service: %w", err)
-	}
-
+func NewWallpaperService(db *gorm.DB, tagSvc *TagService, featureSvc *FeatureService, featureModels *FeatureModelService, duplicateSvc *DuplicateService, variantSvc *VariantService, colorSvc *ColorService, storage storage.Storage, fetcher *ImageFetcher) *WallpaperService {
 	return &WallpaperService{
-		db:         db,
-		tagSvc:     tagSvc,
-		featureSvc: featureSvc,
-		milvusSvc:  milvusSvc,
-	}, nil
+		db:            db,
+		tagSvc:        tagSvc,
+		featureSvc:    featureSvc,
+		featureModels: featureModels,
+		duplicateSvc:  duplicateSvc,
+		variantSvc:    variantSvc,
+		colorSvc:      colorSvc,
+		storage:       storage,
+		fetcher:       fetcher,
+		blobs:         NewBlobStore(db, storage),
+		processSlots:  make(chan struct{}, processConcurrency),
+	}
 }
 
+// CreateWallpaper creates a wallpaper from images the generator produced,
+// copying those left in the generator's static directory into storage. Its
+// variants and palette are computed in the background.
 func (s *WallpaperService) CreateWallpaper(params dto.CreateWallpaper) (*models.Wallpaper, error) {
+	imported, err := s.importImages(&params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import images: %w", err)
+	}
+	wallpaper, err := s.createWallpaper(params, imported.sourceURL, imported.info)
+	if err != nil {
+		s.discardImports(imported)
+		return nil, err
+	}
+	s.unpinImports(imported)
+	s.processImageAsync(*wallpaper)
+	return wallpaper, nil
+}
+
+// createWallpaper creates a wallpaper whose image the server reads from
+// sourceURL. info is read from the image when nil.
+func (s *WallpaperService) createWallpaper(params dto.CreateWallpaper, sourceURL string, info *imageInfo) (*models.Wallpaper, error) {
 	// Get or create category
 	var category models.Category
 	if err := s.db.Where("name = ?", params.Category).FirstOrCreate(&category, models.Category{Name: params.Category}).Error; err != nil {
@@ -53,37 +90,62 @@ func (s *WallpaperService) CreateWallpaper(params dto.CreateWallpaper) (*models.
 		return nil, fmt.Errorf("failed to process tags: %w", err)
 	}
 
+	if info == nil {
+		info = s.inspect(sourceURL)
+	}
+
+	// Keep the feature model fixed until the features are stored
+	model, vectors, release := s.featureModels.Acquire()
+	defer release()
+
 	// Extract features from image
-	features, err := s.featureSvc.ExtractFeatures(params.ImageURL)
+	features, err := s.featureSvc.ExtractFeatures(sourceURL, model.Name)
 	if err != nil {
 		return nil, fmt.Errorf("failed to extract features: %w", err)
 	}
 
+	duplicateOf, err := s.checkDuplicate(params.OnDuplicate, info.contentHash, features, vectors, model.Key())
+	if err != nil {
+		return nil, err
+	}
+
 	tx := s.db.Begin()
 	if tx.Error != nil {
 		return nil, fmt.Errorf("failed to start transaction: %w", tx.Error)
 	}
 
-	// Store features in Milvus and get feature ID
-	featureID, err := s.milvusSvc.StoreFeatures(features)
+	// Store features in the category's partition and get feature ID
+	partition := schema.PartitionNameFor(category.ID)
+	featureID, err := vectors.StoreFeatures(features, partition)
 	if err != nil {
 		tx.Rollback()
-		return nil, fmt.Errorf("failed to store features in Milvus: %w", err)
+		return nil, fmt.Errorf("failed to store features: %w", err)
 	}
 
 	wallpaper := &models.Wallpaper{
-		ImageURL:       params.ImageURL,
-		ImageThumbURL:  params.ImageThumbUrl,
-		ImageMediumURL: params.ImageMediumUrl,
-		CategoryID:     category.ID,
-		Tags:           tags,
-		FeatureID:      featureID,
+		ImageURL:         params.ImageURL,
+		ImageThumbURL:    params.ImageThumbUrl,
+		ImageMediumURL:   params.ImageMediumUrl,
+		CategoryID:       category.ID,
+		Tags:             tags,
+		FeatureID:        featureID,
+		FeatureModel:     model.Key(),
+		FeaturePartition: partition,
+		ContentHash:      info.contentHash,
+		DuplicateOfID:    duplicateOf,
+		GenerationJobID:  params.GenerationJobID,
+		Prompt:           params.Prompt,
 	}
+	applyInfo(wallpaper, info, params)
 
 	if err := tx.Create(wallpaper).Error; err != nil {
 		tx.Rollback()
 		return nil, fmt.Errorf("failed to create wallpaper record: %w", err)
 	}
+	if err := s.blobs.acquire(tx, wallpaper); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
 
 	if err := tx.Commit().Error; err != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
@@ -92,6 +154,67 @@ func (s *WallpaperService) CreateWallpaper(params dto.CreateWallpaper) (*models.
 	return wallpaper, nil
 }
 
+// inspect reads the image's hash, dimensions and type. If it cannot be
+// fetched the result is empty, so only the visual duplicate check applies
+// and the generator's metadata is used.
+func (s *WallpaperService) inspect(imageURL string) *imageInfo {
+	info, err := s.fetcher.Inspect(imageURL)
+	if err != nil {
+		log.Printf("Failed to inspect %s: %v", imageURL, err)
+		return &imageInfo{}
+	}
+	return info
+}
+
+// applyInfo sets the wallpaper's content metadata, read from the image file
+// where possible and otherwise taken from the generator's request
+func applyInfo(wallpaper *models.Wallpaper, info *imageInfo, params dto.CreateWallpaper) {
+	width, height, bytes, mimeType := info.width, info.height, info.bytes, info.mimeType
+	if width == 0 || height == 0 {
+		width, height = params.Width, params.Height
+	}
+	if bytes == 0 {
+		bytes = params.Bytes
+	}
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = params.MimeType
+	}
+	applyImageMetadata(wallpaper, width, height, bytes, mimeType)
+}
+
+// checkDuplicate applies duplicate handling to an image about to be stored.
+// It returns the wallpaper to flag the image as a duplicate of, or a
+// *DuplicateError when duplicates are rejected.
+func (s *WallpaperService) checkDuplicate(onDuplicate, contentHash string, features []float32, vectors VectorStore, featureModel string) (*uint, error) {
+	mode := s.duplicateSvc.Mode(onDuplicate)
+	if mode == DuplicateModeOff {
+		return nil, nil
+	}
+
+	dup, err := s.duplicateSvc.Find(contentHash, features, vectors, featureModel)
+	if err != nil || dup == nil {
+		return nil, err
+	}
+	if mode == DuplicateModeReject {
+		return nil, dup
+	}
+	return &dup.ExistingID, nil
+}
+
+// GetWallpapersByHash returns the wallpapers whose image has the given
+// SHA-256 hash, oldest first
+func (s *WallpaperService) GetWallpapersByHash(hash string) ([]models.Wallpaper, error) {
+	var wallpapers []models.Wallpaper
+	err := s.db.Preload("Tags").Preload("Category").Preload("Variants").
+		Where("content_hash = ?", hash).
+		Order("id").
+		Find(&wallpapers).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch wallpapers: %w", err)
+	}
+	return wallpapers, nil
+}
+
 // GetWallpapersByTags returns wallpapers that have all the specified tags
 func (s *WallpaperService) GetWallpapersByTags(tags []string) ([]models.Wallpaper, error) {
 	var wallpapers []models.Wallpaper
@@ -153,6 +276,8 @@ func (s *WallpaperService) GetWallpapers(filter dto.WallpaperFilter) (*dto.Wallp
 		query = query.Where("categories.name = ?", filter.Category)
 	}
 
+	query = s.applyImageFilters(query, filter)
+
 	// Group by wallpaper ID to handle duplicates from joins.
 	query = query.Group("wallpapers.id")
 
@@ -168,6 +293,7 @@ func (s *WallpaperService) GetWallpapers(filter dto.WallpaperFilter) (*dto.Wallp
 	err := query.
 		Preload("Tags").
 		Preload("Category").
+		Preload("Variants").
 		Order("id DESC").
 		Limit(filter.Limit).
 		Offset(filter.Offset).
@@ -182,11 +308,46 @@ func (s *WallpaperService) GetWallpapers(filter dto.WallpaperFilter) (*dto.Wallp
 	}, nil
 }
 
-// DeleteWallpaper deletes a wallpaper and its features
+// applyImageFilters restricts the query by the image metadata and colour
+// filters
+func (s *WallpaperService) applyImageFilters(query *gorm.DB, filter dto.WallpaperFilter) *gorm.DB {
+	if filter.MinWidth > 0 {
+		query = query.Where("wallpapers.width >= ?", filter.MinWidth)
+	}
+	if filter.MinHeight > 0 {
+		query = query.Where("wallpapers.height >= ?", filter.MinHeight)
+	}
+	if filter.Orientation != "" {
+		query = query.Where("wallpapers.orientation = ?", filter.Orientation)
+	}
+	if len(filter.AspectRatios) > 0 {
+		ratios := s.db
+		for i, ratio := range filter.AspectRatios {
+			low, high := ratio*(1-aspectRatioTolerance), ratio*(1+aspectRatioTolerance)
+			if i == 0 {
+				ratios = ratios.Where("wallpapers.aspect_ratio BETWEEN ? AND ?", low, high)
+			} else {
+				ratios = ratios.Or("wallpapers.aspect_ratio BETWEEN ? AND ?", low, high)
+			}
+		}
+		query = query.Where(ratios)
+	}
+	if filter.Color != "" {
+		if color, err := ParseHexColor(filter.Color); err == nil {
+			query = query.Where(`EXISTS (SELECT 1 FROM wallpaper_colors wc
+				WHERE wc.wallpaper_id = wallpapers.id AND wc.weight >= ?
+				AND SQRT(POWER(wc.l - ?, 2) + POWER(wc.a - ?, 2) + POWER(wc.b - ?, 2)) <= ?)`,
+				colorMinWeight, color.L, color.A, color.B, filter.ColorDistance)
+		}
+	}
+	return query
+}
+
+// DeleteWallpaper deletes a wallpaper, its features and its stored images
 func (s *WallpaperService) DeleteWallpaper(id uint) error {
-	// Get wallpaper to get its feature ID
+	// Get wallpaper to get its feature ID and images
 	var wallpaper models.Wallpaper
-	if err := s.db.First(&wallpaper, id).Error; err != nil {
+	if err := s.db.Preload("Variants").Preload("Renditions").First(&wallpaper, id).Error; err != nil {
 		return fmt.Errorf("failed to find wallpaper: %w", err)
 	}
 
@@ -202,8 +363,40 @@ func (s *WallpaperService) DeleteWallpaper(id uint) error {
 		return fmt.Errorf("failed to delete wallpaper: %w", err)
 	}
 
-	// Delete features from Milvus using feature ID
-	if err := s.milvusSvc.DeleteFeatures(uint(wallpaper.FeatureID)); err != nil {
+	// Keep the generation job it was published from, without the link
+	if err := tx.Model(&models.GenerationJob{}).Where("wallpaper_id = ?", id).Update("wallpaper_id", nil).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to unlink generation job: %w", err)
+	}
+
+	// Take it out of its visual clusters
+	err := tx.Model(&models.VisualCluster{}).
+		Where("id IN (?)", tx.Model(&models.VisualClusterMember{}).Select("cluster_id").Where("wallpaper_id = ?", id)).
+		UpdateColumn("size", gorm.Expr("size - 1")).Error
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to update visual clusters: %w", err)
+	}
+	if err := tx.Where("wallpaper_id = ?", id).Delete(&models.VisualClusterMember{}).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to delete cluster memberships: %w", err)
+	}
+
+	// The images may be shared with other wallpapers
+	unusedBlobs, err := s.blobs.release(tx, &wallpaper)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Delete features from the vector store using feature ID
+	if err := s.featureModels.Store().DeleteFeatures(uint(wallpaper.FeatureID)); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to delete features: %w", err)
+	}
+
+	// Delete features kept for other feature model versions
+	if err := s.featureModels.DeleteWallpaperFeatures(id); err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to delete features: %w", err)
 	}
@@ -213,6 +406,10 @@ func (s *WallpaperService) DeleteWallpaper(id uint) error {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	s.deleteStoredImages(&wallpaper)
+	for _, key := range unusedBlobs {
+		s.blobs.remove(key)
+	}
 	return nil
 }
 
@@ -264,26 +461,187 @@ func (s *WallpaperService) GetAdjacentWallpaper(filter dto.NextPreviousWallpaper
 	return &wallpaper, nil
 }
 
-func (s *WallpaperService) GetSimilarWallpapers(currWalppaperId uint, limit int) ([]models.Wallpaper, error) {
+// GetSimilarWallpapers returns the wallpapers most similar to the given one,
+// best first, optionally restricted to a category and/or set of tags
+func (s *WallpaperService) GetSimilarWallpapers(currWalppaperId uint, filter dto.SimilarWallpaperFilter) ([]dto.ScoredWallpaper, error) {
 	var currentWallpaper models.Wallpaper
 	if err := s.db.First(&currentWallpaper, currWalppaperId).Error; err != nil {
 		return nil, fmt.Errorf("failed to find wallpaper: %w", err)
 	}
 
-	features, err := s.milvusSvc.GetFeaturesOneWallpaper(uint(currentWallpaper.FeatureID))
+	model, vectors := s.featureModels.Active()
+	var features []float32
+	var err error
+	if currentWallpaper.FeatureModel == model.Key() {
+		features, err = vectors.GetFeaturesOneWallpaper(uint(currentWallpaper.FeatureID))
+	} else {
+		// Its stored vector is from another model, so embed it afresh
+		features, err = s.featureSvc.ExtractFeatures(currentWallpaper.ImageURL, model.Name)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get features: %w", err)
 	}
 
-	similarIDs, err := s.milvusSvc.FindSimilar(features, limit, uint64(currentWallpaper.FeatureID))
+	// Milvus cannot page past its topK limit
+	if filter.Offset >= MaxSearchWindow {
+		return []dto.ScoredWallpaper{}, nil
+	}
+	opts := SearchOptions{
+		Limit:    min(filter.Limit, MaxSearchWindow-filter.Offset),
+		Offset:   filter.Offset,
+		MinScore: &filter.Threshold,
+	}
+	if currentWallpaper.FeatureModel == model.Key() {
+		opts.ExcludeID = currentWallpaper.FeatureID
+	}
+
+	var categoryID *uint
+	if filter.SameCategory {
+		categoryID = &currentWallpaper.CategoryID
+	} else if filter.Category != "" {
+		var category models.Category
+		err := s.db.Where("name = ?", filter.Category).First(&category).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return []dto.ScoredWallpaper{}, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to find category: %w", err)
+		}
+		categoryID = &category.ID
+	}
+
+	// Search only the category's partition when it is up to date, otherwise
+	// fall back to filtering by feature ID
+	if categoryID != nil {
+		partitioned, err := s.categoryPartitioned(*categoryID, model.Key())
+		if err != nil {
+			return nil, err
+		}
+		if partitioned {
+			opts.Partitions = []string{schema.PartitionNameFor(*categoryID)}
+			categoryID = nil
+		}
+	}
+	if categoryID != nil || len(filter.Tags) > 0 {
+		opts.FeatureIDs, err = s.featureIDsMatching(categoryID, filter.Tags)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	matches, err := vectors.Search(features, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find similar wallpapers: %w", err)
 	}
 
-	var similarWallpapers []models.Wallpaper
-	if err := s.db.Where("feature_id IN ?", similarIDs).Find(&similarWallpapers).Error; err != nil {
-		return nil, fmt.Errorf("failed to fetch similar wallpapers: %w", err)
+	return s.wallpapersForMatches(matches, filter.Threshold, model.Key())
+}
+
+// categoryPartitioned reports whether a category's partition holds exactly
+// the vectors of the category's wallpapers. It does not while vectors have
+// not been migrated or after wallpapers changed category.
+func (s *WallpaperService) categoryPartitioned(categoryID uint, featureModel string) (bool, error) {
+	partition := schema.PartitionNameFor(categoryID)
+	var stale bool
+	err := s.db.Raw(`SELECT EXISTS (SELECT 1 FROM wallpapers WHERE feature_model = ? AND feature_id <> 0 AND
+		((category_id = ? AND COALESCE(feature_partition, '') <> ?) OR (category_id <> ? AND feature_partition = ?)))`,
+		featureModel, categoryID, partition, categoryID, partition).Scan(&stale).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check partition: %w", err)
+	}
+	return !stale, nil
+}
+
+// featureIDsMatching returns the feature IDs of wallpapers in the category
+// (if set) that have all of the given tags
+func (s *WallpaperService) featureIDsMatching(categoryID *uint, tags []string) ([]int64, error) {
+	query := s.db.Model(&models.Wallpaper{})
+	if categoryID != nil {
+		query = query.Where("wallpapers.category_id = ?", *categoryID)
 	}
+	if len(tags) > 0 {
+		query = query.
+			Joins("JOIN wallpaper_tags ON wallpaper_tags.wallpaper_id = wallpapers.id").
+			Joins("JOIN tags ON tags.id = wallpaper_tags.tag_id").
+			Where("tags.name IN ?", tags).
+			Group("wallpapers.id").
+			Having("COUNT(DISTINCT tags.id) = ?", len(tags))
+	}
+
+	featureIDs := []int64{}
+	if err := query.Pluck("wallpapers.feature_id", &featureIDs).Error; err != nil {
+		return nil, fmt.Errorf("failed to filter wallpapers: %w", err)
+	}
+	return featureIDs, nil
+}
+
+// SemanticSearch embeds a text query with the active feature model and
+// returns the wallpapers whose image vectors are closest to it, best first
+func (s *WallpaperService) SemanticSearch(query string, limit int, threshold float32) ([]dto.ScoredWallpaper, error) {
+	model, vectors := s.featureModels.Active()
+	limit = min(limit, MaxSearchWindow)
+
+	features, err := s.featureSvc.ExtractTextFeatures(query, model.Name)
+	if errors.Is(err, ErrExtractionUnsupported) {
+		return nil, ErrSemanticSearchUnsupported
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+	if len(features) != model.Dimension {
+		return nil, ErrSemanticSearchUnsupported
+	}
+
+	matches, err := vectors.Search(features, SearchOptions{Limit: limit, MinScore: &threshold})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search wallpapers: %w", err)
+	}
+
+	return s.wallpapersForMatches(matches, threshold, model.Key())
+}
+
+// wallpapersForMatches loads the wallpapers for vector matches scoring above
+// threshold from the given feature model's collection, keeping the order of
+// the matches
+func (s *WallpaperService) wallpapersForMatches(matches []VectorMatch, threshold float32, featureModel string) ([]dto.ScoredWallpaper, error) {
+	scores := make(map[int64]float32, len(matches))
+	var featureIDs []int64
+	for _, m := range matches {
+		if m.Score > threshold {
+			scores[m.FeatureID] = m.Score
+			featureIDs = append(featureIDs, m.FeatureID)
+		}
+	}
+	if len(featureIDs) == 0 {
+		return []dto.ScoredWallpaper{}, nil
+	}
+
+	var wallpapers []models.Wallpaper
+	// Feature IDs are only unique within the model's collection
+	err := s.db.Preload("Tags").Preload("Category").
+		Where("feature_model = ? AND feature_id IN ?", featureModel, featureIDs).
+		Find(&wallpapers).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch wallpapers: %w", err)
+	}
+
+	byFeature := make(map[int64]models.Wallpaper, len(wallpapers))
+	for _, w := range wallpapers {
+		byFeature[w.FeatureID] = w
+	}
+
+	results := make([]dto.ScoredWallpaper, 0, len(featureIDs))
+	for _, id := range featureIDs {
+		if w, ok := byFeature[id]; ok {
+			results = append(results, dto.ScoredWallpaper{Wallpaper: w, Score: scores[id]})
+		}
+	}
+	return results, nil
+}
 
-	return similarWallpapers, nil
+// touchWallpapers bumps updated_at of the wallpapers matched by ids, a list
+// or a subquery, after a change to data returned with them such as their
+// tags or colours, so their cache validators change too
+func touchWallpapers(db *gorm.DB, ids interface{}) error {
+	return db.Model(&models.Wallpaper{}).Where("id IN (?)", ids).UpdateColumn("updated_at", time.Now()).Error
 }
@@ -7,6 +7,14 @@ import (
 	"net/http"
 )
 
+// Task statuses reported by the generator
+const (
+	TaskPending = "pending"
+	TaskStarted = "started"
+	TaskSuccess = "success"
+	TaskFailed  = "failed"
+)
+
 type GenerateRequest struct {
 	Prompt         string  `json:"prompt"`
 	NegativePrompt *string `json:"negative_prompt,omitempty"`
@@ -21,6 +29,7 @@ type TaskStatus struct {
 	UrlPathMedium *string `json:"url_path_medium,omitempty"`
 	UrlPath       string  `json:"url_path"`
 	Status        string  `json:"status"`
+	Progress      *int    `json:"progress,omitempty"`
 	Error         *string `json:"error,omitempty"`
 }
 
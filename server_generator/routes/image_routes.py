@@ -1,8 +1,10 @@
-from fastapi import APIRouter
+from fastapi import APIRouter, HTTPException
 from pydantic import BaseModel
 from typing import Optional
+import base64
 from services.generators.image_generators.generator_factory import GeneratorFactory
 from services.images.image_features import ImageFeatures
+from services.images.clip_features import ClipFeatures
 from celery.result import AsyncResult
 from models.response_model import BaseResponse, FeatureExtractionResponse
 from celery_config import celery_app
@@ -11,6 +13,16 @@ import numpy as np
 
 router = APIRouter(prefix="/images", tags=["images"])
 image_features = ImageFeatures()
+clip_features = ClipFeatures()
+# Feature models by the name the server registers them under; requests
+# without a model get the default one
+DEFAULT_FEATURE_MODEL = "efficientnetv2-b0"
+feature_models = {
+    DEFAULT_FEATURE_MODEL: image_features,
+    "clip-vit-b-32": clip_features,
+}
+# Feature models that can also embed text
+text_feature_models = {"clip-vit-b-32": clip_features}
 
 class ImageRequest(BaseModel):
     prompt: str
@@ -24,6 +36,16 @@ class ImageRequest(BaseModel):
 class ImagePathRequest(BaseModel):
     image_path: str
 
+class TextFeatureRequest(BaseModel):
+    text: str
+    model: Optional[str] = None
+
+class FeatureExtractionRequest(BaseModel):
+    # Either the path or URL of the image, or its base64-encoded bytes
+    image_path: Optional[str] = None
+    image_data: Optional[str] = None
+    model: Optional[str] = None
+
 @router.get("/generators")
 async def get_available_generators():
     return {"generators": GeneratorFactory.get_available_generators()}
@@ -99,13 +121,22 @@ async def get_generation_status(task_id: str):
         )
 
 @router.post("/extract-features", response_model=FeatureExtractionResponse)
-async def extract_features(request: ImagePathRequest):
+async def extract_features(request: FeatureExtractionRequest):
     """
-    Extract features from an image using EfficientNet-B0
+    Extract features from an image with the requested feature model,
+    EfficientNetV2-B0 by default
     """
+    model = feature_models.get(request.model or DEFAULT_FEATURE_MODEL)
+    if model is None:
+        raise HTTPException(status_code=501, detail=f"unknown feature model {request.model!r}")
     try:
         # Extract features
-        features = image_features.extract_features(request.image_path)
+        if request.image_data is not None:
+            features = model.extract_features(image_bytes=base64.b64decode(request.image_data))
+        elif request.image_path:
+            features = model.extract_features(request.image_path)
+        else:
+            raise ValueError("image_path or image_data is required")
         
         return FeatureExtractionResponse(
             status="success",
@@ -124,6 +155,33 @@ async def extract_features(request: ImagePathRequest):
             error_message=str(e)
         )
 
+@router.post("/extract-text-features", response_model=FeatureExtractionResponse)
+async def extract_text_features(request: TextFeatureRequest):
+    """
+    Embed a text query into the image feature space of a CLIP-style model
+    """
+    model = text_feature_models.get(request.model)
+    if model is None:
+        raise HTTPException(status_code=501, detail=f"feature model {request.model!r} cannot embed text")
+    try:
+        features = model.extract_text_features(request.text)
+        return FeatureExtractionResponse(
+            status="success",
+            features=features.tolist(),
+            feature_shape=features.shape,
+            feature_mean=float(features.mean()),
+            feature_std=float(features.std())
+        )
+    except Exception as e:
+        return FeatureExtractionResponse(
+            status="error",
+            features=[],
+            feature_shape=(0,),
+            feature_mean=0.0,
+            feature_std=0.0,
+            error_message=str(e)
+        )
+
 # @router.delete("", response_model=BaseResponse)
 # async def delete_image(request: ImagePathRequest):
 #     try:
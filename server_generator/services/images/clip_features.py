@@ -0,0 +1,55 @@
+import os
+import requests
+import numpy as np
+import torch
+from PIL import Image
+from io import BytesIO
+from transformers import CLIPModel, CLIPProcessor
+
+class ClipFeatures:
+    """CLIP ViT-B/32 embeddings. Images and text land in the same 512-d
+    space, so text queries can be matched against image features."""
+
+    MODEL_ID = "openai/clip-vit-base-patch32"
+
+    def __init__(self):
+        # Loaded on first use so the image-only setup does not pay for it
+        self.model = None
+        self.processor = None
+
+    def _load(self):
+        if self.model is None:
+            self.model = CLIPModel.from_pretrained(self.MODEL_ID).eval()
+            self.processor = CLIPProcessor.from_pretrained(self.MODEL_ID)
+
+    @staticmethod
+    def _normalize(features: torch.Tensor) -> np.ndarray:
+        features = features[0].detach().numpy().astype(np.float32)
+        norm = np.linalg.norm(features)
+        if norm > 0:
+            features = features / norm
+        return features
+
+    def extract_features(self, image_path_url: str = None, image_bytes: bytes = None) -> np.ndarray:
+        """Extract the L2-normalized CLIP embedding of an image, given either
+        as a path or URL, or as its bytes"""
+        self._load()
+        if image_bytes is None:
+            if os.path.exists(image_path_url):
+                with open(image_path_url, "rb") as f:
+                    image_bytes = f.read()
+            else:
+                response = requests.get(image_path_url, timeout=30)
+                response.raise_for_status()
+                image_bytes = response.content
+        img = Image.open(BytesIO(image_bytes)).convert("RGB")
+        with torch.no_grad():
+            inputs = self.processor(images=img, return_tensors="pt")
+            return self._normalize(self.model.get_image_features(**inputs))
+
+    def extract_text_features(self, text: str) -> np.ndarray:
+        """Extract the L2-normalized CLIP embedding of a text query"""
+        self._load()
+        with torch.no_grad():
+            inputs = self.processor(text=[text], return_tensors="pt", padding=True, truncation=True)
+            return self._normalize(self.model.get_text_features(**inputs))
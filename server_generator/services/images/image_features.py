@@ -18,11 +18,14 @@ class ImageFeatures:
             print(f"Error initializing model: {str(e)}")
             raise
 
-    def extract_features(self, image_path_url: str) -> np.ndarray:
-        """Extract L2-normalized features from image using EfficientNetV2"""
+    def extract_features(self, image_path_url: str = None, image_bytes: bytes = None) -> np.ndarray:
+        """Extract L2-normalized features from image using EfficientNetV2.
+        The image is given either as a path or URL, or as its bytes."""
         try:
             # Load and preprocess image
-            if os.path.exists(image_path_url):
+            if image_bytes is not None:
+                img = Image.open(BytesIO(image_bytes)).convert("RGB").resize((224, 224))
+            elif os.path.exists(image_path_url):
                 img = image.load_img(image_path_url, target_size=(224, 224))
             else:
                 response = requests.get(image_path_url, timeout=30)